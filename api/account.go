@@ -0,0 +1,287 @@
+package api
+
+import (
+	"net/url"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/newtoallofthis123/noob_store/types"
+	"github.com/newtoallofthis123/noob_store/utils"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	minPasswordLength = 8
+	resetTokenExpiry  = time.Hour
+	verifyTokenExpiry = 48 * time.Hour
+)
+
+var errWeakPassword = types.Errorf(types.ErrInvalid, "password must be at least 8 characters long")
+
+// hashPassword hashes a new password of a user, which has to be long enough
+func (s *Server) hashPassword(password string) (string, error) {
+	if len(password) < minPasswordLength {
+		return "", errWeakPassword
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), s.env.BcryptCost)
+	if err != nil {
+		return "", err
+	}
+	return string(hash), nil
+}
+
+// rehashPassword hashes the password of a user again once they log in when
+// BCRYPT_COST has changed since it was hashed
+func (s *Server) rehashPassword(user types.User, password string) {
+	cost, err := bcrypt.Cost([]byte(user.Password))
+	if err != nil || cost == s.env.BcryptCost {
+		return
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), s.env.BcryptCost)
+	if err == nil {
+		err = s.db.SetUserPassword(user.Id, string(hash))
+	}
+	if err != nil {
+		s.logger.Error("Unable to rehash password of userId: " + user.Id + " with err: " + err.Error())
+	}
+}
+
+// sendUserToken mails a user a link with a single use token of a kind, only its hash being stored
+func (s *Server) sendUserToken(user types.User, kind string) error {
+	token := utils.RandomToken(32)
+	expiry, path, subject, body := resetTokenExpiry, "/user/reset", "Reset your password",
+		"Someone asked to reset the password of your noob_store account. If it was you, post a new password along with the token to:\n\n"
+	if kind == types.TokenVerify {
+		expiry, path, subject, body = verifyTokenExpiry, "/user/verify", "Verify your email",
+			"Open this link to verify the email of your noob_store account:\n\n"
+	}
+
+	err := s.db.CreateUserToken(utils.CalHash([]byte(token)), user.Id, kind, time.Now().Add(expiry))
+	if err != nil {
+		return err
+	}
+
+	link := s.env.PublicURL + path + "?" + url.Values{"token": {token}}.Encode()
+	return s.mail.Send(user.Email, subject, body+link+"\n\nThe link expires in "+expiry.String()+".")
+}
+
+// handleChangePassword changes the password of the session user, logging out their other sessions
+// and WebDAV clients.
+// Api keys are kept unless revoke_keys is true, the response telling which it was.
+func (s *Server) handleChangePassword(c *gin.Context) {
+	if !s.env.LocalLogin {
+		abort(c, 403, "Local logins are disabled, log in through the identity provider")
+		return
+	}
+
+	authKey := c.GetHeader("Authorization")
+	session, exists := authSession(c)
+	if !exists {
+		s.logger.Error("Unauthorized session: " + authKey)
+		abort(c, 401, "Invalid Authorization or missing session")
+		return
+	}
+
+	user, err := s.db.GetUser(session.UserId)
+	if err != nil {
+		abortErr(c, err, "No valid user found for sessionId "+session.Id)
+		return
+	}
+	// Guessing the current password counts as a failed login, so a stolen session can not brute force it
+	if wait, locked := s.loginLocked(user.Id); locked {
+		tooManyRequests(c, "Account is locked after too many failed logins", wait)
+		return
+	}
+	if bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(c.PostForm("current_password"))) != nil {
+		s.loginFailed(user.Id)
+		abort(c, 401, "Authorization failed")
+		return
+	}
+	s.loginSucceeded(user.Id)
+
+	hash, err := s.hashPassword(c.PostForm("new_password"))
+	if err != nil {
+		abortErr(c, err, err.Error())
+		return
+	}
+	err = s.db.SetUserPassword(user.Id, hash)
+	if err != nil {
+		s.logger.Error("Unable to change password of userId: " + user.Id + " with err: " + err.Error())
+		abortErr(c, err, "Unable to change password")
+		return
+	}
+
+	_, err = s.revokeSessions(user.Id, session.Id)
+	if err != nil {
+		s.logger.Error("Unable to revoke sessions of userId: " + user.Id + " with err: " + err.Error())
+	}
+
+	if c.PostForm("revoke_keys") != "true" {
+		c.JSON(200, gin.H{"success": "Changed password, api keys were kept", "revoked_keys": 0})
+		return
+	}
+	n, err := s.db.DeleteApiKeysByUser(user.Id)
+	if err != nil {
+		s.logger.Error("Unable to revoke api keys of userId: " + user.Id + " with err: " + err.Error())
+		abortErr(c, err, "Changed password but unable to revoke api keys")
+		return
+	}
+
+	c.JSON(200, gin.H{"success": "Changed password and revoked api keys", "revoked_keys": n})
+}
+
+// handleDeleteAccount deletes the session user along with all of their files. Users with a password
+// have to give it, along with a code when two factor login is on.
+func (s *Server) handleDeleteAccount(c *gin.Context) {
+	authKey := c.GetHeader("Authorization")
+	session, exists := authSession(c)
+	if !exists {
+		s.logger.Error("Unauthorized session: " + authKey)
+		abort(c, 401, "Invalid Authorization or missing session")
+		return
+	}
+
+	user, err := s.db.GetUser(session.UserId)
+	if err != nil {
+		abortErr(c, err, "No valid user found for sessionId "+session.Id)
+		return
+	}
+	if user.Password != "" && bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(c.PostForm("password"))) != nil {
+		abort(c, 401, "Authorization failed")
+		return
+	}
+	if totp, err := s.db.GetTOTP(user.Id); err == nil && totp.Enabled {
+		if !s.checkSecondFactor(totp, c.PostForm("code"), c.PostForm("recovery_code")) {
+			abort(c, 401, "Authorization failed")
+			return
+		}
+	}
+
+	err = s.deleteUser(user.Id)
+	if err != nil {
+		s.logger.Error("Unable to delete userId: " + user.Id + " with err: " + err.Error())
+		abortErr(c, err, "Unable to delete account: "+err.Error())
+		return
+	}
+
+	s.logger.Info("Deleted account of userId: " + user.Id)
+	c.JSON(200, gin.H{"success": "Deleted account"})
+}
+
+func (s *Server) handleSendVerification(c *gin.Context) {
+	authKey := c.GetHeader("Authorization")
+	session, exists := authSession(c)
+	if !exists {
+		s.logger.Error("Unauthorized session: " + authKey)
+		abort(c, 401, "Invalid Authorization or missing session")
+		return
+	}
+
+	user, err := s.db.GetUser(session.UserId)
+	if err != nil {
+		abortErr(c, err, "No valid user found for sessionId "+session.Id)
+		return
+	}
+	if user.Verified {
+		abort(c, 409, "Email is already verified")
+		return
+	}
+
+	err = s.sendUserToken(user, types.TokenVerify)
+	if err != nil {
+		s.logger.Error("Unable to send verification mail to userId: " + user.Id + " with err: " + err.Error())
+		abortErr(c, err, "Unable to send verification mail")
+		return
+	}
+
+	c.JSON(200, gin.H{"success": "Sent verification mail"})
+}
+
+func (s *Server) handleVerifyEmail(c *gin.Context) {
+	userId, err := s.db.UseUserToken(utils.CalHash([]byte(c.Query("token"))), types.TokenVerify, time.Now())
+	if err != nil {
+		abortErr(c, err, "Invalid or expired token")
+		return
+	}
+
+	err = s.db.SetEmailVerified(userId)
+	if err != nil {
+		s.logger.Error("Unable to verify email of userId: " + userId + " with err: " + err.Error())
+		abortErr(c, err, "Unable to verify email")
+		return
+	}
+	s.promoteVerified(userId)
+
+	c.JSON(200, gin.H{"success": "Verified email"})
+}
+
+// handleRequestReset mails a password reset token to a user. It answers the same whether
+// or not the email belongs to a user, so it can not be used to find out who has an account.
+func (s *Server) handleRequestReset(c *gin.Context) {
+	if !s.env.LocalLogin {
+		abort(c, 403, "Local logins are disabled, log in through the identity provider")
+		return
+	}
+
+	email, exists := c.GetPostForm("email")
+	if !exists {
+		abort(c, 400, "Email is needed")
+		return
+	}
+
+	user, err := s.db.GetUserByEmail(email)
+	if err == nil && user.Password != "" {
+		err = s.sendUserToken(user, types.TokenReset)
+		if err != nil {
+			s.logger.Error("Unable to send reset mail to userId: " + user.Id + " with err: " + err.Error())
+		}
+	}
+
+	c.JSON(200, gin.H{"success": "If the email has an account, a reset mail is on its way"})
+}
+
+// handleResetPassword sets a new password with a reset token, logging out every session of the user
+// and revoking their api keys
+func (s *Server) handleResetPassword(c *gin.Context) {
+	if !s.env.LocalLogin {
+		abort(c, 403, "Local logins are disabled, log in through the identity provider")
+		return
+	}
+
+	hash, err := s.hashPassword(c.PostForm("password"))
+	if err != nil {
+		abortErr(c, err, err.Error())
+		return
+	}
+
+	userId, err := s.db.UseUserToken(utils.CalHash([]byte(c.PostForm("token"))), types.TokenReset, time.Now())
+	if err != nil {
+		abortErr(c, err, "Invalid or expired token")
+		return
+	}
+
+	err = s.db.SetUserPassword(userId, hash)
+	if err != nil {
+		s.logger.Error("Unable to reset password of userId: " + userId + " with err: " + err.Error())
+		abortErr(c, err, "Unable to reset password")
+		return
+	}
+	// Receiving the mail proves the email as well
+	if s.db.SetEmailVerified(userId) == nil {
+		s.promoteVerified(userId)
+	}
+
+	_, err = s.revokeSessions(userId, "")
+	if err != nil {
+		s.logger.Error("Unable to revoke sessions of userId: " + userId + " with err: " + err.Error())
+	}
+	// Whoever lost the password may have made keys with it too
+	_, err = s.db.DeleteApiKeysByUser(userId)
+	if err != nil {
+		s.logger.Error("Unable to revoke api keys of userId: " + userId + " with err: " + err.Error())
+	}
+
+	c.JSON(200, gin.H{"success": "Reset password and revoked api keys"})
+}
@@ -0,0 +1,380 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/newtoallofthis123/noob_store/types"
+	"github.com/newtoallofthis123/noob_store/utils"
+)
+
+var errSoleGroupOwner = types.Errorf(types.ErrConflict, "user is the only owner of a group")
+
+// audit records an action of the admin of the request in the audit log
+func (s *Server) audit(c *gin.Context, action, target, detail string) {
+	session := c.MustGet(sessionKey).(types.Session)
+
+	err := s.db.InsertAuditEntry(types.AuditEntry{
+		ActorId: session.UserId,
+		Action:  action,
+		Target:  target,
+		Detail:  detail,
+		Ip:      c.ClientIP(),
+	})
+	if err != nil {
+		s.logger.Error("Unable to write audit entry for " + action + " with err: " + err.Error())
+	}
+}
+
+// promoteAdmin makes the user an admin when their email is the ADMIN_EMAIL. The address has to be
+// verified first, as anyone could otherwise sign up with it before its owner does.
+func (s *Server) promoteAdmin(user *types.User) error {
+	if s.env.AdminEmail == "" || !user.Verified || user.Role == types.UserAdmin || !strings.EqualFold(user.Email, s.env.AdminEmail) {
+		return nil
+	}
+
+	err := s.db.SetUserRole(user.Id, types.UserAdmin)
+	if err != nil {
+		return err
+	}
+	user.Role = types.UserAdmin
+	s.logger.Info("Promoted " + user.Email + " to admin")
+	return nil
+}
+
+// promoteVerified promotes the user whose email was just verified, if it is the ADMIN_EMAIL
+func (s *Server) promoteVerified(userId string) {
+	user, err := s.db.GetUser(userId)
+	if err == nil {
+		err = s.promoteAdmin(&user)
+	}
+	if err != nil {
+		s.logger.Error("Unable to promote userId: " + userId + " to admin with err: " + err.Error())
+	}
+}
+
+// deleteUser purges all the files of a user, trashed or not, and then deletes the user.
+// Nothing is deleted if any of the files are locked or the user is the only owner of a group.
+func (s *Server) deleteUser(userId string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	groups, err := s.db.GetGroupsByUser(userId)
+	if err != nil {
+		return err
+	}
+	for _, group := range groups {
+		if group.Role != types.RoleOwner {
+			continue
+		}
+		members, err := s.db.GetMembers(group.Id)
+		if err != nil {
+			return err
+		}
+		owners := 0
+		for _, member := range members {
+			if member.Role == types.RoleOwner {
+				owners++
+			}
+		}
+		if owners <= 1 {
+			return errSoleGroupOwner
+		}
+	}
+
+	files, err := s.db.GetMetadatasByUser(userId)
+	if err != nil {
+		return err
+	}
+	trash, err := s.db.GetTrashByUser(userId)
+	if err != nil {
+		return err
+	}
+	files = append(files, trash...)
+
+	now := time.Now()
+	for _, meta := range files {
+		if meta.Locked(now, false) {
+			return errObjectLocked
+		}
+	}
+
+	_, err = s.revokeSessions(userId, "")
+	if err != nil {
+		return err
+	}
+
+	// The files are purged in the same transaction as the user, so a failure leaves both in place
+	err = s.db.DeleteUserById(userId)
+	if err != nil {
+		return err
+	}
+	for _, meta := range files {
+		_ = s.cache.DeleteMetadata(meta.Id)
+	}
+	return nil
+}
+
+func (s *Server) handleAdminUsers(c *gin.Context) {
+	users, err := s.db.GetUsers()
+	if err != nil {
+		s.logger.Error("Unable to get users with err: " + err.Error())
+		abortErr(c, err, "Unable to get users")
+		return
+	}
+
+	for i := range users {
+		users[i].Password = ""
+	}
+
+	c.JSON(200, users)
+}
+
+func (s *Server) handleAdminDeleteUser(c *gin.Context) {
+	session := c.MustGet(sessionKey).(types.Session)
+
+	id := c.Param("id")
+	if id == session.UserId {
+		abort(c, 403, "Admins can not delete themselves")
+		return
+	}
+	if _, err := s.db.GetUser(id); err != nil {
+		abort(c, 404, "User not found")
+		return
+	}
+
+	err := s.deleteUser(id)
+	if err != nil {
+		s.logger.Error("Unable to delete user: " + id + " with err: " + err.Error())
+		abortErr(c, err, "Unable to delete user: "+err.Error())
+		return
+	}
+
+	s.audit(c, "delete_user", id, "")
+	c.JSON(200, gin.H{"success": "Deleted user with id: " + id})
+}
+
+func (s *Server) handleAdminSetRole(c *gin.Context) {
+	session := c.MustGet(sessionKey).(types.Session)
+
+	id := c.Param("id")
+	role := c.PostForm("role")
+	if !types.ValidUserRole(role) {
+		abort(c, 400, "role must be one of admin, user or read_only")
+		return
+	}
+	if id == session.UserId && role != types.UserAdmin {
+		abort(c, 403, "Admins can not demote themselves")
+		return
+	}
+	if _, err := s.db.GetUser(id); err != nil {
+		abort(c, 404, "User not found")
+		return
+	}
+
+	err := s.db.SetUserRole(id, role)
+	if err != nil {
+		s.logger.Error("Unable to set role of user: " + id + " with err: " + err.Error())
+		abortErr(c, err, "Unable to set role")
+		return
+	}
+
+	s.audit(c, "set_role", id, role)
+	c.JSON(200, gin.H{"success": "Set role of user " + id + " to " + role})
+}
+
+func (s *Server) handleAdminRevokeSessions(c *gin.Context) {
+	id := c.Param("id")
+
+	n, err := s.revokeSessions(id, "")
+	if err != nil {
+		s.logger.Error("Unable to revoke sessions of user: " + id + " with err: " + err.Error())
+		abortErr(c, err, "Unable to revoke sessions")
+		return
+	}
+
+	s.audit(c, "revoke_sessions", id, strconv.Itoa(n))
+	c.JSON(200, gin.H{"success": "Revoked sessions of user " + id, "count": n})
+}
+
+// handleAdminBuckets gets the size of each bucket file along with how much of it is live or deleted data
+func (s *Server) handleAdminBuckets(c *gin.Context) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	stats, err := s.db.GetBucketStats()
+	if err != nil {
+		s.logger.Error("Unable to get bucket stats with err: " + err.Error())
+		abortErr(c, err, "Unable to get bucket stats")
+		return
+	}
+
+	res := make([]types.BucketStat, 0)
+	for id, bucket := range s.handler.Buckets() {
+		stat := stats[id]
+		stat.Id = id
+		stat.FileSize, stat.Filled = bucket.FileSize()
+		res = append(res, stat)
+	}
+
+	c.JSON(200, res)
+}
+
+// handleAdminGC frees up the space of deleted blobs in all the buckets
+func (s *Server) handleAdminGC(c *gin.Context) {
+	s.mu.Lock()
+	err := s.DeleteFreeSpace()
+	s.mu.Unlock()
+	if err != nil {
+		s.logger.Error("Failed to prune free space: With err: " + err.Error())
+		abortErr(c, err, "Unable to free space: "+err.Error())
+		return
+	}
+
+	s.audit(c, "gc", "", "")
+	c.JSON(200, gin.H{"success": "Freed up deleted space"})
+}
+
+// handleAdminScrub reads back every live blob and reports the ones not matching their checksum.
+// Each blob is read under a lock of its own, so that uploads are only held up for one blob at a time.
+func (s *Server) handleAdminScrub(c *gin.Context) {
+	s.mu.RLock()
+	buckets := make([]string, 0, len(s.handler.Buckets()))
+	for id := range s.handler.Buckets() {
+		buckets = append(buckets, id)
+	}
+	s.mu.RUnlock()
+
+	checked := 0
+	corrupt := make([]string, 0)
+	for _, id := range buckets {
+		blobs, err := s.db.GetBlobsInBucket(id)
+		if err != nil {
+			s.logger.Error("Unable to get blobs in bucket: " + id + " with err: " + err.Error())
+			abortErr(c, err, "Unable to get blobs")
+			return
+		}
+
+		for _, blob := range blobs {
+			if blob.Deleted {
+				continue
+			}
+			live, err := s.readLiveBlob(&blob)
+			if !live {
+				continue
+			}
+			checked++
+			if err != nil || utils.CalHash(blob.Content) != blob.Checksum {
+				s.logger.Error("Scrub found corrupt blob with id: " + blob.Id)
+				corrupt = append(corrupt, blob.Id)
+			}
+		}
+	}
+
+	s.audit(c, "scrub", "", fmt.Sprintf("checked %d, corrupt %d", checked, len(corrupt)))
+	c.JSON(200, gin.H{"checked": checked, "corrupt": corrupt})
+}
+
+// readLiveBlob reads the content of a blob under the read lock, looking it up again as it may have
+// been deleted or moved by a gc since it was listed. It is false if the blob is no longer live.
+func (s *Server) readLiveBlob(blob *types.Blob) (bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	current, err := s.db.GetBlobById(blob.Id)
+	if errors.Is(err, types.ErrNotFound) || (err == nil && current.Deleted) {
+		return false, nil
+	}
+	if err != nil {
+		return true, err
+	}
+
+	*blob = current
+	return true, s.handler.Get(blob)
+}
+
+// quotaOwner checks that the id param is an existing user or group to keep a quota of,
+// answering with a 404 when it is not
+func (s *Server) quotaOwner(c *gin.Context) (string, bool) {
+	id := c.Param("id")
+
+	var err error
+	if isGroupId(id) {
+		_, err = s.db.GetGroup(id)
+	} else {
+		_, err = s.db.GetUser(id)
+	}
+	if err != nil {
+		abort(c, 404, "No user or group found with id: "+id)
+		return "", false
+	}
+
+	return id, true
+}
+
+func (s *Server) handleAdminGetQuota(c *gin.Context) {
+	id, ok := s.quotaOwner(c)
+	if !ok {
+		return
+	}
+
+	usage, err := s.db.GetUsage(id)
+	if err != nil {
+		s.logger.Error("Unable to get usage of: " + id + " with err: " + err.Error())
+		abortErr(c, err, "Unable to get usage")
+		return
+	}
+
+	c.JSON(200, gin.H{"usage": usage, "quota": s.quotaOf(id)})
+}
+
+// handleAdminSetQuota sets the quota of a user or group, with max_bytes taking sizes like 10GB
+func (s *Server) handleAdminSetQuota(c *gin.Context) {
+	id, ok := s.quotaOwner(c)
+	if !ok {
+		return
+	}
+
+	maxBytes, err := utils.ParseSize(c.DefaultPostForm("max_bytes", "0"))
+	if err != nil {
+		abort(c, 400, "Invalid max_bytes: "+err.Error())
+		return
+	}
+	maxObjects, err := strconv.ParseInt(c.DefaultPostForm("max_objects", "0"), 10, 64)
+	if err != nil || maxObjects < 0 {
+		abort(c, 400, "max_objects must be a positive number")
+		return
+	}
+
+	quota := types.Quota{OwnerId: id, MaxBytes: int64(maxBytes), MaxObjects: maxObjects}
+	err = s.db.SetQuota(quota)
+	if err != nil {
+		s.logger.Error("Unable to set quota of: " + id + " with err: " + err.Error())
+		abortErr(c, err, "Unable to set quota")
+		return
+	}
+
+	s.audit(c, "set_quota", id, fmt.Sprintf("bytes %d, objects %d", quota.MaxBytes, quota.MaxObjects))
+	c.JSON(200, quota)
+}
+
+func (s *Server) handleAdminAudit(c *gin.Context) {
+	before, _ := strconv.ParseInt(c.Query("before"), 10, 64)
+	limit, err := strconv.ParseUint(c.DefaultQuery("limit", "100"), 10, 64)
+	if err != nil || limit == 0 || limit > 1000 {
+		limit = 100
+	}
+
+	entries, err := s.db.GetAuditLog(c.Query("actor"), before, limit)
+	if err != nil {
+		s.logger.Error("Unable to get audit log with err: " + err.Error())
+		abortErr(c, err, "Unable to get audit log")
+		return
+	}
+
+	c.JSON(200, entries)
+}
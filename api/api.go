@@ -1,29 +1,44 @@
 package api
 
 import (
+	"fmt"
 	"log/slog"
 	"math/rand"
 	"sync"
 
 	"github.com/gin-gonic/gin"
+	"github.com/newtoallofthis123/noob_store/auth"
 	"github.com/newtoallofthis123/noob_store/cache"
 	"github.com/newtoallofthis123/noob_store/db"
 	"github.com/newtoallofthis123/noob_store/fs"
+	"github.com/newtoallofthis123/noob_store/mail"
+	"github.com/newtoallofthis123/noob_store/types"
 	"github.com/newtoallofthis123/noob_store/utils"
+	"golang.org/x/crypto/bcrypt"
 )
 
 type Server struct {
 	listenAddr string
+	env        *utils.Env
 	logger     *slog.Logger
 	db         *db.Store
 	cache      *cache.Cache
 	handler    *fs.Handler
+	signingKey []byte
+	providers  []authProvider
+	oidc       *auth.OIDCClient
+	mail       mail.Sender
+	davLocks   sync.Map
 	counter    int64
 	mu         sync.RWMutex
 	pruning    bool
 }
 
 func NewServer(env *utils.Env, logger *slog.Logger) *Server {
+	if env.BcryptCost < bcrypt.MinCost || env.BcryptCost > bcrypt.MaxCost {
+		panic(fmt.Sprintf("BCRYPT_COST must be between %d and %d", bcrypt.MinCost, bcrypt.MaxCost))
+	}
+
 	store, err := db.NewStore(env.ConnString)
 	if err != nil {
 		panic(err)
@@ -38,6 +53,18 @@ func NewServer(env *utils.Env, logger *slog.Logger) *Server {
 
 	logger.Info("Initialized Tables")
 
+	// The admin is only promoted once their email is verified, on startup or when verifying it
+	if env.AdminEmail != "" {
+		admin, err := store.GetUserByEmail(env.AdminEmail)
+		if err == nil && admin.Verified && admin.Role != types.UserAdmin {
+			err = store.SetUserRole(admin.Id, types.UserAdmin)
+			if err != nil {
+				panic(err)
+			}
+			logger.Info("Promoted " + env.AdminEmail + " to admin")
+		}
+	}
+
 	cache, err := cache.NewCache(env.CacheConn)
 	if err != nil {
 		panic(err)
@@ -56,14 +83,39 @@ func NewServer(env *utils.Env, logger *slog.Logger) *Server {
 
 	logger.Info("Initialized new fs handler")
 
-	return &Server{
+	signingKey := env.SigningKey
+	if signingKey == "" {
+		signingKey = utils.RandomToken(32)
+		logger.Warn("No SIGNING_KEY set, presigned urls will not survive a restart or work across replicas")
+	}
+
+	sender, err := mail.NewSender(env, logger)
+	if err != nil {
+		panic(err)
+	}
+
+	server := &Server{
 		listenAddr: env.ListenAddr,
+		env:        env,
 		logger:     logger,
 		db:         &store,
 		cache:      &cache,
 		handler:    &handler,
+		signingKey: []byte(signingKey),
+		mail:       sender,
 		counter:    0,
 	}
+	server.providers = server.newProviders()
+
+	if env.OIDCIssuer != "" {
+		server.oidc, err = auth.Discover(env.OIDCIssuer, env.OIDCClientId, env.OIDCSecret, env.OIDCRedirect)
+		if err != nil {
+			panic(err)
+		}
+		logger.Info("Discovered OIDC issuer " + env.OIDCIssuer)
+	}
+
+	return server
 }
 
 // Pruner returns a gin.HandlerFunc that prunes old or unnecessary data from the server.
@@ -84,32 +136,141 @@ func (s *Server) Pruner() gin.HandlerFunc {
 	}
 }
 
-func (s *Server) Start() {
+// Router sets up the middleware and routes of the server
+func (s *Server) Router() *gin.Engine {
 	r := gin.Default()
 
+	r.Use(s.RequestId())
+	r.Use(s.ResolveAuth())
 	// Setup pruner as a middleware
 	r.Use(s.Pruner())
+	r.Use(s.RateLimiter())
+	r.Use(s.ReadOnlyGuard())
+	r.Use(s.ApiKeyGuard())
 
 	// To be used to measure latency
 	r.GET("/", func(c *gin.Context) {
 		c.JSON(200, gin.H{"noob_store": gin.H{"version": "0.1", "author": "NoobScience", "status": "up"}})
 	})
+	var spec gin.H
+	r.GET("/openapi.json", func(c *gin.Context) {
+		c.JSON(200, spec)
+	})
 	r.POST("/add", s.handleFileAdd)
 	r.GET("/info/:id", s.handleFileMetadataById)
 	r.GET("/file/:id", s.handleFileDownloadById)
 	r.DELETE("/delete/:id", s.handleDeleteFile)
+	r.POST("/move", s.handleMove)
+	r.POST("/copy", s.handleCopy)
+	r.GET("/retention/:id", s.handleGetRetention)
+	r.PUT("/retention/:id", s.handleSetRetention)
+	r.PUT("/legal_hold/:id", s.handleSetLegalHold)
+	r.GET("/tags/:id", s.handleGetTags)
+	r.PUT("/tags/:id", s.handleSetTags)
+	r.GET("/search", s.handleSearch)
+	r.GET("/search/content", s.handleContentSearch)
+	r.POST("/share", s.handleShare)
+	r.GET("/share", s.handleUserGrants)
+	r.DELETE("/share/:id", s.handleUnshare)
+	r.POST("/presign", s.handlePresign)
+	r.GET("/signed/:id", s.handleSignedDownload)
+	r.PUT("/signed/upload", s.handleSignedUpload)
+	r.POST("/links", s.handleCreateLink)
+	r.GET("/links", s.handleUserLinks)
+	r.DELETE("/links/:id", s.handleRevokeLink)
+	r.GET("/links/:id/events", s.handleLinkEvents)
+	r.GET("/l/:token", s.handlePublicLink)
+	r.POST("/l/:token", s.handlePublicLink)
+
+	groups := r.Group("/groups")
+
+	groups.POST("", s.handleCreateGroup)
+	groups.GET("", s.handleUserGroups)
+	groups.GET("/:id", s.handleGetGroup)
+	groups.POST("/:id/members", s.handleSetMember)
+	groups.DELETE("/:id/members/:user", s.handleRemoveMember)
+	groups.GET("/:id/ls", s.handleGroupLs)
+	groups.GET("/:id/usage", s.handleGroupUsage)
+	groups.POST("/:id/transfer", s.handleTransfer)
+
+	oidc := r.Group("/auth/oidc")
+
+	oidc.GET("/login", s.handleOIDCLogin)
+	oidc.GET("/callback", s.handleOIDCCallback)
 
 	user := r.Group("/user")
 
 	user.POST("/create", s.handleCreateUser)
 	user.POST("/login", s.handleLoginUser)
+	user.POST("/login/2fa", s.handleLoginTwoFactor)
+	user.POST("/reset/request", s.handleRequestReset)
+	user.POST("/reset", s.handleResetPassword)
+	user.GET("/verify", s.handleVerifyEmail)
+	user.POST("/verify/send", s.handleSendVerification)
+	user.POST("/password", s.handleChangePassword)
+	user.DELETE("", s.handleDeleteAccount)
+	user.POST("/logout", s.handleLogout)
+	user.GET("/sessions", s.handleUserSessions)
+	user.DELETE("/sessions", s.handleRevokeAllSessions)
+	user.DELETE("/sessions/:id", s.handleRevokeSession)
+	user.GET("/2fa", s.handleTOTPStatus)
+	user.POST("/2fa/enroll", s.handleEnrollTOTP)
+	user.POST("/2fa/confirm", s.handleConfirmTOTP)
+	user.DELETE("/2fa", s.handleDisableTOTP)
+	user.POST("/2fa/recovery_codes", s.handleRegenerateRecoveryCodes)
+	user.POST("/keys", s.handleCreateApiKey)
+	user.GET("/keys", s.handleUserApiKeys)
+	user.DELETE("/keys/:id", s.handleRevokeApiKey)
 	user.GET("/ls", s.handleUserLs)
 	user.GET("/path_ls", s.handleUserPathLs)
 	user.DELETE("/delete_dir/:dir", s.handleDeleteDir)
+	user.POST("/mkdir", s.handleMkdir)
+	user.DELETE("/rmdir", s.handleRmdir)
+	user.GET("/stat", s.handleStat)
+	user.GET("/children", s.handleDirChildren)
+	user.GET("/usage", s.handleUserUsage)
+	user.GET("/shared", s.handleSharedWithMe)
+	user.GET("/trash", s.handleUserTrash)
+	user.POST("/trash/restore/:id", s.handleRestoreTrash)
+	user.POST("/trash/restore_dir/:dir", s.handleRestoreTrashDir)
+	user.DELETE("/trash", s.handleEmptyTrash)
+	user.POST("/lifecycle", s.handleCreateLifecycleRule)
+	user.GET("/lifecycle", s.handleUserLifecycleRules)
+	user.DELETE("/lifecycle/:id", s.handleDeleteLifecycleRule)
+	user.GET("/lifecycle/:id/dry_run", s.handleLifecycleDryRun)
+
+	admin := r.Group("/admin", s.requireAuth(), s.requireRole(types.UserAdmin))
+
+	admin.GET("/users", s.handleAdminUsers)
+	admin.DELETE("/users/:id", s.handleAdminDeleteUser)
+	admin.PUT("/users/:id/role", s.handleAdminSetRole)
+	admin.DELETE("/users/:id/sessions", s.handleAdminRevokeSessions)
+	admin.DELETE("/users/:id/2fa", s.handleAdminResetTOTP)
+	admin.DELETE("/users/:id/lockout", s.handleAdminUnlock)
+	admin.GET("/buckets", s.handleAdminBuckets)
+	admin.POST("/gc", s.handleAdminGC)
+	admin.POST("/scrub", s.handleAdminScrub)
+	admin.GET("/quotas/:id", s.handleAdminGetQuota)
+	admin.PUT("/quotas/:id", s.handleAdminSetQuota)
+	admin.GET("/audit", s.handleAdminAudit)
+
+	for _, method := range davMethods {
+		r.Handle(method, davPrefix+"/*path", s.handleDav)
+	}
+
+	spec = s.openAPI(r.Routes())
+
+	return r
+}
+
+func (s *Server) Start() {
+	r := s.Router()
 
 	s.logger.Info("Initialized routes")
 	s.handler.LogBucketsInfo()
 
+	s.runWorkers()
+
 	err := r.Run(s.listenAddr)
 	if err != nil {
 		s.logger.Error("Closing Server with err: " + err.Error())
@@ -0,0 +1,146 @@
+package api
+
+import (
+	"path/filepath"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/newtoallofthis123/noob_store/db"
+	"github.com/newtoallofthis123/noob_store/types"
+	"github.com/newtoallofthis123/noob_store/utils"
+	"github.com/newtoallofthis123/ranhash"
+)
+
+// apiKeyPrefix marks a bearer token as an api key rather than a session id
+const apiKeyPrefix = "nsk_"
+
+// maxApiKeyExpiry is the longest an api key can be created for, keys without an expiry never expiring
+const maxApiKeyExpiry = 5 * 365 * 24 * time.Hour
+
+// checkApiKey authenticates an api key token, returning a session carrying its scope
+func (s *Server) checkApiKey(token string) (types.Session, bool) {
+	key, err := s.db.GetApiKeyByHash(utils.CalHash([]byte(token)))
+	if err != nil {
+		return types.Session{}, false
+	}
+
+	now := time.Now()
+	if key.ExpiresAt != "" {
+		expiresAt, err := time.Parse(time.RFC3339Nano, key.ExpiresAt)
+		if err != nil || !now.Before(expiresAt) {
+			s.logger.Debug("Expired api key with id: " + key.Id)
+			return types.Session{}, false
+		}
+	}
+
+	err = s.db.TouchApiKey(key.Id, now, sessionTouchInterval)
+	if err != nil {
+		s.logger.Error("Unable to touch api key: " + key.Id + " with err: " + err.Error())
+	}
+
+	s.logger.Debug("Authenticated api key: " + key.Id + " of: " + key.UserId)
+	return types.Session{UserId: key.UserId, KeyId: key.Id, Scope: key.Scope, PathPrefix: key.PathPrefix}, true
+}
+
+// handleCreateApiKey mints an api key for the session user. The token is only ever returned here.
+func (s *Server) handleCreateApiKey(c *gin.Context) {
+	authKey := c.GetHeader("Authorization")
+	session, exists := authSession(c)
+	if !exists {
+		s.logger.Error("Unauthorized session: " + authKey)
+		abort(c, 401, "Invalid Authorization or missing session")
+		return
+	}
+
+	name, exists := c.GetPostForm("name")
+	if !exists || name == "" {
+		abort(c, 400, "Name is needed in the post form")
+		return
+	}
+	scope := c.DefaultPostForm("scope", types.PermRead)
+	if scope != types.PermRead && scope != types.PermWrite {
+		abort(c, 400, "scope must be one of read or write")
+		return
+	}
+
+	prefix := ""
+	if path, exists := c.GetPostForm("path"); exists {
+		prefix = filepath.Clean(path)
+		if db.IsRootDir(prefix) {
+			prefix = ""
+		}
+	}
+
+	token := apiKeyPrefix + utils.RandomToken(24)
+	key := types.ApiKey{
+		Id:         ranhash.GenerateRandomString(8),
+		UserId:     session.UserId,
+		Name:       name,
+		Token:      token,
+		Hash:       utils.CalHash([]byte(token)),
+		Scope:      scope,
+		PathPrefix: prefix,
+	}
+	if _, exists := c.GetPostForm("expires_in"); exists {
+		expiry, err := parseExpiry(c, 0, maxApiKeyExpiry)
+		if err != nil {
+			abortErr(c, err, err.Error())
+			return
+		}
+		key.ExpiresAt = time.Now().Add(expiry).Format(time.RFC3339Nano)
+	}
+
+	err := s.db.CreateApiKey(key)
+	if err != nil {
+		s.logger.Error("Unable to create api key for userId: " + session.UserId + " with err: " + err.Error())
+		abortErr(c, err, "Unable to create api key: "+err.Error())
+		return
+	}
+
+	c.JSON(200, key)
+}
+
+func (s *Server) handleUserApiKeys(c *gin.Context) {
+	authKey := c.GetHeader("Authorization")
+	session, exists := authSession(c)
+	if !exists {
+		s.logger.Error("Unauthorized session: " + authKey)
+		abort(c, 401, "Invalid Authorization or missing session")
+		return
+	}
+
+	keys, err := s.db.GetApiKeysByUser(session.UserId)
+	if err != nil {
+		s.logger.Error("Unable to get api keys of userId: " + session.UserId + " with err: " + err.Error())
+		abortErr(c, err, "Unable to get api keys")
+		return
+	}
+
+	c.JSON(200, keys)
+}
+
+func (s *Server) handleRevokeApiKey(c *gin.Context) {
+	authKey := c.GetHeader("Authorization")
+	session, exists := authSession(c)
+	if !exists {
+		s.logger.Error("Unauthorized session: " + authKey)
+		abort(c, 401, "Invalid Authorization or missing session")
+		return
+	}
+
+	id := c.Param("id")
+	key, err := s.db.GetApiKeyById(id)
+	if err != nil || key.UserId != session.UserId {
+		abort(c, 404, "Unable to find api key")
+		return
+	}
+
+	err = s.db.DeleteApiKeyById(id)
+	if err != nil {
+		s.logger.Error("Unable to delete api key: " + id + " with err: " + err.Error())
+		abortErr(c, err, "Unable to revoke api key")
+		return
+	}
+
+	c.JSON(200, gin.H{"success": "Revoked api key with id: " + id})
+}
@@ -0,0 +1,320 @@
+package api
+
+import (
+	"crypto/hmac"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/newtoallofthis123/noob_store/auth"
+	"github.com/newtoallofthis123/noob_store/types"
+	"github.com/newtoallofthis123/noob_store/utils"
+	"github.com/newtoallofthis123/ranhash"
+)
+
+// oidcStateExpiry is how long a user has to log in at the issuer
+const oidcStateExpiry = 10 * time.Minute
+
+// authProvider authenticates one kind of bearer token
+type authProvider interface {
+	// accepts checks if the token is of the kind the provider handles
+	accepts(token string) bool
+	authenticate(token string) (types.Session, bool)
+}
+
+// checkAuth authenticates the Authorization header with the first provider accepting its token
+func (s *Server) checkAuth(authKey string) (types.Session, bool) {
+	if authKey == "" {
+		return types.Session{}, false
+	}
+
+	authKey = strings.Replace(authKey, "Bearer ", "", 1)
+	for _, provider := range s.providers {
+		if provider.accepts(authKey) {
+			return provider.authenticate(authKey)
+		}
+	}
+
+	return types.Session{}, false
+}
+
+// newProviders sets up the auth providers enabled in the env, api keys and
+// local sessions always being accepted
+func (s *Server) newProviders() []authProvider {
+	providers := []authProvider{apiKeyProvider{s}}
+
+	if s.env.JwksFile != "" {
+		keys, err := auth.LoadKeySet(s.env.JwksFile)
+		if err != nil {
+			panic(err)
+		}
+		providers = append(providers, jwtProvider{s: s, keys: keys})
+		s.logger.Info("Accepting JWTs signed with keys from " + s.env.JwksFile)
+	}
+
+	return append(providers, sessionProvider{s})
+}
+
+// sessionProvider authenticates the sessions created by logging in
+type sessionProvider struct {
+	s *Server
+}
+
+func (p sessionProvider) accepts(token string) bool {
+	return true
+}
+
+func (p sessionProvider) authenticate(token string) (types.Session, bool) {
+	s := p.s
+
+	session, err := s.cache.GetSession(token)
+	if err != nil {
+		session, err = s.db.GetSession(token)
+		s.logger.Debug("Cache miss for session with id: " + token)
+		if err != nil {
+			return types.Session{}, false
+		}
+		_ = s.cache.InsertSession(session, time.Until(s.sessionExpiry(session)))
+		s.logger.Debug("Cache refreshed for id: " + token)
+	}
+
+	now := time.Now()
+	if s.sessionExpired(session, now) {
+		s.logger.Debug("Expired session with id: " + token)
+		_ = s.revokeSession(session.Id)
+		return types.Session{}, false
+	}
+	session = s.touchSession(session, now)
+
+	s.logger.Debug("Authenticated request from: " + session.UserId)
+	return session, true
+}
+
+// apiKeyProvider authenticates the api keys of users
+type apiKeyProvider struct {
+	s *Server
+}
+
+func (p apiKeyProvider) accepts(token string) bool {
+	return strings.HasPrefix(token, apiKeyPrefix)
+}
+
+func (p apiKeyProvider) authenticate(token string) (types.Session, bool) {
+	return p.s.checkApiKey(token)
+}
+
+// jwtProvider authenticates JWTs of an identity provider, provisioning their users on first use.
+// Verified tokens are cached until they expire so users are only looked up once per token.
+type jwtProvider struct {
+	s    *Server
+	keys *auth.KeySet
+}
+
+func (p jwtProvider) accepts(token string) bool {
+	return auth.LooksLikeJWT(token)
+}
+
+func (p jwtProvider) authenticate(token string) (types.Session, bool) {
+	s := p.s
+
+	cacheKey := "jwt:" + utils.CalHash([]byte(token))
+	session, err := s.cache.GetSession(cacheKey)
+	if err == nil {
+		return session, true
+	}
+
+	claims, err := auth.Verify(token, p.keys, s.env.JwtIssuer, s.env.JwtAudience, time.Now())
+	if err != nil {
+		s.logger.Debug("Rejected JWT with err: " + err.Error())
+		return types.Session{}, false
+	}
+
+	user, err := s.provisionUser(claims)
+	if err != nil {
+		s.logger.Error("Unable to provision user for JWT with err: " + err.Error())
+		return types.Session{}, false
+	}
+
+	expiresAt, _ := claims.Time("exp")
+	session = types.Session{Id: cacheKey, UserId: user.Id, ExpiresAt: expiresAt.Format(time.RFC3339Nano)}
+	_ = s.cache.InsertSession(session, time.Until(expiresAt))
+
+	s.logger.Debug("Authenticated JWT of: " + user.Id)
+	return session, true
+}
+
+// provisionUser gets the user the claims of a verified token are about, creating them on their first login,
+// and brings their role and groups in line with the claims. A local user with the same verified email
+// is linked to the identity instead of creating a new user.
+func (s *Server) provisionUser(claims auth.Claims) (types.User, error) {
+	issuer, subject := claims.String("iss"), claims.String("sub")
+	if subject == "" {
+		return types.User{}, types.Errorf(types.ErrUnauthorized, "token has no subject")
+	}
+
+	user, err := s.db.GetUserBySubject(issuer, subject)
+	if err != nil {
+		email := claims.String("email")
+		if email == "" {
+			return types.User{}, types.Errorf(types.ErrUnauthorized, "token has no email")
+		}
+
+		user, err = s.db.GetUserByEmail(email)
+		if err == nil {
+			verified, _ := claims["email_verified"].(bool)
+			if user.Subject != "" || !verified {
+				return types.User{}, types.Errorf(types.ErrConflict, "email belongs to another user")
+			}
+			err = s.db.LinkUserSubject(user.Id, issuer, subject)
+			if err != nil {
+				return types.User{}, err
+			}
+			s.logger.Info("Linked user " + user.Id + " to identity " + subject)
+			// The identity provider vouching for the email proves it as much as a verification mail
+			if !user.Verified {
+				err = s.db.SetEmailVerified(user.Id)
+				if err != nil {
+					return types.User{}, err
+				}
+				user.Verified = true
+			}
+		} else {
+			verified, _ := claims["email_verified"].(bool)
+			user = types.User{
+				Id:       ranhash.GenerateRandomString(8),
+				Email:    email,
+				Role:     types.UserRegular,
+				Issuer:   issuer,
+				Subject:  subject,
+				Verified: verified,
+			}
+			err = s.db.CreateUser(user)
+			if err != nil {
+				return types.User{}, err
+			}
+			s.logger.Info("Provisioned user " + user.Id + " for identity " + subject)
+		}
+
+		err = s.promoteAdmin(&user)
+		if err != nil {
+			return types.User{}, err
+		}
+	}
+
+	role := claimedRole(claims.Strings(s.env.RoleClaim))
+	if role != "" && role != user.Role {
+		err = s.db.SetUserRole(user.Id, role)
+		if err != nil {
+			return types.User{}, err
+		}
+		user.Role = role
+	}
+
+	s.joinClaimedGroups(user.Id, claims.Strings(s.env.GroupsClaim))
+	return user, nil
+}
+
+// claimedRole maps the roles claim of a token to the highest user role in it, being empty if there is none
+func claimedRole(roles []string) string {
+	role := ""
+	for _, r := range roles {
+		switch r {
+		case types.UserAdmin:
+			return types.UserAdmin
+		case types.UserRegular:
+			role = types.UserRegular
+		case types.UserReadOnly:
+			if role == "" {
+				role = types.UserReadOnly
+			}
+		}
+	}
+	return role
+}
+
+// joinClaimedGroups adds a user as a member to the existing groups named by id or name in the groups claim.
+// Memberships are only ever added, so roles given in noob_store are kept.
+func (s *Server) joinClaimedGroups(userId string, names []string) {
+	for _, name := range names {
+		group, err := s.db.GetGroup(name)
+		if err != nil {
+			group, err = s.db.GetGroupByName(name)
+		}
+		if err != nil {
+			continue
+		}
+
+		if _, err := s.db.GetMemberRole(group.Id, userId); err == nil {
+			continue
+		}
+		err = s.db.SetMember(group.Id, userId, types.RoleMember)
+		if err != nil {
+			s.logger.Error("Unable to add user " + userId + " to group " + group.Id + " with err: " + err.Error())
+		}
+	}
+}
+
+// handleOIDCLogin sends the user to the issuer to log in, with a signed state bound to their browser
+func (s *Server) handleOIDCLogin(c *gin.Context) {
+	if s.oidc == nil {
+		abort(c, 501, "OIDC login is not configured")
+		return
+	}
+
+	nonce := utils.RandomToken(16)
+	exp := strconv.FormatInt(time.Now().Add(oidcStateExpiry).Unix(), 10)
+	state := nonce + "." + exp + "." + s.signature("oidc", nonce, exp)
+
+	c.SetCookie("oidc_state", state, int(oidcStateExpiry.Seconds()), "/", "", c.Request.TLS != nil, true)
+	c.Redirect(302, s.oidc.AuthURL(state, nonce))
+}
+
+// handleOIDCCallback finishes the login at the issuer, creating a session for the provisioned user
+func (s *Server) handleOIDCCallback(c *gin.Context) {
+	if s.oidc == nil {
+		abort(c, 501, "OIDC login is not configured")
+		return
+	}
+
+	state := c.Query("state")
+	cookie, err := c.Cookie("oidc_state")
+	if err != nil || cookie != state {
+		abort(c, 400, "Login state does not match")
+		return
+	}
+	parts := strings.Split(state, ".")
+	if len(parts) != 3 || !hmac.Equal([]byte(parts[2]), []byte(s.signature("oidc", parts[0], parts[1]))) {
+		abort(c, 400, "Invalid login state")
+		return
+	}
+	exp, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil || time.Now().Unix() > exp {
+		abort(c, 400, "Login expired, try again")
+		return
+	}
+	c.SetCookie("oidc_state", "", -1, "/", "", c.Request.TLS != nil, true)
+
+	claims, err := s.oidc.Exchange(c.Query("code"), parts[0])
+	if err != nil {
+		s.logger.Error("Unable to exchange OIDC code with err: " + err.Error())
+		abort(c, 401, "Login failed: "+err.Error())
+		return
+	}
+
+	user, err := s.provisionUser(claims)
+	if err != nil {
+		s.logger.Error("Unable to provision OIDC user with err: " + err.Error())
+		abortErr(c, err, "Login failed: "+err.Error())
+		return
+	}
+
+	session, err := s.newSession(c, user.Id)
+	if err != nil {
+		s.logger.Error("Failed to create session for " + user.Id + " with err: " + err.Error())
+		abortErr(c, err, "Error creating session: "+err.Error())
+		return
+	}
+
+	c.JSON(200, session)
+}
@@ -2,67 +2,34 @@ package api
 
 import (
 	"io"
+	"mime"
 	"path/filepath"
-	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/newtoallofthis123/noob_store/fs"
 	"github.com/newtoallofthis123/noob_store/types"
 	"github.com/newtoallofthis123/noob_store/utils"
 )
 
-func (s *Server) checkAuth(authKey string) (types.Session, bool) {
-	if authKey == "" {
-		return types.Session{}, false
-	}
-
-	authKey = strings.Replace(authKey, "Bearer ", "", 1)
-	session, err := s.cache.GetSession(authKey)
-	if err != nil {
-		session, err = s.db.GetSession(authKey)
-		s.logger.Debug("Cache miss for session with id: " + authKey)
-		if err != nil {
-			return types.Session{}, false
-		}
-		_ = s.cache.InsertSession(session)
-		s.logger.Debug("Cache refreshed for id: " + authKey)
-	}
-
-	s.logger.Debug("Authenticated request from: " + session.UserId)
-	return session, true
-}
-
 func (s *Server) handleFileMetadataById(c *gin.Context) {
 	authKey := c.GetHeader("Authorization")
-	session, exists := s.checkAuth(authKey)
+	session, exists := authSession(c)
 	if !exists {
 		s.logger.Error("Unauthorized session: " + authKey)
-		c.JSON(500, gin.H{"err": "Invalid Authorization or missing session"})
+		abort(c, 401, "Invalid Authorization or missing session")
 		return
 	}
 
 	id, exists := c.Params.Get("id")
 	if !exists {
-		c.JSON(500, gin.H{"err": "id needed"})
+		abort(c, 400, "id needed")
 		return
 	}
 
-	metadata, err := s.cache.GetMetadata(id)
+	metadata, err := s.Info(session, id)
 	if err != nil {
-		s.logger.Debug("Cache miss for metadata: " + id)
-		metadata, err = s.db.GetMetaDataById(id)
-		if err != nil {
-			s.logger.Error("No metadata with id: " + id + " with err: " + err.Error())
-			c.JSON(500, gin.H{"err": "Failed to retrieve metadata: " + err.Error()})
-			return
-		}
-
-		_ = s.cache.InsertMetadata(metadata)
-		s.logger.Debug("Cache refreshed for metadata with id: " + metadata.Id)
-	}
-
-	if metadata.UserId != session.UserId {
-		s.logger.Warn("Prevented Unauthorized access for file from userId" + session.UserId)
-		c.JSON(500, gin.H{"err": "Unauthorized access to file from userId: " + session.UserId})
+		abortErr(c, err, "Failed to retrieve metadata: "+err.Error())
 		return
 	}
 
@@ -72,213 +39,191 @@ func (s *Server) handleFileMetadataById(c *gin.Context) {
 
 func (s *Server) handleFileDownloadById(c *gin.Context) {
 	authKey := c.GetHeader("Authorization")
-	if authKey == "" {
-		authKey = c.Query("session")
-	}
-	session, exists := s.checkAuth(authKey)
+	session, exists := authSession(c)
 	if !exists {
 		s.logger.Error("Unauthorized session: " + authKey)
-		c.JSON(500, gin.H{"err": "Invalid Authorization or missing session"})
+		abort(c, 401, "Invalid Authorization or missing session")
 		return
 	}
 
 	id, exists := c.Params.Get("id")
 	if !exists {
-		c.JSON(500, gin.H{"err": "blob id needed"})
+		abort(c, 400, "blob id needed")
 		return
 	}
 
-	s.mu.RLock()
-	meta, err := s.cache.GetMetadata(id)
+	meta, content, err := s.Download(session, id)
 	if err != nil {
-		s.logger.Debug("Cache miss for metadata: " + id + " with err: " + err.Error())
-		meta, err = s.db.GetMetaDataById(id)
-		if err != nil {
-			s.logger.Error("No metadata with id: " + id + " with err: " + err.Error())
-			c.JSON(500, gin.H{"err": "Failed to retrieve metadata: " + err.Error()})
-			return
-		}
-
-		_ = s.cache.InsertMetadata(meta)
-		s.logger.Debug("Cache refreshed for metadata with id: " + meta.Id)
-	}
-	s.mu.RUnlock()
-
-	if meta.UserId != session.UserId {
-		s.logger.Warn("Prevented Unauthorized access for file from userId" + session.UserId)
-		c.JSON(500, gin.H{"err": "Unauthorized access to file from userId: " + session.UserId})
+		abortErr(c, err, "Failed to retrieve file: "+err.Error())
 		return
 	}
 
+	c.Header("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": meta.Name}))
+	c.Data(200, meta.Mime, content)
+}
+
+// serveFile writes the verified content of a file as the response
+func (s *Server) serveFile(c *gin.Context, meta types.Metadata) {
 	s.mu.RLock()
-	blob, err := s.cache.GetBlob(meta.Blob)
-	if err != nil {
-		s.logger.Debug("Cache miss for blob with id: " + meta.Blob + " with err: " + err.Error())
-		blob, err = s.db.GetBlobById(meta.Blob)
-		if err != nil {
-			s.logger.Error("No Cache with Id: " + meta.Blob + " with err: " + err.Error())
-			c.JSON(500, gin.H{"err": "Failed to retrieve blob: " + err.Error()})
-			return
-		}
-		s.logger.Debug("Cache refreshed for blob with id: " + meta.Blob)
-	}
+	content, err := s.readContent(meta)
 	s.mu.RUnlock()
-
-	err = s.handler.Get(&blob)
 	if err != nil {
-		s.logger.Error("Failed to fill data for blob: " + blob.Id + " with err: " + err.Error())
-		c.JSON(500, gin.H{"err": "Failed to retrieve blob: " + err.Error()})
+		s.logger.Error("Failed to read blob: " + meta.Blob + " with err: " + err.Error())
+		abortErr(c, err, "Failed to retrieve blob: "+err.Error())
 		return
 	}
 
-	checksum := utils.CalHash(blob.Content)
-	if checksum != blob.Checksum {
-		c.JSON(500, gin.H{"err": "File check validity failed! File recovery not possible: recommeneded deletion"})
-	}
-
-	n, err := c.Writer.Write(blob.Content)
-	if err != nil {
-		s.logger.Error("Failed to write data to response for blob: " + blob.Id + " with err: " + err.Error())
-		c.JSON(500, gin.H{"err": "Unable to write: " + err.Error()})
-		return
-	}
-	if n != len(blob.Content) {
-		s.logger.Error("Failed integrity data for blob: " + blob.Id)
-		c.JSON(500, gin.H{"err": "File integrity check failed"})
-		return
-	}
+	c.Header("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": meta.Name}))
+	c.Data(200, meta.Mime, content)
 }
+
 func (s *Server) handleFileAdd(c *gin.Context) {
 	authKey := c.GetHeader("Authorization")
-	session, exists := s.checkAuth(authKey)
+	session, exists := authSession(c)
 	if !exists {
 		s.logger.Error("Unauthorized session: " + authKey)
-		c.JSON(500, gin.H{"err": "Invalid Authorization or missing session"})
+		abort(c, 401, "Invalid Authorization or missing session")
 		return
 	}
 
 	path, exists := c.GetPostForm("path")
 	if !exists {
-		c.JSON(500, gin.H{"err": "Path is needed in the post form"})
+		abort(c, 400, "Path is needed in the post form")
 		return
 	}
 
 	fileHeader, err := c.FormFile("content")
 	if err != nil {
 		s.logger.Error("Unable to read content file for: " + authKey + " with err: " + err.Error())
-		c.JSON(500, gin.H{"err": "Unable to read file: " + err.Error()})
+		abort(c, 400, "Unable to read file: "+err.Error())
 		return
 	}
 
 	file, err := fileHeader.Open()
 	if err != nil {
 		s.logger.Error("Unable to read content file for " + authKey + " with err: " + err.Error())
-		c.JSON(500, gin.H{"err": "Unable to read file: " + err.Error()})
+		abortErr(c, err, "Unable to read file: "+err.Error())
 		return
 	}
 	content, err := io.ReadAll(file)
 	if err != nil {
 		s.logger.Error("Unable to read content file for " + authKey + " with err: " + err.Error())
-		c.JSON(500, gin.H{"err": "Unable to read file: " + err.Error()})
+		abortErr(c, err, "Unable to read file: "+err.Error())
+		return
+	}
+
+	info := UploadInfo{
+		Path:     path,
+		Group:    c.PostForm("group"),
+		UserMeta: prefixedFields(c, "meta-", "X-Meta-"),
+		Tags:     prefixedFields(c, "tag-", "X-Tag-"),
+	}
+	meta, err := s.Upload(session, info, content)
+	if err != nil {
+		abortErr(c, err, "Unable to insert file: "+err.Error())
 		return
 	}
 
+	c.JSON(200, meta)
+}
+
+// addFile stores a new file for a user at a path that must not be taken, within their quota
+func (s *Server) addFile(userId, path string, content []byte, attrs fileAttrs) (types.Metadata, error) {
 	path = filepath.Clean(path)
-	s.mu.RLock()
-	existing, err := s.db.GetMetaDataByPath(path)
-	if err == nil && existing.UserId == session.UserId {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_, err := s.db.GetMetaDataByUserPath(userId, path)
+	if err == nil {
 		s.logger.Error("Attempt at adding duplicate path: " + path)
-		c.JSON(500, gin.H{"err": "Path already exists for user in store"})
-		return
+		return types.Metadata{}, types.Errorf(types.ErrConflict, "path already exists for user in store")
 	}
-	s.mu.RUnlock()
 
+	return s.storeFile(userId, path, content, attrs)
+}
+
+// replaceFile stores a new file for a user at a path, trashing the file already there
+// if the session user can write to it and it is not under retention or legal hold
+func (s *Server) replaceFile(session types.Session, userId, path string, content []byte, attrs fileAttrs) (types.Metadata, error) {
+	path = filepath.Clean(path)
 	s.mu.Lock()
-	blob, meta, err := s.handler.Insert(path, content, session.UserId)
-	err = s.db.InsertBlob(blob)
+	defer s.mu.Unlock()
+
+	existing, err := s.db.GetMetaDataByUserPath(userId, path)
 	if err != nil {
-		s.logger.Debug("Unable to insert blob " + blob.Id + " with err: " + err.Error())
-		c.JSON(500, gin.H{"err": "Unable to insert file: " + err.Error()})
-		return
+		return s.storeFile(userId, path, content, attrs)
 	}
-	err = s.db.InsertMetaData(meta)
+
+	if !s.authorize(session, existing, types.PermWrite) {
+		s.logger.Warn("Prevented Unauthorized overwrite of file: " + existing.Id + " by user " + session.UserId)
+		return types.Metadata{}, types.Errorf(types.ErrForbidden, "unauthorized file access")
+	}
+	now := time.Now()
+	if existing.Locked(now, false) {
+		return types.Metadata{}, types.Errorf(types.ErrLocked, "can not overwrite file under retention or legal hold: %s", path)
+	}
+
+	err = s.db.TrashMetadataById(existing.Id, now)
 	if err != nil {
-		s.logger.Debug("Unable to insert metadata " + meta.Id + " with err: " + err.Error())
-		c.JSON(500, gin.H{"err": "Unable to insert file: " + err.Error()})
-		return
+		s.logger.Error("Unable to trash overwritten file: " + existing.Id + " with err: " + err.Error())
+		return types.Metadata{}, err
 	}
-	err = s.cache.InsertBlob(blob)
+	_ = s.cache.DeleteMetadata(existing.Id)
+
+	meta, err := s.storeFile(userId, path, content, attrs)
 	if err != nil {
-		s.logger.Error("Unable to insert blob to cache " + blob.Id + " with err: " + err.Error())
-		c.JSON(500, gin.H{"err": "Unable to insert file: " + err.Error()})
-		return
+		// Put the old file back so that a failed overwrite leaves the path as it was
+		_ = s.db.RestoreMetadataById(existing.Id)
+		return types.Metadata{}, err
 	}
-	err = s.cache.InsertMetadata(meta)
+	return meta, nil
+}
+
+// storeFile stores a new file for a user within their quota, the caller must hold the write lock
+func (s *Server) storeFile(userId, path string, content []byte, attrs fileAttrs) (types.Metadata, error) {
+	err := s.checkQuota(userId, int64(len(content)), 1)
 	if err != nil {
-		s.logger.Error("Unable to insert metadata to cache " + meta.Id + " with err: " + err.Error())
-		c.JSON(500, gin.H{"err": "Unable to insert file: " + err.Error()})
-		return
+		s.logger.Warn("Rejected upload over quota for userId: " + userId)
+		return types.Metadata{}, err
+	}
+
+	blob, meta, err := s.insertFile(path, content, userId, attrs)
+	if err != nil {
+		return types.Metadata{}, err
 	}
-	s.mu.Unlock()
 
 	s.logger.Debug("Successfully added blob: " + blob.Id)
 	s.logger.Info("Added to bucket: " + blob.Bucket)
 	s.handler.LogBucketsInfo()
-	c.JSON(200, meta)
+	return meta, nil
 }
 
 func (s *Server) handleDeleteFile(c *gin.Context) {
 	authKey := c.GetHeader("Authorization")
-	session, exists := s.checkAuth(authKey)
+	session, exists := authSession(c)
 	if !exists {
 		s.logger.Error("Unauthorized session: " + authKey)
-		c.JSON(500, gin.H{"err": "Invalid Authorization or missing session"})
+		abort(c, 401, "Invalid Authorization or missing session")
 		return
 	}
 
 	fileId := c.Param("id")
 
-	s.mu.RLock()
-	meta, err := s.db.GetMetaDataById(fileId)
-	if err != nil {
-		s.logger.Error("Unable to find file with id: " + fileId + " with err: " + err.Error())
-		c.JSON(500, gin.H{"err": "Unable to find file"})
-		return
-	}
-	s.mu.RUnlock()
-
-	if meta.UserId != session.UserId {
-		s.logger.Warn("Prevented Unauthorized access of file: " + fileId + " by user " + session.UserId)
-		c.JSON(500, gin.H{"err": "Unauthorized file access"})
-		return
-	}
-
-	s.mu.Lock()
-	err = s.db.DeleteMetadataById(meta.Id)
+	err := s.Delete(session, fileId, bypassGovernance(c))
 	if err != nil {
-		s.logger.Error("Unable to delete file with id: " + fileId + " with err: " + err.Error())
-		err = s.db.InsertMetaData(meta)
-		if err != nil {
-			s.logger.Error("Unable to retrieve file with id: " + fileId + " with err: " + err.Error())
-			c.JSON(500, gin.H{"err": "Unable to atomically delete file + lost file access"})
-			return
-		}
-		c.JSON(200, gin.H{"failure": "Failed to delete file: " + fileId + " but file is preserved."})
+		abortErr(c, err, "Failed to delete file: "+fileId+" with err: "+err.Error())
 		return
 	}
 
-	_ = s.db.MarkBlobDelete(meta.Blob)
-	s.mu.Unlock()
-
-	c.JSON(200, gin.H{"success": "Deleted file with id: " + fileId})
+	c.JSON(200, gin.H{"success": "Moved file with id: " + fileId + " to trash"})
 }
 
 func (s *Server) handleDeleteDir(c *gin.Context) {
 	authKey := c.GetHeader("Authorization")
-	session, exists := s.checkAuth(authKey)
+	session, exists := authSession(c)
 	if !exists {
 		s.logger.Error("Unauthorized session: " + authKey)
-		c.JSON(500, gin.H{"err": "Invalid Authorization or missing session"})
+		abort(c, 401, "Invalid Authorization or missing session")
 		return
 	}
 
@@ -286,63 +231,150 @@ func (s *Server) handleDeleteDir(c *gin.Context) {
 
 	s.mu.RLock()
 	metas, err := s.db.GetMetaDataByDir(dir)
+	s.mu.RUnlock()
 	if err != nil {
 		s.logger.Error("Unable to find file with id: " + dir + " with err: " + err.Error())
-		c.JSON(500, gin.H{"err": "Unable to find file"})
+		abortErr(c, err, "Unable to find file")
 		return
 	}
-	s.mu.RUnlock()
 
 	bak := make([]types.Metadata, 0)
 	hasErr := false
+	trashedAt := time.Now()
 
 	s.mu.Lock()
 	for _, meta := range metas {
-		bak = append(bak, meta)
-		if meta.UserId != session.UserId {
+		if !s.authorize(session, meta, types.PermWrite) {
 			s.logger.Warn("Prevented Unauthorized access of file: " + dir + " by user " + session.UserId)
 			hasErr = true
 			break
 		}
+		if meta.Locked(trashedAt, bypassGovernance(c)) {
+			s.logger.Warn("Prevented deletion of locked file: " + meta.Id + " by user " + session.UserId)
+			hasErr = true
+			break
+		}
 
-		err = s.db.DeleteMetadataById(meta.Id)
+		err = s.db.TrashMetadataById(meta.Id, trashedAt)
 		if err != nil {
-			s.logger.Error("Unable to delete file with id: " + dir + " with err: " + err.Error())
+			s.logger.Error("Unable to trash file with id: " + meta.Id + " with err: " + err.Error())
 			hasErr = true
 			break
 		}
+		bak = append(bak, meta)
 	}
 
+	err = nil
 	for _, m := range bak {
 		if hasErr {
-			err = s.db.InsertMetaData(m)
+			err = s.db.RestoreMetadataById(m.Id)
 		} else {
-			err = s.db.MarkBlobDelete(m.Blob)
+			_ = s.cache.DeleteMetadata(m.Id)
 		}
 	}
 	s.mu.Unlock()
 	if err != nil {
 		s.logger.Error("Unabled to recover from dir deletion operation failure: " + dir + " with err: " + err.Error())
-		c.JSON(500, gin.H{"err": "Delete failed unatomically"})
+		abortErr(c, err, "Delete failed unatomically")
 		return
 	}
 
 	if hasErr {
-		c.JSON(200, gin.H{"failure": "Failed to delete dir: " + dir + " but files are preserved."})
-	} else {
-		c.JSON(200, gin.H{"success": "Deleted dir: " + dir})
+		abort(c, 500, "Failed to delete dir: "+dir+" but files are preserved.")
+		return
+	}
+	c.JSON(200, gin.H{"success": "Moved dir: " + dir + " to trash"})
+}
+
+// fileAttrs are the attributes of a file that are given rather than derived from its content
+type fileAttrs struct {
+	Mime     string
+	UserMeta map[string]string
+	Tags     map[string]string
+}
+
+// insertFile writes the content into a bucket and stores the blob and metadata for it.
+// The mime is detected from the path and content unless given in the attrs.
+// The caller must hold the write lock.
+func (s *Server) insertFile(path string, content []byte, userId string, attrs fileAttrs) (types.Blob, types.Metadata, error) {
+	blob, meta, err := s.handler.Insert(path, content, userId)
+	if err != nil {
+		s.logger.Error("Unable to write blob for path " + path + " with err: " + err.Error())
+		return types.Blob{}, types.Metadata{}, err
+	}
+	if attrs.Mime != "" {
+		meta.Mime = attrs.Mime
+	}
+	meta.UserMeta = attrs.UserMeta
+	meta.Tags = attrs.Tags
+
+	err = s.db.InsertBlob(blob)
+	if err != nil {
+		s.logger.Debug("Unable to insert blob " + blob.Id + " with err: " + err.Error())
+		return types.Blob{}, types.Metadata{}, err
+	}
+	err = s.db.InsertMetaData(meta)
+	if err != nil {
+		s.logger.Debug("Unable to insert metadata " + meta.Id + " with err: " + err.Error())
+		_ = s.db.MarkBlobDelete(blob.Id)
+		return types.Blob{}, types.Metadata{}, err
+	}
+	err = s.db.EnsureDirs(userId, meta.Parent)
+	if err != nil {
+		s.logger.Error("Unable to create parent dirs of " + meta.Path + " with err: " + err.Error())
+	}
+	err = s.db.AddUsage(userId, int64(blob.Size), 1)
+	if err != nil {
+		s.logger.Error("Unable to add usage of userId: " + userId + " with err: " + err.Error())
+	}
+	if text, ok := fs.ExtractText(meta.Mime, content); ok {
+		err = s.db.IndexContent(meta.Id, text)
+		if err != nil {
+			s.logger.Error("Unable to index content of " + meta.Id + " with err: " + err.Error())
+		}
 	}
+	err = s.cache.InsertBlob(blob)
+	if err != nil {
+		s.logger.Error("Unable to insert blob to cache " + blob.Id + " with err: " + err.Error())
+	}
+	err = s.cache.InsertMetadata(meta)
+	if err != nil {
+		s.logger.Error("Unable to insert metadata to cache " + meta.Id + " with err: " + err.Error())
+	}
+
+	return blob, meta, nil
+}
+
+// readContent reads and verifies the content of the blob of a metadata
+func (s *Server) readContent(meta types.Metadata) ([]byte, error) {
+	blob, err := s.cache.GetBlob(meta.Blob)
+	if err != nil {
+		s.logger.Debug("Cache miss for blob with id: " + meta.Blob + " with err: " + err.Error())
+		blob, err = s.db.GetBlobById(meta.Blob)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = s.handler.Get(&blob)
+	if err != nil {
+		return nil, err
+	}
+
+	if utils.CalHash(blob.Content) != blob.Checksum {
+		return nil, types.Errorf(types.ErrCorrupt, "checksum mismatch for blob: %s", blob.Id)
+	}
+
+	return blob.Content, nil
 }
 
 // DeleteFreeSpace interfaces with the fs handler, db and cache
 // to freeup deleted space from buckets
-// This is a very costly operation
+// This is a very costly operation, the caller must hold the write lock
 func (s *Server) DeleteFreeSpace() error {
-	// FIXME: Make me a admin route
-	// TODO: Setup admin routing system
-
 	buckets := s.handler.Buckets()
 	s.logger.Info("Starting prune operation: Costly brace for impact")
+	now := time.Now()
 	for id, bucket := range buckets {
 		blobs, err := s.db.GetBlobsInBucket(id)
 		if err != nil {
@@ -352,6 +384,17 @@ func (s *Server) DeleteFreeSpace() error {
 			s.logger.Warn("No blobs found in bucket: " + bucket.Name() + "; Skipping")
 			continue
 		}
+		for i := range blobs {
+			if !blobs[i].Deleted {
+				continue
+			}
+			// Never compact away the bytes of a file under retention or legal hold
+			locked, err := s.db.IsBlobLocked(blobs[i].Id, now)
+			if err != nil || locked {
+				s.logger.Warn("Keeping locked blob with id: " + blobs[i].Id)
+				blobs[i].Deleted = false
+			}
+		}
 		err = s.cache.DeleteBlobs(blobs)
 		if err != nil {
 			s.logger.Warn("Error in Invalidating cache: " + err.Error())
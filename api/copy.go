@@ -0,0 +1,37 @@
+package api
+
+import "github.com/gin-gonic/gin"
+
+// handleCopy copies a file or a whole dir to a new path, writing the bytes into new blobs.
+// Files already at the destination are a conflict, unless overwrite is set in which case they are trashed.
+func (s *Server) handleCopy(c *gin.Context) {
+	authKey := c.GetHeader("Authorization")
+	session, exists := authSession(c)
+	if !exists {
+		s.logger.Error("Unauthorized session: " + authKey)
+		abort(c, 401, "Invalid Authorization or missing session")
+		return
+	}
+
+	src, exists := c.GetPostForm("src")
+	if !exists {
+		abort(c, 400, "src is needed in the post form")
+		return
+	}
+	dst, exists := c.GetPostForm("dst")
+	if !exists {
+		abort(c, 400, "dst is needed in the post form")
+		return
+	}
+	overwrite := c.PostForm("overwrite") == "true"
+	// Files of another user can be copied into the session user's tree when shared with them
+	owner := c.DefaultPostForm("owner", session.UserId)
+
+	copies, err := s.Copy(session, owner, src, dst, overwrite, bypassGovernance(c))
+	if err != nil {
+		abortErr(c, err, "Unable to copy files: "+err.Error())
+		return
+	}
+
+	c.JSON(200, copies)
+}
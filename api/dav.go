@@ -0,0 +1,389 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/newtoallofthis123/noob_store/types"
+	"github.com/newtoallofthis123/noob_store/utils"
+	"golang.org/x/net/webdav"
+)
+
+// davPrefix is where the tree of the user is served over webdav
+const davPrefix = "/dav"
+
+// davMethods are the methods webdav clients send
+var davMethods = []string{"OPTIONS", "GET", "HEAD", "PUT", "DELETE", "PROPFIND", "PROPPATCH", "MKCOL", "COPY", "MOVE", "LOCK", "UNLOCK"}
+
+// handleDav serves the tree of the user over webdav, so that it can be mounted with davfs2 or a desktop file manager
+func (s *Server) handleDav(c *gin.Context) {
+	session, err := s.davSession(c)
+	if err != nil {
+		var locked *LockedOutError
+		var limited *RateLimitedError
+		switch {
+		case errors.As(err, &locked):
+			tooManyRequests(c, "Account is locked after too many failed logins", locked.RetryAfter)
+			return
+		case errors.As(err, &limited):
+			tooManyRequests(c, "Too many attempts", limited.RetryAfter)
+			return
+		}
+		c.Header("WWW-Authenticate", `Basic realm="noob_store", charset="UTF-8"`)
+		abortErr(c, err, "Unable to authenticate: "+err.Error())
+		return
+	}
+	s.limitBandwidth(c, session.UserId)
+
+	// Locks are kept per user, as every user has a tree of their own under the same paths
+	locks, _ := s.davLocks.LoadOrStore(session.UserId, webdav.NewMemLS())
+	handler := &webdav.Handler{
+		Prefix:     davPrefix,
+		FileSystem: davFS{s: s, session: session},
+		LockSystem: locks.(webdav.LockSystem),
+		Logger: func(r *http.Request, err error) {
+			if err != nil {
+				s.logger.Debug("WebDAV " + r.Method + " " + r.URL.Path + " failed with err: " + err.Error())
+			}
+		},
+	}
+	handler.ServeHTTP(c.Writer, c.Request)
+}
+
+// davCredentialTTL is how long verified basic auth credentials are cached, as webdav clients
+// send them along with every request and hashing the password each time would be too slow
+const davCredentialTTL = time.Minute
+
+// davSession authenticates the basic auth of a webdav client. The password is either an api key,
+// with any username, or the password of the user whose email is the username.
+// Accounts with two factor login on have to use an api key.
+func (s *Server) davSession(c *gin.Context) (types.Session, error) {
+	email, password, ok := c.Request.BasicAuth()
+	if !ok {
+		return types.Session{}, types.Errorf(types.ErrUnauthorized, "basic auth is needed")
+	}
+	if strings.HasPrefix(password, apiKeyPrefix) {
+		return s.Authenticate(password)
+	}
+
+	cacheKey := "dav:" + utils.CalHash([]byte(strings.ToLower(email)+"\x00"+password))
+	if session, err := s.cache.GetSession(cacheKey); err == nil {
+		return session, nil
+	}
+
+	// Credentials not cached yet are a login like any other, and count against the same limits
+	if wait, ok := s.allowAuth(c.ClientIP(), email); !ok {
+		return types.Session{}, &RateLimitedError{RetryAfter: wait}
+	}
+	user, err := s.checkPassword(email, password)
+	if errors.Is(err, types.ErrNotFound) {
+		err = types.Errorf(types.ErrUnauthorized, "authorization failed")
+	}
+	if err != nil {
+		return types.Session{}, err
+	}
+	if totp, err := s.db.GetTOTP(user.Id); err == nil && totp.Enabled {
+		return types.Session{}, types.Errorf(types.ErrForbidden, "accounts with two factor login on have to use an api key")
+	}
+	s.loginSucceeded(user.Id)
+
+	session := types.Session{Id: cacheKey, UserId: user.Id}
+	if s.cache.InsertSession(session, davCredentialTTL) == nil {
+		_ = s.cache.TrackSession(davCredentialsKey(user.Id), cacheKey, davCredentialTTL)
+	}
+	return session, nil
+}
+
+// davCredentialsKey is the set of the cached webdav credentials of a user
+func davCredentialsKey(userId string) string {
+	return "dav_credentials:" + userId
+}
+
+// forgetDavCredentials drops the cached webdav credentials of a user, so that an old password
+// stops working right away instead of once its cache entry runs out
+func (s *Server) forgetDavCredentials(userId string) {
+	err := s.cache.DeleteTrackedSessions(davCredentialsKey(userId))
+	if err != nil {
+		s.logger.Error("Unable to drop WebDAV credentials of userId: " + userId + " with err: " + err.Error())
+	}
+}
+
+// davFS is the tree of a user as a webdav.FileSystem, on top of the same operations as the http api
+type davFS struct {
+	s       *Server
+	session types.Session
+}
+
+// davPath turns a webdav path like /docs/a.txt into the metadata path docs/a.txt, the root being .
+func davPath(name string) string {
+	p := strings.TrimPrefix(path.Clean("/"+name), "/")
+	if p == "" {
+		return "."
+	}
+	return p
+}
+
+// davErr turns the errors of the store into the os errors the webdav handler answers with
+func davErr(err error) error {
+	switch {
+	case errors.Is(err, types.ErrNotFound):
+		return os.ErrNotExist
+	case errors.Is(err, types.ErrConflict):
+		return os.ErrExist
+	case errors.Is(err, types.ErrUnauthorized), errors.Is(err, types.ErrForbidden), errors.Is(err, types.ErrLocked):
+		return os.ErrPermission
+	}
+	return err
+}
+
+// stat gets the file or dir at a metadata path
+func (fs davFS) stat(p string) (davInfo, error) {
+	res, err := fs.s.Stat(fs.session, p)
+	if err != nil {
+		return davInfo{}, davErr(err)
+	}
+	if res.Dir != nil {
+		return dirInfo(*res.Dir), nil
+	}
+
+	// Only listings fill in the size of files
+	blob, err := fs.s.db.GetBlobById(res.File.Blob)
+	if err != nil {
+		return davInfo{}, davErr(err)
+	}
+	res.File.Size = blob.Size
+	return fileInfo(*res.File), nil
+}
+
+func (fs davFS) Stat(ctx context.Context, name string) (os.FileInfo, error) {
+	info, err := fs.stat(davPath(name))
+	if err != nil {
+		return nil, err
+	}
+	return info, nil
+}
+
+// Mkdir creates a single dir, which unlike the mkdir of the http api needs its parent to exist
+func (fs davFS) Mkdir(ctx context.Context, name string, perm os.FileMode) error {
+	p := davPath(name)
+	if _, err := fs.stat(p); err == nil {
+		return os.ErrExist
+	}
+	if _, err := fs.s.statDir(fs.session.UserId, filepath.Dir(p)); err != nil {
+		return davErr(err)
+	}
+
+	_, err := fs.s.Mkdir(fs.session, p)
+	return davErr(err)
+}
+
+// OpenFile opens a file or dir for reading, or a file for writing. Written files always
+// start out empty and are stored when closed, replacing the file at the path.
+func (fs davFS) OpenFile(ctx context.Context, name string, flag int, perm os.FileMode) (webdav.File, error) {
+	p := davPath(name)
+	info, err := fs.stat(p)
+
+	if flag&(os.O_WRONLY|os.O_RDWR) != 0 {
+		if err == nil && (info.IsDir() || flag&os.O_EXCL != 0) {
+			return nil, os.ErrExist
+		}
+		if err != nil && (flag&os.O_CREATE == 0 || !errors.Is(err, os.ErrNotExist)) {
+			return nil, err
+		}
+		if _, err := fs.s.statDir(fs.session.UserId, filepath.Dir(p)); err != nil {
+			return nil, davErr(err)
+		}
+		if err := fs.s.checkWrite(fs.session); err != nil {
+			return nil, davErr(err)
+		}
+		return &davWriter{fs: fs, path: p}, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+	if info.IsDir() {
+		return &davDir{fs: fs, path: p, info: info}, nil
+	}
+
+	_, content, err := fs.s.Download(fs.session, info.meta.Id)
+	if err != nil {
+		return nil, davErr(err)
+	}
+	return &davFile{Reader: bytes.NewReader(content), info: info}, nil
+}
+
+func (fs davFS) RemoveAll(ctx context.Context, name string) error {
+	p := davPath(name)
+	info, err := fs.stat(p)
+	if err != nil {
+		return err
+	}
+
+	if info.IsDir() {
+		return davErr(fs.s.Rmdir(fs.session, p, true, false))
+	}
+	return davErr(fs.s.Delete(fs.session, info.meta.Id, false))
+}
+
+// Rename moves a file or dir, the webdav handler removing whatever is at the destination first when overwriting
+func (fs davFS) Rename(ctx context.Context, oldName, newName string) error {
+	_, err := fs.s.Move(fs.session, davPath(oldName), davPath(newName), false, false)
+	return davErr(err)
+}
+
+// readDir lists the immediate sub dirs and files of a dir
+func (fs davFS) readDir(p string) ([]os.FileInfo, error) {
+	dirs, err := fs.s.db.GetChildDirs(fs.session.UserId, p)
+	if err != nil {
+		return nil, davErr(err)
+	}
+
+	infos := make([]os.FileInfo, 0, len(dirs))
+	for _, dir := range dirs {
+		if fs.session.AllowsPath(dir.Path) {
+			infos = append(infos, dirInfo(dir))
+		}
+	}
+
+	// Files are read through the listing, which fills in their sizes
+	opts := types.ListOptions{Dir: p, Sort: "name"}
+	for {
+		page, err := fs.s.List(fs.session, opts)
+		if err != nil {
+			return nil, davErr(err)
+		}
+		for _, meta := range page.Items {
+			infos = append(infos, fileInfo(meta))
+		}
+		if page.NextCursor == "" {
+			return infos, nil
+		}
+		opts.Cursor = page.NextCursor
+	}
+}
+
+// davInfo is the os.FileInfo of a file or dir
+type davInfo struct {
+	name    string
+	size    int64
+	modTime time.Time
+	dir     bool
+	meta    types.Metadata
+}
+
+func fileInfo(meta types.Metadata) davInfo {
+	created, _ := parseTime(meta.CreatedAt)
+	return davInfo{name: meta.Name, size: int64(meta.Size), modTime: created, meta: meta}
+}
+
+func dirInfo(dir types.Dir) davInfo {
+	created, _ := parseTime(dir.CreatedAt)
+	name := filepath.Base(dir.Path)
+	if name == "." {
+		name = "/"
+	}
+	return davInfo{name: name, modTime: created, dir: true}
+}
+
+func (i davInfo) Name() string       { return i.name }
+func (i davInfo) Size() int64        { return i.size }
+func (i davInfo) ModTime() time.Time { return i.modTime }
+func (i davInfo) IsDir() bool        { return i.dir }
+func (i davInfo) Sys() any           { return nil }
+
+func (i davInfo) Mode() os.FileMode {
+	if i.dir {
+		return os.ModeDir | 0o755
+	}
+	return 0o644
+}
+
+// ContentType saves the webdav handler from sniffing the content of files for their mime
+func (i davInfo) ContentType(ctx context.Context) (string, error) {
+	if i.meta.Mime == "" {
+		return "", webdav.ErrNotImplemented
+	}
+	return i.meta.Mime, nil
+}
+
+// davFile is a file opened for reading
+type davFile struct {
+	*bytes.Reader
+	info davInfo
+}
+
+func (f *davFile) Close() error                             { return nil }
+func (f *davFile) Write(p []byte) (int, error)              { return 0, os.ErrPermission }
+func (f *davFile) Readdir(count int) ([]os.FileInfo, error) { return nil, os.ErrInvalid }
+func (f *davFile) Stat() (os.FileInfo, error)               { return f.info, nil }
+
+// davDir is a dir opened for reading its entries
+type davDir struct {
+	fs      davFS
+	path    string
+	info    davInfo
+	entries []os.FileInfo
+	read    bool
+}
+
+func (d *davDir) Close() error                                 { return nil }
+func (d *davDir) Read(p []byte) (int, error)                   { return 0, os.ErrInvalid }
+func (d *davDir) Write(p []byte) (int, error)                  { return 0, os.ErrInvalid }
+func (d *davDir) Seek(offset int64, whence int) (int64, error) { return 0, nil }
+func (d *davDir) Stat() (os.FileInfo, error)                   { return d.info, nil }
+
+// Readdir returns the next count entries, or all of the remaining ones when count is not positive
+func (d *davDir) Readdir(count int) ([]os.FileInfo, error) {
+	if !d.read {
+		entries, err := d.fs.readDir(d.path)
+		if err != nil {
+			return nil, err
+		}
+		d.entries = entries
+		d.read = true
+	}
+
+	if count <= 0 {
+		entries := d.entries
+		d.entries = nil
+		return entries, nil
+	}
+	if len(d.entries) == 0 {
+		return nil, io.EOF
+	}
+	n := min(count, len(d.entries))
+	entries := d.entries[:n]
+	d.entries = d.entries[n:]
+	return entries, nil
+}
+
+// davWriter is a file opened for writing, its content being stored when it is closed
+type davWriter struct {
+	fs      davFS
+	path    string
+	content bytes.Buffer
+}
+
+func (w *davWriter) Write(p []byte) (int, error)                  { return w.content.Write(p) }
+func (w *davWriter) Read(p []byte) (int, error)                   { return 0, os.ErrInvalid }
+func (w *davWriter) Seek(offset int64, whence int) (int64, error) { return 0, os.ErrInvalid }
+func (w *davWriter) Readdir(count int) ([]os.FileInfo, error)     { return nil, os.ErrInvalid }
+
+func (w *davWriter) Stat() (os.FileInfo, error) {
+	return davInfo{name: filepath.Base(w.path), size: int64(w.content.Len()), modTime: time.Now()}, nil
+}
+
+func (w *davWriter) Close() error {
+	_, err := w.fs.s.Upload(w.fs.session, UploadInfo{Path: w.path, Overwrite: true}, w.content.Bytes())
+	return davErr(err)
+}
@@ -0,0 +1,113 @@
+package api
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/newtoallofthis123/noob_store/db"
+	"github.com/newtoallofthis123/noob_store/types"
+)
+
+// statDir gets a dir of the user with its stats, the root dir always exists
+func (s *Server) statDir(userId, path string) (types.Dir, error) {
+	dir := types.Dir{UserId: userId, Path: path, Name: path, Parent: path}
+	if !db.IsRootDir(path) {
+		var err error
+		dir, err = s.db.GetDirByUserPath(userId, path)
+		if err != nil {
+			return types.Dir{}, err
+		}
+	}
+
+	err := s.db.FillDirStats(&dir)
+	return dir, err
+}
+
+func (s *Server) handleMkdir(c *gin.Context) {
+	authKey := c.GetHeader("Authorization")
+	session, exists := authSession(c)
+	if !exists {
+		s.logger.Error("Unauthorized session: " + authKey)
+		abort(c, 401, "Invalid Authorization or missing session")
+		return
+	}
+
+	path, exists := c.GetPostForm("path")
+	if !exists {
+		abort(c, 400, "Path is needed in the post form")
+		return
+	}
+
+	dir, err := s.Mkdir(session, path)
+	if err != nil {
+		abortErr(c, err, "Unable to create dir: "+err.Error())
+		return
+	}
+
+	c.JSON(200, dir)
+}
+
+// handleRmdir removes an empty dir, or with recursive set trashes everything under it
+func (s *Server) handleRmdir(c *gin.Context) {
+	authKey := c.GetHeader("Authorization")
+	session, exists := authSession(c)
+	if !exists {
+		s.logger.Error("Unauthorized session: " + authKey)
+		abort(c, 401, "Invalid Authorization or missing session")
+		return
+	}
+
+	path, exists := c.GetQuery("path")
+	if !exists {
+		abort(c, 400, "Path is needed")
+		return
+	}
+	recursive := c.Query("recursive") == "true"
+
+	err := s.Rmdir(session, path, recursive, bypassGovernance(c))
+	if err != nil {
+		abortErr(c, err, "Unable to remove dir: "+err.Error())
+		return
+	}
+
+	c.JSON(200, gin.H{"success": "Removed dir: " + path})
+}
+
+func (s *Server) handleStat(c *gin.Context) {
+	authKey := c.GetHeader("Authorization")
+	session, exists := authSession(c)
+	if !exists {
+		s.logger.Error("Unauthorized session: " + authKey)
+		abort(c, 401, "Invalid Authorization or missing session")
+		return
+	}
+
+	path := c.DefaultQuery("path", ".")
+
+	res, err := s.Stat(session, path)
+	if err != nil {
+		abortErr(c, err, "Nothing found at path: "+path)
+		return
+	}
+
+	c.JSON(200, res)
+}
+
+// handleDirChildren lists the immediate sub dirs and files of a dir
+func (s *Server) handleDirChildren(c *gin.Context) {
+	authKey := c.GetHeader("Authorization")
+	session, exists := authSession(c)
+	if !exists {
+		s.logger.Error("Unauthorized session: " + authKey)
+		abort(c, 401, "Invalid Authorization or missing session")
+		return
+	}
+
+	path := c.DefaultQuery("path", ".")
+
+	res, err := s.Children(session, path)
+	if err != nil {
+		abortErr(c, err, "Unable to list dir: "+err.Error())
+		return
+	}
+
+	c.JSON(200, res)
+}
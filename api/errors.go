@@ -0,0 +1,93 @@
+package api
+
+import (
+	"errors"
+	"regexp"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/newtoallofthis123/noob_store/types"
+	"github.com/newtoallofthis123/noob_store/utils"
+)
+
+const requestIdKey = "request_id"
+
+// errorKinds maps the error kinds of types to the status and code they are answered with
+var errorKinds = []struct {
+	kind   error
+	status int
+	code   string
+}{
+	{types.ErrInvalid, 400, "invalid_request"},
+	{types.ErrUnauthorized, 401, "unauthorized"},
+	{types.ErrForbidden, 403, "forbidden"},
+	{types.ErrNotFound, 404, "not_found"},
+	{types.ErrConflict, 409, "conflict"},
+	{types.ErrLocked, 423, "locked"},
+	{types.ErrQuotaExceeded, 507, "quota_exceeded"},
+	{types.ErrCorrupt, 500, "corrupt"},
+}
+
+// statusCodes are the codes of statuses without an error kind of their own
+var statusCodes = map[int]string{
+	410: "gone",
+	429: "rate_limited",
+	500: "internal",
+	501: "not_implemented",
+	503: "unavailable",
+}
+
+// codeOf returns the machine readable code of a status
+func codeOf(status int) string {
+	for _, k := range errorKinds {
+		if k.status == status {
+			return k.code
+		}
+	}
+	if code, ok := statusCodes[status]; ok {
+		return code
+	}
+	return "internal"
+}
+
+// abort answers a request with an error in the shape every error of the api has:
+// the message in err, a code that stays the same across versions and the id of the request
+func abort(c *gin.Context, status int, msg string) {
+	abortCode(c, status, codeOf(status), msg)
+}
+
+func abortCode(c *gin.Context, status int, code, msg string) {
+	c.AbortWithStatusJSON(status, types.ErrorRes{Err: msg, Code: code, RequestId: c.GetString(requestIdKey)})
+}
+
+// abortErr answers a request with the status of the kind of err, or 500 if it has none
+func abortErr(c *gin.Context, err error, msg string) {
+	for _, k := range errorKinds {
+		if errors.Is(err, k.kind) {
+			abortCode(c, k.status, k.code, msg)
+			return
+		}
+	}
+	abort(c, 500, msg)
+}
+
+// requestIdPattern is what a request id passed in by a proxy has to look like to be kept
+var requestIdPattern = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+// RequestId returns a gin.HandlerFunc that gives every request an id, sent back in the X-Request-Id header
+// and with every error, so that a failure a client sees can be found in the logs
+func (s *Server) RequestId() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.GetHeader("X-Request-Id")
+		if !requestIdPattern.MatchString(id) {
+			id = utils.RandomToken(8)
+		}
+		c.Set(requestIdKey, id)
+		c.Header("X-Request-Id", id)
+		c.Next()
+
+		if c.Writer.Status() >= 500 {
+			s.logger.Error("Request " + id + " to " + c.Request.Method + " " + c.Request.URL.Path + " failed with status " + strconv.Itoa(c.Writer.Status()))
+		}
+	}
+}
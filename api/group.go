@@ -0,0 +1,440 @@
+package api
+
+import (
+	"path/filepath"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/newtoallofthis123/noob_store/db"
+	"github.com/newtoallofthis123/noob_store/types"
+	"github.com/newtoallofthis123/ranhash"
+)
+
+// isGroupId checks if an owner id is that of a group rather than a user
+func isGroupId(id string) bool {
+	return strings.HasPrefix(id, "g_")
+}
+
+// groupRole gets the role of a user in a group, with the owners and admins of an org
+// being admins of all of its teams. It is empty if the user is not a member.
+func (s *Server) groupRole(userId, groupId string) string {
+	role, _ := s.db.GetMemberRole(groupId, userId)
+	if role == types.RoleOwner || role == types.RoleAdmin {
+		return role
+	}
+
+	group, err := s.db.GetGroup(groupId)
+	if err != nil || group.OrgId == "" {
+		return role
+	}
+	orgRole, _ := s.db.GetMemberRole(group.OrgId, userId)
+	if orgRole == types.RoleOwner || orgRole == types.RoleAdmin {
+		return types.RoleAdmin
+	}
+
+	return role
+}
+
+// ownerFor gets the owner new files of the session user go to, being the group given
+// if the user can write to its storage
+func (s *Server) ownerFor(session types.Session, groupId string) (string, error) {
+	if groupId == "" {
+		return session.UserId, nil
+	}
+
+	if !types.PermissionAllows(types.RolePermission(s.groupRole(session.UserId, groupId)), types.PermWrite) {
+		s.logger.Warn("Prevented Unauthorized upload to group: " + groupId + " by user " + session.UserId)
+		return "", types.Errorf(types.ErrForbidden, "unauthorized access to group: %s", groupId)
+	}
+
+	return groupId, nil
+}
+
+// memberGroup gets the group in the id param if the session user has at least the needed permission on its storage
+func (s *Server) memberGroup(c *gin.Context, session types.Session, needed string) (types.Group, bool) {
+	id := c.Param("id")
+
+	group, err := s.db.GetGroup(id)
+	if err != nil {
+		abortErr(c, err, "Unable to find group")
+		return types.Group{}, false
+	}
+
+	group.Role = s.groupRole(session.UserId, id)
+	if !types.PermissionAllows(types.RolePermission(group.Role), needed) {
+		s.logger.Warn("Prevented Unauthorized access to group: " + id + " by user " + session.UserId)
+		abort(c, 403, "Unauthorized access to group: "+id)
+		return types.Group{}, false
+	}
+
+	return group, true
+}
+
+// handleCreateGroup creates an org, or a team within an org the session user administers
+func (s *Server) handleCreateGroup(c *gin.Context) {
+	authKey := c.GetHeader("Authorization")
+	session, exists := authSession(c)
+	if !exists {
+		s.logger.Error("Unauthorized session: " + authKey)
+		abort(c, 401, "Invalid Authorization or missing session")
+		return
+	}
+
+	name, exists := c.GetPostForm("name")
+	if !exists || name == "" {
+		abort(c, 400, "Name is needed in the post form")
+		return
+	}
+
+	group := types.Group{
+		Id:    "g_" + ranhash.GenerateRandomString(8),
+		Name:  name,
+		Kind:  c.DefaultPostForm("kind", types.GroupOrg),
+		OrgId: c.PostForm("org"),
+	}
+	switch group.Kind {
+	case types.GroupOrg:
+		group.OrgId = ""
+	case types.GroupTeam:
+		if group.OrgId == "" {
+			abort(c, 400, "A team needs the org it belongs to")
+			return
+		}
+		org, err := s.db.GetGroup(group.OrgId)
+		if err != nil || org.Kind != types.GroupOrg {
+			abort(c, 404, "Unable to find org: "+group.OrgId)
+			return
+		}
+		role := s.groupRole(session.UserId, org.Id)
+		if role != types.RoleOwner && role != types.RoleAdmin {
+			abort(c, 403, "Only admins of an org can create teams in it")
+			return
+		}
+	default:
+		abort(c, 400, "kind must be one of org or team")
+		return
+	}
+
+	err := s.db.CreateGroup(group, session.UserId)
+	if err != nil {
+		s.logger.Error("Unable to create group: " + group.Id + " with err: " + err.Error())
+		abortErr(c, err, "Unable to create group: "+err.Error())
+		return
+	}
+
+	group.Role = types.RoleOwner
+	c.JSON(200, group)
+}
+
+func (s *Server) handleUserGroups(c *gin.Context) {
+	authKey := c.GetHeader("Authorization")
+	session, exists := authSession(c)
+	if !exists {
+		s.logger.Error("Unauthorized session: " + authKey)
+		abort(c, 401, "Invalid Authorization or missing session")
+		return
+	}
+
+	groups, err := s.db.GetGroupsByUser(session.UserId)
+	if err != nil {
+		s.logger.Error("Unable to get groups of userId: " + session.UserId + " with err: " + err.Error())
+		abortErr(c, err, "Unable to get groups")
+		return
+	}
+
+	c.JSON(200, groups)
+}
+
+func (s *Server) handleGetGroup(c *gin.Context) {
+	authKey := c.GetHeader("Authorization")
+	session, exists := authSession(c)
+	if !exists {
+		s.logger.Error("Unauthorized session: " + authKey)
+		abort(c, 401, "Invalid Authorization or missing session")
+		return
+	}
+
+	group, ok := s.memberGroup(c, session, types.PermRead)
+	if !ok {
+		return
+	}
+
+	members, err := s.db.GetMembers(group.Id)
+	if err != nil {
+		s.logger.Error("Unable to get members of group: " + group.Id + " with err: " + err.Error())
+		abortErr(c, err, "Unable to get members")
+		return
+	}
+
+	c.JSON(200, gin.H{"group": group, "members": members})
+}
+
+// handleSetMember adds a user to a group, or changes their role. Only owners can make other owners.
+func (s *Server) handleSetMember(c *gin.Context) {
+	authKey := c.GetHeader("Authorization")
+	session, exists := authSession(c)
+	if !exists {
+		s.logger.Error("Unauthorized session: " + authKey)
+		abort(c, 401, "Invalid Authorization or missing session")
+		return
+	}
+
+	group, ok := s.memberGroup(c, session, types.PermOwner)
+	if !ok {
+		return
+	}
+
+	email, exists := c.GetPostForm("email")
+	if !exists {
+		abort(c, 400, "Email of the user to add is needed")
+		return
+	}
+	role := c.DefaultPostForm("role", types.RoleMember)
+	if !types.ValidRole(role) {
+		abort(c, 400, "role must be one of owner, admin, member or viewer")
+		return
+	}
+
+	user, err := s.db.GetUserByEmail(email)
+	if err != nil {
+		abortErr(c, err, "User not found")
+		return
+	}
+
+	current, _ := s.db.GetMemberRole(group.Id, user.Id)
+	if (role == types.RoleOwner || current == types.RoleOwner) && group.Role != types.RoleOwner {
+		abort(c, 403, "Only owners can change the owners of a group")
+		return
+	}
+
+	err = s.db.SetMember(group.Id, user.Id, role)
+	if err != nil {
+		s.logger.Error("Unable to add member to group: " + group.Id + " with err: " + err.Error())
+		abortErr(c, err, "Unable to add member: "+err.Error())
+		return
+	}
+
+	c.JSON(200, types.Member{GroupId: group.Id, UserId: user.Id, Email: user.Email, Role: role})
+}
+
+// handleRemoveMember removes a user from a group, which members can also do to themselves
+func (s *Server) handleRemoveMember(c *gin.Context) {
+	authKey := c.GetHeader("Authorization")
+	session, exists := authSession(c)
+	if !exists {
+		s.logger.Error("Unauthorized session: " + authKey)
+		abort(c, 401, "Invalid Authorization or missing session")
+		return
+	}
+
+	userId := c.Param("user")
+	needed := types.PermOwner
+	if userId == session.UserId {
+		needed = types.PermRead
+	}
+	group, ok := s.memberGroup(c, session, needed)
+	if !ok {
+		return
+	}
+
+	current, err := s.db.GetMemberRole(group.Id, userId)
+	if err != nil {
+		abortErr(c, err, "User is not a member of the group")
+		return
+	}
+	if current == types.RoleOwner {
+		if group.Role != types.RoleOwner {
+			abort(c, 403, "Only owners can change the owners of a group")
+			return
+		}
+		members, err := s.db.GetMembers(group.Id)
+		if err != nil {
+			abortErr(c, err, "Unable to get members")
+			return
+		}
+		owners := 0
+		for _, member := range members {
+			if member.Role == types.RoleOwner {
+				owners++
+			}
+		}
+		if owners <= 1 {
+			abort(c, 409, "Can not remove the last owner of a group")
+			return
+		}
+	}
+
+	err = s.db.DeleteMember(group.Id, userId)
+	if err != nil {
+		s.logger.Error("Unable to remove member from group: " + group.Id + " with err: " + err.Error())
+		abortErr(c, err, "Unable to remove member")
+		return
+	}
+
+	c.JSON(200, gin.H{"success": "Removed user " + userId + " from group " + group.Id})
+}
+
+func (s *Server) handleGroupLs(c *gin.Context) {
+	authKey := c.GetHeader("Authorization")
+	session, exists := authSession(c)
+	if !exists {
+		s.logger.Error("Unauthorized session: " + authKey)
+		abort(c, 401, "Invalid Authorization or missing session")
+		return
+	}
+
+	group, ok := s.memberGroup(c, session, types.PermRead)
+	if !ok {
+		return
+	}
+
+	opts, err := parseListOptions(c)
+	if err != nil {
+		abortErr(c, err, err.Error())
+		return
+	}
+	opts.Shared = false
+
+	res, err := s.db.ListMetadata(group.Id, opts)
+	if err != nil {
+		s.logger.Error("Unable to fetch files of group: " + group.Id + " with err: " + err.Error())
+		abortErr(c, err, "Unable to fetch group files: "+err.Error())
+		return
+	}
+
+	c.JSON(200, res)
+}
+
+func (s *Server) handleGroupUsage(c *gin.Context) {
+	authKey := c.GetHeader("Authorization")
+	session, exists := authSession(c)
+	if !exists {
+		s.logger.Error("Unauthorized session: " + authKey)
+		abort(c, 401, "Invalid Authorization or missing session")
+		return
+	}
+
+	group, ok := s.memberGroup(c, session, types.PermRead)
+	if !ok {
+		return
+	}
+
+	usage, err := s.db.GetUsage(group.Id)
+	if err != nil {
+		s.logger.Error("Unable to get usage of group: " + group.Id + " with err: " + err.Error())
+		abortErr(c, err, "Unable to get usage")
+		return
+	}
+
+	c.JSON(200, gin.H{"usage": usage, "quota": s.quotaOf(group.Id)})
+}
+
+// handleTransfer moves the files under a path into the storage of the group. Admins of the group can take over
+// the files of any of its members, such as those of someone leaving, while members can only give their own.
+func (s *Server) handleTransfer(c *gin.Context) {
+	authKey := c.GetHeader("Authorization")
+	session, exists := authSession(c)
+	if !exists {
+		s.logger.Error("Unauthorized session: " + authKey)
+		abort(c, 401, "Invalid Authorization or missing session")
+		return
+	}
+
+	group, ok := s.memberGroup(c, session, types.PermWrite)
+	if !ok {
+		return
+	}
+
+	path, exists := c.GetPostForm("path")
+	if !exists {
+		abort(c, 400, "Path is needed in the post form")
+		return
+	}
+	path = filepath.Clean(path)
+
+	from := c.DefaultPostForm("from", session.UserId)
+	if from != session.UserId {
+		if group.Role != types.RoleOwner && group.Role != types.RoleAdmin {
+			abort(c, 403, "Only admins of a group can transfer the files of others")
+			return
+		}
+		if isGroupId(from) {
+			abort(c, 400, "Files can only be transferred from members")
+			return
+		}
+		if _, err := s.db.GetMemberRole(group.Id, from); err != nil {
+			abort(c, 404, "User is not a member of the group")
+			return
+		}
+	}
+
+	if db.IsRootDir(path) {
+		abort(c, 400, "Can not transfer the root dir")
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var moved []types.Metadata
+	file, err := s.db.GetMetaDataByUserPath(from, path)
+	if err == nil {
+		moved = []types.Metadata{file}
+	} else {
+		moved, err = s.db.GetMetadataSubtreeByUser(from, path)
+		if err != nil {
+			s.logger.Error("Unable to find files under: " + path + " with err: " + err.Error())
+			abortErr(c, err, "Unable to find files to transfer")
+			return
+		}
+	}
+	if len(moved) == 0 {
+		abort(c, 404, "Nothing found at path: "+path)
+		return
+	}
+
+	var bytes int64
+	for _, meta := range moved {
+		if _, err := s.db.GetMetaDataByUserPath(group.Id, meta.Path); err == nil {
+			abort(c, 409, "Path already exists in the group: "+meta.Path)
+			return
+		}
+		blob, err := s.db.GetBlobById(meta.Blob)
+		if err != nil {
+			s.logger.Error("Unable to find blob: " + meta.Blob + " with err: " + err.Error())
+			abortErr(c, err, "Unable to find file: "+meta.Path)
+			return
+		}
+		bytes += int64(blob.Size)
+	}
+	objects := int64(len(moved))
+
+	err = s.checkQuota(group.Id, bytes, objects)
+	if err != nil {
+		abortErr(c, err, err.Error())
+		return
+	}
+
+	err = s.db.TransferOwnership(from, group.Id, path, moved)
+	if err != nil {
+		s.logger.Error("Unable to transfer path: " + path + " to group: " + group.Id + " with err: " + err.Error())
+		abortErr(c, err, "Unable to transfer: "+err.Error())
+		return
+	}
+
+	err = s.db.AddUsage(from, -bytes, -objects)
+	if err == nil {
+		err = s.db.AddUsage(group.Id, bytes, objects)
+	}
+	if err != nil {
+		s.logger.Error("Unable to update usage after transfer with err: " + err.Error())
+	}
+	for _, meta := range moved {
+		err = s.cache.DeleteMetadata(meta.Id)
+		if err != nil {
+			s.logger.Error("Unable to drop metadata from cache: " + meta.Id + " with err: " + err.Error())
+		}
+	}
+
+	c.JSON(200, gin.H{"success": "Transferred files to group " + group.Id, "count": objects})
+}
@@ -0,0 +1,187 @@
+package api
+
+import (
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/newtoallofthis123/noob_store/types"
+	"github.com/newtoallofthis123/ranhash"
+)
+
+func (s *Server) handleCreateLifecycleRule(c *gin.Context) {
+	authKey := c.GetHeader("Authorization")
+	session, exists := authSession(c)
+	if !exists {
+		s.logger.Error("Unauthorized session: " + authKey)
+		abort(c, 401, "Invalid Authorization or missing session")
+		return
+	}
+
+	days, err := strconv.Atoi(c.PostForm("expire_days"))
+	if err != nil || days <= 0 {
+		abort(c, 400, "expire_days is needed as a positive number of days")
+		return
+	}
+
+	rule := types.LifecycleRule{
+		Id:         ranhash.GenerateRandomString(8),
+		UserId:     session.UserId,
+		Prefix:     c.PostForm("prefix"),
+		Bucket:     c.PostForm("bucket"),
+		Tags:       prefixedFields(c, "tag-", ""),
+		ExpireDays: days,
+	}
+	err = validateTags(rule.Tags)
+	if err != nil {
+		abortErr(c, err, err.Error())
+		return
+	}
+
+	if rule.Bucket != "" {
+		if _, ok := s.handler.Buckets()[rule.Bucket]; !ok {
+			abort(c, 404, "No bucket found with id: "+rule.Bucket)
+			return
+		}
+	}
+
+	err = s.db.CreateLifecycleRule(rule)
+	if err != nil {
+		s.logger.Error("Failed to create lifecycle rule for userId: " + session.UserId + " with err: " + err.Error())
+		abortErr(c, err, "Error creating lifecycle rule: "+err.Error())
+		return
+	}
+
+	c.JSON(200, rule)
+}
+
+func (s *Server) handleUserLifecycleRules(c *gin.Context) {
+	authKey := c.GetHeader("Authorization")
+	session, exists := authSession(c)
+	if !exists {
+		s.logger.Error("Unauthorized session: " + authKey)
+		abort(c, 401, "Invalid Authorization or missing session")
+		return
+	}
+
+	rules, err := s.db.GetLifecycleRules(session.UserId)
+	if err != nil {
+		s.logger.Error("Unable to fetch lifecycle rules for userId: " + session.UserId + " with err: " + err.Error())
+		abortErr(c, err, "Unable to fetch lifecycle rules")
+		return
+	}
+
+	c.JSON(200, rules)
+}
+
+// ownedLifecycleRule gets the lifecycle rule in the id param if it belongs to the session user
+func (s *Server) ownedLifecycleRule(c *gin.Context, session types.Session) (types.LifecycleRule, bool) {
+	id := c.Param("id")
+	rule, err := s.db.GetLifecycleRuleById(id)
+	if err != nil {
+		s.logger.Error("Unable to find lifecycle rule with id: " + id + " with err: " + err.Error())
+		abortErr(c, err, "Unable to find lifecycle rule")
+		return types.LifecycleRule{}, false
+	}
+
+	if rule.UserId != session.UserId {
+		s.logger.Warn("Prevented Unauthorized access of lifecycle rule: " + id + " by user " + session.UserId)
+		abort(c, 403, "Unauthorized lifecycle rule access")
+		return types.LifecycleRule{}, false
+	}
+
+	return rule, true
+}
+
+func (s *Server) handleDeleteLifecycleRule(c *gin.Context) {
+	authKey := c.GetHeader("Authorization")
+	session, exists := authSession(c)
+	if !exists {
+		s.logger.Error("Unauthorized session: " + authKey)
+		abort(c, 401, "Invalid Authorization or missing session")
+		return
+	}
+
+	rule, ok := s.ownedLifecycleRule(c, session)
+	if !ok {
+		return
+	}
+
+	err := s.db.DeleteLifecycleRuleById(rule.Id)
+	if err != nil {
+		s.logger.Error("Unable to delete lifecycle rule with id: " + rule.Id + " with err: " + err.Error())
+		abortErr(c, err, "Unable to delete lifecycle rule")
+		return
+	}
+
+	c.JSON(200, gin.H{"success": "Deleted lifecycle rule with id: " + rule.Id})
+}
+
+// handleLifecycleDryRun reports the files that a lifecycle rule would delete right now
+func (s *Server) handleLifecycleDryRun(c *gin.Context) {
+	authKey := c.GetHeader("Authorization")
+	session, exists := authSession(c)
+	if !exists {
+		s.logger.Error("Unauthorized session: " + authKey)
+		abort(c, 401, "Invalid Authorization or missing session")
+		return
+	}
+
+	rule, ok := s.ownedLifecycleRule(c, session)
+	if !ok {
+		return
+	}
+
+	metas, err := s.db.GetExpiredByRule(rule, time.Now())
+	if err != nil {
+		s.logger.Error("Unable to evaluate lifecycle rule with id: " + rule.Id + " with err: " + err.Error())
+		abortErr(c, err, "Unable to evaluate lifecycle rule")
+		return
+	}
+
+	expired := make([]types.Metadata, 0)
+	now := time.Now()
+	for _, meta := range metas {
+		if !meta.Locked(now, false) {
+			expired = append(expired, meta)
+		}
+	}
+
+	c.JSON(200, gin.H{"rule": rule, "expired": expired})
+}
+
+// ApplyLifecycleRules permanently deletes every file expired by a lifecycle rule
+func (s *Server) ApplyLifecycleRules() error {
+	rules, err := s.db.GetLifecycleRules("")
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	for _, rule := range rules {
+		metas, err := s.db.GetExpiredByRule(rule, now)
+		if err != nil {
+			return err
+		}
+
+		s.mu.Lock()
+		for _, meta := range metas {
+			err = s.purgeMetadata(meta)
+			if errors.Is(err, errObjectLocked) {
+				err = nil
+				continue
+			}
+			if err != nil {
+				break
+			}
+			s.logger.Info("Expired file with id: " + meta.Id + " by lifecycle rule: " + rule.Id)
+		}
+		s.mu.Unlock()
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
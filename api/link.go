@@ -0,0 +1,384 @@
+package api
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/newtoallofthis123/noob_store/types"
+	"github.com/newtoallofthis123/noob_store/utils"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// maxPresignExpiry is the longest a presigned url can stay valid
+const maxPresignExpiry = 7 * 24 * time.Hour
+
+// signature signs the parts of a presigned url with the signing key
+func (s *Server) signature(parts ...string) string {
+	mac := hmac.New(sha256.New, s.signingKey)
+	mac.Write([]byte(strings.Join(parts, "\n")))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// verifySignature checks the expiry and the signature of the parts of a presigned url
+func (s *Server) verifySignature(c *gin.Context, parts ...string) bool {
+	exp, err := strconv.ParseInt(c.Query("exp"), 10, 64)
+	if err != nil || time.Now().Unix() > exp {
+		return false
+	}
+
+	expected := s.signature(append(parts, c.Query("exp"))...)
+	return hmac.Equal([]byte(expected), []byte(c.Query("sig")))
+}
+
+// parseExpiry reads an expiry in seconds from the post form, bounded by max
+func parseExpiry(c *gin.Context, fallback, max time.Duration) (time.Duration, error) {
+	v, exists := c.GetPostForm("expires_in")
+	if !exists {
+		return fallback, nil
+	}
+
+	n, err := strconv.Atoi(v)
+	if err != nil || n <= 0 || time.Duration(n)*time.Second > max {
+		return 0, types.Errorf(types.ErrInvalid, "expires_in must be a positive number of seconds up to %d", int(max.Seconds()))
+	}
+
+	return time.Duration(n) * time.Second, nil
+}
+
+// handlePresign creates a url signed for a single operation on a single object.
+// A GET url downloads the file with the given id and a PUT url uploads a file at the given path.
+func (s *Server) handlePresign(c *gin.Context) {
+	authKey := c.GetHeader("Authorization")
+	session, exists := authSession(c)
+	if !exists {
+		s.logger.Error("Unauthorized session: " + authKey)
+		abort(c, 401, "Invalid Authorization or missing session")
+		return
+	}
+
+	expiry, err := parseExpiry(c, time.Hour, maxPresignExpiry)
+	if err != nil {
+		abortErr(c, err, err.Error())
+		return
+	}
+	exp := strconv.FormatInt(time.Now().Add(expiry).Unix(), 10)
+
+	var signed string
+	switch c.PostForm("op") {
+	case "GET":
+		id := c.PostForm("id")
+		meta, err := s.db.GetMetaDataById(id)
+		if err != nil {
+			abortErr(c, err, "Failed to retrieve metadata: "+err.Error())
+			return
+		}
+		if !s.authorize(session, meta, types.PermRead) {
+			abort(c, 403, "Unauthorized access to file from userId: "+session.UserId)
+			return
+		}
+
+		query := url.Values{"user": {session.UserId}, "exp": {exp}, "sig": {s.signature("GET", id, session.UserId, exp)}}
+		signed = "/signed/" + url.PathEscape(id) + "?" + query.Encode()
+	case "PUT":
+		path, exists := c.GetPostForm("path")
+		if !exists {
+			abort(c, 400, "Path is needed in the post form")
+			return
+		}
+		if !session.AllowsPath(path) {
+			abort(c, 403, "Path is outside of the api key prefix")
+			return
+		}
+		if err := s.checkWrite(session); err != nil {
+			abortErr(c, err, "Unable to presign upload: "+err.Error())
+			return
+		}
+
+		query := url.Values{"path": {path}, "user": {session.UserId}, "exp": {exp}, "sig": {s.signature("PUT", path, session.UserId, exp)}}
+		signed = "/signed/upload?" + query.Encode()
+	default:
+		abort(c, 400, "op must be one of GET or PUT")
+		return
+	}
+
+	c.JSON(200, gin.H{"url": signed, "expires_at": time.Now().Add(expiry).Format(time.RFC3339)})
+}
+
+// handleSignedDownload downloads a file with a presigned GET url.
+// The permission of the signer is checked again so that unsharing a file also kills its urls.
+func (s *Server) handleSignedDownload(c *gin.Context) {
+	id := c.Param("id")
+	userId := c.Query("user")
+	if !s.verifySignature(c, "GET", id, userId) {
+		abort(c, 403, "Invalid or expired signature")
+		return
+	}
+
+	meta, err := s.db.GetMetaDataById(id)
+	if err != nil {
+		abortErr(c, err, "Failed to retrieve metadata: "+err.Error())
+		return
+	}
+	if !s.authorize(types.Session{UserId: userId}, meta, types.PermRead) {
+		abort(c, 403, "Unauthorized access to file from userId: "+userId)
+		return
+	}
+
+	s.serveFile(c, meta)
+}
+
+// handleSignedUpload uploads the request body as a file with a presigned PUT url.
+// Like downloads, the signer is checked again so that deleted or read only users can not keep uploading.
+func (s *Server) handleSignedUpload(c *gin.Context) {
+	path := c.Query("path")
+	userId := c.Query("user")
+	if !s.verifySignature(c, "PUT", path, userId) {
+		abort(c, 403, "Invalid or expired signature")
+		return
+	}
+
+	signer := types.Session{UserId: userId}
+	if !signer.AllowsPath(path) {
+		abort(c, 403, "Unauthorized upload from userId: "+userId)
+		return
+	}
+	if err := s.checkWrite(signer); err != nil {
+		s.logger.Warn("Prevented signed upload to path: " + path + " by userId: " + userId + " with err: " + err.Error())
+		abort(c, 403, "Unauthorized upload from userId: "+userId)
+		return
+	}
+
+	content, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		abortErr(c, err, "Unable to read file: "+err.Error())
+		return
+	}
+
+	attrs := fileAttrs{
+		Mime:     c.GetHeader("Content-Type"),
+		UserMeta: prefixedFields(c, "", "X-Meta-"),
+		Tags:     prefixedFields(c, "", "X-Tag-"),
+	}
+	err = validateUserMeta(attrs.UserMeta)
+	if err == nil {
+		err = validateTags(attrs.Tags)
+	}
+	if err != nil {
+		abortErr(c, err, err.Error())
+		return
+	}
+
+	meta, err := s.addFile(userId, path, content, attrs)
+	if err != nil {
+		abortErr(c, err, "Unable to insert file: "+err.Error())
+		return
+	}
+
+	c.JSON(200, meta)
+}
+
+// handleCreateLink creates a public share link for a file, with an optional password, expiry and download limit
+func (s *Server) handleCreateLink(c *gin.Context) {
+	authKey := c.GetHeader("Authorization")
+	session, exists := authSession(c)
+	if !exists {
+		s.logger.Error("Unauthorized session: " + authKey)
+		abort(c, 401, "Invalid Authorization or missing session")
+		return
+	}
+
+	id := c.PostForm("id")
+	meta, err := s.db.GetMetaDataById(id)
+	if err != nil {
+		abortErr(c, err, "Failed to retrieve metadata: "+err.Error())
+		return
+	}
+	if !s.authorize(session, meta, types.PermOwner) {
+		s.logger.Warn("Prevented Unauthorized link creation for file: " + id + " by user " + session.UserId)
+		abort(c, 403, "Unauthorized access to file from userId: "+session.UserId)
+		return
+	}
+
+	link := types.ShareLink{
+		Id:         utils.RandomToken(16),
+		MetadataId: meta.Id,
+		UserId:     session.UserId,
+	}
+
+	if max := c.PostForm("max_downloads"); max != "" {
+		link.MaxDownloads, err = strconv.Atoi(max)
+		if err != nil || link.MaxDownloads < 0 {
+			abort(c, 400, "max_downloads must be a positive number")
+			return
+		}
+	}
+
+	if password := c.PostForm("password"); password != "" {
+		hash, err := bcrypt.GenerateFromPassword([]byte(password), s.env.BcryptCost)
+		if err != nil {
+			abortErr(c, err, "Password hashing failed with err: "+err.Error())
+			return
+		}
+		link.Password = string(hash)
+		link.HasPassword = true
+	}
+
+	var expiresAt *time.Time
+	if _, exists := c.GetPostForm("expires_in"); exists {
+		expiry, err := parseExpiry(c, 0, 365*24*time.Hour)
+		if err != nil {
+			abortErr(c, err, err.Error())
+			return
+		}
+		t := time.Now().Add(expiry)
+		expiresAt = &t
+		link.ExpiresAt = t.Format(time.RFC3339)
+	}
+
+	err = s.db.CreateShareLink(link, expiresAt)
+	if err != nil {
+		s.logger.Error("Unable to create share link for file: " + meta.Id + " with err: " + err.Error())
+		abortErr(c, err, "Unable to create share link: "+err.Error())
+		return
+	}
+
+	c.JSON(200, gin.H{"link": link, "url": "/l/" + link.Id})
+}
+
+func (s *Server) handleUserLinks(c *gin.Context) {
+	authKey := c.GetHeader("Authorization")
+	session, exists := authSession(c)
+	if !exists {
+		s.logger.Error("Unauthorized session: " + authKey)
+		abort(c, 401, "Invalid Authorization or missing session")
+		return
+	}
+
+	links, err := s.db.GetShareLinksByUser(session.UserId)
+	if err != nil {
+		s.logger.Error("Unable to get share links of userId: " + session.UserId + " with err: " + err.Error())
+		abortErr(c, err, "Unable to get share links")
+		return
+	}
+
+	c.JSON(200, links)
+}
+
+// ownedLink gets the share link in the id param if it was created by the session user
+func (s *Server) ownedLink(c *gin.Context, session types.Session) (types.ShareLink, bool) {
+	id := c.Param("id")
+	link, err := s.db.GetShareLinkById(id)
+	if err != nil {
+		abortErr(c, err, "Unable to find share link")
+		return types.ShareLink{}, false
+	}
+
+	if link.UserId != session.UserId {
+		s.logger.Warn("Prevented Unauthorized access of share link by user " + session.UserId)
+		abort(c, 403, "Unauthorized share link access")
+		return types.ShareLink{}, false
+	}
+
+	return link, true
+}
+
+func (s *Server) handleRevokeLink(c *gin.Context) {
+	authKey := c.GetHeader("Authorization")
+	session, exists := authSession(c)
+	if !exists {
+		s.logger.Error("Unauthorized session: " + authKey)
+		abort(c, 401, "Invalid Authorization or missing session")
+		return
+	}
+
+	link, ok := s.ownedLink(c, session)
+	if !ok {
+		return
+	}
+
+	err := s.db.RevokeShareLink(link.Id)
+	if err != nil {
+		s.logger.Error("Unable to revoke share link with err: " + err.Error())
+		abortErr(c, err, "Unable to revoke share link")
+		return
+	}
+
+	c.JSON(200, gin.H{"success": "Revoked share link"})
+}
+
+func (s *Server) handleLinkEvents(c *gin.Context) {
+	authKey := c.GetHeader("Authorization")
+	session, exists := authSession(c)
+	if !exists {
+		s.logger.Error("Unauthorized session: " + authKey)
+		abort(c, 401, "Invalid Authorization or missing session")
+		return
+	}
+
+	link, ok := s.ownedLink(c, session)
+	if !ok {
+		return
+	}
+
+	events, err := s.db.GetLinkEvents(link.Id)
+	if err != nil {
+		s.logger.Error("Unable to get share link events with err: " + err.Error())
+		abortErr(c, err, "Unable to get share link events")
+		return
+	}
+
+	c.JSON(200, events)
+}
+
+// handlePublicLink downloads the file of a share link without any authorization,
+// recording every attempt in the audit log of the link. The password of a link is taken from
+// the X-Link-Password header, or from the form a browser POSTs to the link.
+func (s *Server) handlePublicLink(c *gin.Context) {
+	link, err := s.db.GetShareLinkById(c.Param("token"))
+	if err != nil {
+		abortErr(c, err, "Invalid share link")
+		return
+	}
+
+	event := types.LinkEvent{LinkId: link.Id, Ip: c.ClientIP(), UserAgent: c.Request.UserAgent()}
+	fail := func(status int, reason string) {
+		event.Reason = reason
+		_ = s.db.InsertLinkEvent(event)
+		abort(c, status, "Invalid share link: "+reason)
+	}
+
+	if link.HasPassword {
+		// Never from the query, as urls end up in logs, history and referers
+		password := c.GetHeader("X-Link-Password")
+		if password == "" {
+			password = c.PostForm("password")
+		}
+		if bcrypt.CompareHashAndPassword([]byte(link.Password), []byte(password)) != nil {
+			fail(401, "wrong password")
+			return
+		}
+	}
+
+	ok, err := s.db.UseShareLink(link.Id, time.Now())
+	if err != nil || !ok {
+		fail(410, "revoked, expired or out of downloads")
+		return
+	}
+
+	meta, err := s.db.GetMetaDataById(link.MetadataId)
+	if err != nil {
+		fail(404, "file no longer exists")
+		return
+	}
+
+	event.Ok = true
+	_ = s.db.InsertLinkEvent(event)
+	s.serveFile(c, meta)
+}
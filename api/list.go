@@ -0,0 +1,84 @@
+package api
+
+import (
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/newtoallofthis123/noob_store/types"
+	"github.com/newtoallofthis123/noob_store/utils"
+)
+
+// parseTime parses either an RFC3339 timestamp or a plain date
+func parseTime(s string) (time.Time, error) {
+	t, err := time.Parse(time.RFC3339, s)
+	if err == nil {
+		return t, nil
+	}
+	return time.Parse(time.DateOnly, s)
+}
+
+// parseListOptions reads the pagination, sorting and filtering of a listing from the query
+func parseListOptions(c *gin.Context) (types.ListOptions, error) {
+	opts := types.ListOptions{
+		Cursor:    c.Query("cursor"),
+		Sort:      c.DefaultQuery("sort", "name"),
+		Desc:      c.Query("order") == "desc",
+		Prefix:    c.Query("prefix"),
+		Delimiter: c.Query("delimiter"),
+		Mime:      c.Query("mime"),
+		Shared:    c.Query("shared") == "true",
+	}
+
+	// Listings of api keys limited to a path prefix only go under it
+	if session, exists := c.Get(sessionKey); exists {
+		prefix := session.(types.Session).PathPrefix
+		if prefix != "" && !strings.HasPrefix(opts.Prefix, prefix+"/") {
+			opts.Prefix = prefix + "/"
+		}
+	}
+
+	if dir, exists := c.GetQuery("dir"); exists {
+		opts.Dir = filepath.Clean(dir)
+	}
+
+	if filters, exists := c.GetQueryArray("tag"); exists {
+		tags, err := parseTagFilter(filters)
+		if err != nil {
+			return opts, err
+		}
+		opts.Tags = tags
+	}
+
+	if limit, exists := c.GetQuery("limit"); exists {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n <= 0 {
+			return opts, types.Errorf(types.ErrInvalid, "limit must be a positive number")
+		}
+		opts.Limit = n
+	}
+
+	for param, dst := range map[string]**uint64{"min_size": &opts.MinSize, "max_size": &opts.MaxSize} {
+		if v, exists := c.GetQuery(param); exists {
+			n, err := utils.ParseSize(v)
+			if err != nil {
+				return opts, types.Errorf(types.ErrInvalid, "%s must be a size like 512 or 10MB", param)
+			}
+			*dst = &n
+		}
+	}
+
+	for param, dst := range map[string]**time.Time{"after": &opts.After, "before": &opts.Before} {
+		if v, exists := c.GetQuery(param); exists {
+			t, err := parseTime(v)
+			if err != nil {
+				return opts, types.Errorf(types.ErrInvalid, "%s must be an RFC3339 timestamp or a date", param)
+			}
+			*dst = &t
+		}
+	}
+
+	return opts, nil
+}
@@ -0,0 +1,166 @@
+package api
+
+import (
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/newtoallofthis123/noob_store/types"
+)
+
+const (
+	sessionKey = "session"
+	userKey    = "user"
+)
+
+// readOnlyAllowed are the routes read-only users can still call with methods other than GET,
+// as they only affect their own sessions and credentials
+var readOnlyAllowed = []string{"/user/logout", "/user/sessions", "/user/sessions/:id",
+	"/user/2fa", "/user/2fa/enroll", "/user/2fa/confirm", "/user/2fa/recovery_codes", "/user/password", "/user/verify/send"}
+
+// ResolveAuth returns a gin.HandlerFunc that authenticates the Authorization header of a request once,
+// storing the session in the context for the middleware and handlers after it to read with authSession.
+// Requests without a valid session are let through, for the routes that need one to turn away.
+func (s *Server) ResolveAuth() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if session, exists := s.checkAuth(c.GetHeader("Authorization")); exists {
+			c.Set(sessionKey, session)
+		}
+		c.Next()
+	}
+}
+
+// authSession gets the session ResolveAuth stored in the context, if the request had a valid one
+func authSession(c *gin.Context) (types.Session, bool) {
+	session, exists := c.Get(sessionKey)
+	if !exists {
+		return types.Session{}, false
+	}
+	return session.(types.Session), true
+}
+
+// requireAuth returns a gin.HandlerFunc that aborts requests without a valid session
+func (s *Server) requireAuth() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		authKey := c.GetHeader("Authorization")
+		if _, exists := authSession(c); !exists {
+			s.logger.Error("Unauthorized session: " + authKey)
+			abort(c, 401, "Invalid Authorization or missing session")
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// requireRole returns a gin.HandlerFunc that aborts requests from users without one of the roles.
+// It must run after requireAuth and stores the user in the context.
+func (s *Server) requireRole(roles ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		session := c.MustGet(sessionKey).(types.Session)
+
+		user, err := s.db.GetUser(session.UserId)
+		if err != nil {
+			s.logger.Error("Unable to get user with id: " + session.UserId + " with err: " + err.Error())
+			abortErr(c, err, "No valid user found for sessionId "+session.Id)
+			return
+		}
+		if !slices.Contains(roles, user.Role) {
+			s.logger.Warn("Prevented Unauthorized access to " + c.FullPath() + " from userId " + user.Id)
+			abort(c, 403, "Unauthorized access from userId: "+user.Id)
+			return
+		}
+
+		c.Set(userKey, user)
+		c.Next()
+	}
+}
+
+// keyForbidden are the routes api keys can not call, as they manage the credentials of the user
+var keyForbidden = []string{"/user/logout", "/user/sessions", "/user/sessions/:id", "/user/keys", "/user/keys/:id",
+	"/user/2fa", "/user/2fa/enroll", "/user/2fa/confirm", "/user/2fa/recovery_codes", "/user/password", "/user/verify/send", "/user"}
+
+// keyAccountWide are the routes keys limited to a path prefix can not call, as they work on the whole account
+// rather than on the paths they name. The routes of groups are account wide as well.
+var keyAccountWide = []string{"/user/trash", "/user/trash/restore/:id", "/user/lifecycle", "/user/lifecycle/:id",
+	"/user/lifecycle/:id/dry_run", "/share", "/share/:id", "/links", "/links/:id", "/links/:id/events"}
+
+// keyPathFields are the form, query and route fields holding the paths a request works on
+var keyPathFields = []string{"path", "src", "dst", "dir"}
+
+// ApiKeyGuard returns a gin.HandlerFunc that holds requests authenticated with an api key to its scope.
+// Read keys can only make GET requests and keys limited to a path prefix can only name paths under it,
+// with no access to the account wide routes.
+func (s *Server) ApiKeyGuard() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !strings.HasPrefix(strings.Replace(c.GetHeader("Authorization"), "Bearer ", "", 1), apiKeyPrefix) {
+			c.Next()
+			return
+		}
+		session, exists := authSession(c)
+		if !exists {
+			c.Next()
+			return
+		}
+
+		if slices.Contains(keyForbidden, c.FullPath()) || strings.HasPrefix(c.FullPath(), "/admin") {
+			abort(c, 403, "Api keys can not access "+c.FullPath())
+			return
+		}
+		if session.PathPrefix != "" && (slices.Contains(keyAccountWide, c.FullPath()) || strings.HasPrefix(c.FullPath(), "/groups")) {
+			abort(c, 403, "Api keys limited to a path prefix can not access "+c.FullPath())
+			return
+		}
+		switch c.Request.Method {
+		case "GET", "HEAD", "OPTIONS":
+		default:
+			if session.Scope != types.PermWrite {
+				abort(c, 403, "Api key is read only")
+				return
+			}
+		}
+
+		for _, field := range keyPathFields {
+			values := []string{c.Param(field), c.Query(field), c.PostForm(field)}
+			for _, v := range values {
+				if v != "" && !session.AllowsPath(filepath.Clean(v)) {
+					abort(c, 403, "Api key can not access path: "+v)
+					return
+				}
+			}
+		}
+
+		c.Next()
+	}
+}
+
+// ReadOnlyGuard returns a gin.HandlerFunc that aborts any request that could change the store
+// when it comes from a read-only user
+func (s *Server) ReadOnlyGuard() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		switch c.Request.Method {
+		case "GET", "HEAD", "OPTIONS":
+			c.Next()
+			return
+		}
+		if slices.Contains(readOnlyAllowed, c.FullPath()) {
+			c.Next()
+			return
+		}
+
+		session, exists := authSession(c)
+		if !exists {
+			c.Next()
+			return
+		}
+		user, err := s.db.GetUser(session.UserId)
+		if err == nil && user.Role == types.UserReadOnly {
+			s.logger.Warn("Prevented write to " + c.FullPath() + " from read only userId " + user.Id)
+			abort(c, 403, "User is read only")
+			return
+		}
+
+		c.Next()
+	}
+}
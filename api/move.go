@@ -0,0 +1,35 @@
+package api
+
+import "github.com/gin-gonic/gin"
+
+// handleMove moves or renames a file or a whole dir without touching the blobs.
+// Files already at the destination are a conflict, unless overwrite is set in which case they are trashed.
+func (s *Server) handleMove(c *gin.Context) {
+	authKey := c.GetHeader("Authorization")
+	session, exists := authSession(c)
+	if !exists {
+		s.logger.Error("Unauthorized session: " + authKey)
+		abort(c, 401, "Invalid Authorization or missing session")
+		return
+	}
+
+	src, exists := c.GetPostForm("src")
+	if !exists {
+		abort(c, 400, "src is needed in the post form")
+		return
+	}
+	dst, exists := c.GetPostForm("dst")
+	if !exists {
+		abort(c, 400, "dst is needed in the post form")
+		return
+	}
+	overwrite := c.PostForm("overwrite") == "true"
+
+	moved, err := s.Move(session, src, dst, overwrite, bypassGovernance(c))
+	if err != nil {
+		abortErr(c, err, "Unable to move files: "+err.Error())
+		return
+	}
+
+	c.JSON(200, moved)
+}
@@ -0,0 +1,421 @@
+package api
+
+import (
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/newtoallofthis123/noob_store/types"
+)
+
+// routeDoc documents a route for the OpenAPI spec, the params in its path being taken from the route itself
+type routeDoc struct {
+	Summary string
+	// Public routes can be called without the Authorization header
+	Public bool
+	Params []paramDoc
+	// Res is a sample of the response, with a gin.H standing for an object with the given fields
+	Res any
+	// Raw is the content type of routes answering with the bytes of a file rather than json
+	Raw string
+	// Body is the content type of routes reading the bytes of a file from the request body
+	Body string
+}
+
+// paramDoc documents a param read from the query, a header or the form
+type paramDoc struct {
+	Name     string
+	In       string
+	Required bool
+	Desc     string
+}
+
+func query(name, desc string) paramDoc  { return paramDoc{Name: name, In: "query", Desc: desc} }
+func header(name, desc string) paramDoc { return paramDoc{Name: name, In: "header", Desc: desc} }
+func form(name, desc string) paramDoc   { return paramDoc{Name: name, In: "form", Desc: desc} }
+func file(name, desc string) paramDoc   { return paramDoc{Name: name, In: "file", Desc: desc} }
+
+// need marks a param as required
+func need(p paramDoc) paramDoc {
+	p.Required = true
+	return p
+}
+
+var (
+	success      = gin.H{"success": ""}
+	successCount = gin.H{"success": "", "count": 0}
+	usageRes     = gin.H{"usage": types.Usage{}, "quota": types.Quota{}}
+	retentionDoc = gin.H{"id": "", "retention_mode": "", "retain_until": "", "legal_hold": false}
+	bypassParam  = header("X-Bypass-Governance-Retention", "true to override governance retention as an admin")
+	expiresParam = form("expires_in", "seconds until it expires")
+)
+
+// listParams are the params of the paginated listings read by parseListOptions
+var listParams = []paramDoc{
+	query("limit", "page size"),
+	query("cursor", "next_cursor of the previous page"),
+	query("sort", "one of name, size, created_at or mime"),
+	query("order", "asc or desc"),
+	query("dir", "only list files directly in this dir"),
+	query("prefix", "only list paths starting with this"),
+	query("delimiter", "group paths past the delimiter into common prefixes"),
+	query("mime", "mime type, a trailing * matching any subtype"),
+	query("tag", "key=value a file has to be tagged with, repeatable"),
+	query("min_size", "size like 512 or 10MB"),
+	query("max_size", "size like 512 or 10MB"),
+	query("after", "created after, RFC3339 or a date"),
+	query("before", "created before, RFC3339 or a date"),
+	query("shared", "true to list files shared with the user as well"),
+}
+
+// routeDocs documents every route of the server, keyed by method and path
+var routeDocs = map[string]routeDoc{
+	"GET /":             {Summary: "Status of the server", Public: true, Res: gin.H{"noob_store": gin.H{"version": "", "author": "", "status": ""}}},
+	"GET /openapi.json": {Summary: "This OpenAPI spec", Public: true, Res: gin.H{}},
+
+	"POST /add": {Summary: "Upload a file", Res: types.Metadata{}, Params: []paramDoc{
+		need(form("path", "path to store the file at")), need(file("content", "content of the file")),
+		form("group", "id of a group to store the file for"),
+		form("meta-<key>", "user metadata, also read from X-Meta-<key> headers"), form("tag-<key>", "tags, also read from X-Tag-<key> headers")}},
+	"GET /info/:id":      {Summary: "Metadata of a file", Res: types.Metadata{}},
+	"GET /file/:id":      {Summary: "Download a file", Raw: "application/octet-stream"},
+	"DELETE /delete/:id": {Summary: "Move a file to the trash", Res: success, Params: []paramDoc{bypassParam}},
+	"POST /move": {Summary: "Move or rename a file or dir", Res: []types.Metadata{}, Params: []paramDoc{
+		need(form("src", "")), need(form("dst", "")), form("overwrite", "true to trash files in the way"), bypassParam}},
+	"POST /copy": {Summary: "Copy a file or dir", Res: []types.Metadata{}, Params: []paramDoc{
+		need(form("src", "")), need(form("dst", "")), form("owner", "id of the user or group src belongs to"), form("overwrite", "true to trash files in the way"), bypassParam}},
+	"GET /retention/:id": {Summary: "Retention of a file", Res: retentionDoc},
+	"PUT /retention/:id": {Summary: "Set the retention of a file", Res: retentionDoc, Params: []paramDoc{
+		form("mode", "governance or compliance, empty to clear, compliance only by the owner"), form("retain_until", "future RFC3339 date within MAX_RETENTION"), bypassParam}},
+	"PUT /legal_hold/:id": {Summary: "Set or clear the legal hold of a file", Res: retentionDoc, Params: []paramDoc{need(form("hold", "true or false"))}},
+	"GET /tags/:id":       {Summary: "Tags of a file", Res: gin.H{"id": "", "tags": map[string]string{}}},
+	"PUT /tags/:id":       {Summary: "Replace the tags of a file", Res: gin.H{"id": "", "tags": map[string]string{}}, Params: []paramDoc{form("tag-<key>", "value of the tag")}},
+	"GET /search": {Summary: "Search metadata with a query like name:*.log size>10MB", Res: types.ListRes{},
+		Params: append([]paramDoc{need(query("q", "search query"))}, listParams...)},
+	"GET /search/content": {Summary: "Full text search of text files", Res: []types.ContentMatch{}, Params: []paramDoc{need(query("q", "search query")), query("limit", "")}},
+
+	"POST /share": {Summary: "Share a file or dir with a user or group", Res: types.Grant{}, Params: []paramDoc{
+		need(form("path", "")), form("email", "email of the user"), form("group", "id of the group"), form("permission", "read, write or owner")}},
+	"GET /share":        {Summary: "Shares made by the user", Res: []types.Grant{}},
+	"DELETE /share/:id": {Summary: "Remove a share", Res: success},
+
+	"POST /presign": {Summary: "Presign a url to download or upload one file", Res: gin.H{"url": "", "expires_at": ""}, Params: []paramDoc{
+		form("op", "GET or PUT"), form("id", "id of the file to download"), form("path", "path to upload to"), expiresParam}},
+	"GET /signed/:id": {Summary: "Download a file with a presigned url", Public: true, Raw: "application/octet-stream", Params: []paramDoc{
+		need(query("user", "")), need(query("exp", "")), need(query("sig", ""))}},
+	"PUT /signed/upload": {Summary: "Upload the request body with a presigned url", Public: true, Res: types.Metadata{}, Body: "application/octet-stream", Params: []paramDoc{
+		need(query("path", "")), need(query("user", "")), need(query("exp", "")), need(query("sig", "")),
+		header("X-Meta-<key>", "user metadata"), header("X-Tag-<key>", "tags")}},
+	"POST /links": {Summary: "Create a public share link", Res: gin.H{"link": types.ShareLink{}, "url": ""}, Params: []paramDoc{
+		need(form("id", "id of the file")), form("password", ""), expiresParam, form("max_downloads", "")}},
+	"GET /links":            {Summary: "Share links of the user", Res: []types.ShareLink{}},
+	"DELETE /links/:id":     {Summary: "Revoke a share link", Res: success},
+	"GET /links/:id/events": {Summary: "Uses of a share link", Res: []types.LinkEvent{}},
+	"GET /l/:token": {Summary: "Download the file of a share link", Public: true, Raw: "application/octet-stream", Params: []paramDoc{
+		header("X-Link-Password", "")}},
+	"POST /l/:token": {Summary: "Download the file of a share link with the password in a form", Public: true, Raw: "application/octet-stream", Params: []paramDoc{
+		header("X-Link-Password", ""), form("password", "")}},
+
+	"POST /groups": {Summary: "Create an org or team", Res: types.Group{}, Params: []paramDoc{
+		need(form("name", "")), form("kind", "org or team"), form("org", "id of the org of a team")}},
+	"GET /groups":     {Summary: "Groups of the user", Res: []types.Group{}},
+	"GET /groups/:id": {Summary: "A group and its members", Res: gin.H{"group": types.Group{}, "members": []types.Member{}}},
+	"POST /groups/:id/members": {Summary: "Add a member or change their role", Res: types.Member{}, Params: []paramDoc{
+		need(form("email", "")), form("role", "owner, admin, member or viewer")}},
+	"DELETE /groups/:id/members/:user": {Summary: "Remove a member", Res: success},
+	"GET /groups/:id/ls":               {Summary: "List the files of a group", Res: types.ListRes{}, Params: listParams},
+	"GET /groups/:id/usage":            {Summary: "Storage used by a group", Res: usageRes},
+	"POST /groups/:id/transfer": {Summary: "Transfer the files of a member to the group", Res: successCount, Params: []paramDoc{
+		need(form("path", "")), form("from", "id of the member, the user by default")}},
+
+	"GET /auth/oidc/login":    {Summary: "Redirect to the identity provider", Public: true},
+	"GET /auth/oidc/callback": {Summary: "Finish logging in with the identity provider", Public: true, Res: types.Session{}, Params: []paramDoc{query("code", ""), query("state", "")}},
+
+	"POST /user/create": {Summary: "Sign up", Public: true, Res: types.Session{}, Params: []paramDoc{need(form("email", "")), need(form("password", ""))}},
+	"POST /user/login": {Summary: "Log in, answering with a challenge instead when two factor login is on", Public: true,
+		Res: types.Session{}, Params: []paramDoc{need(form("email", "")), need(form("password", ""))}},
+	"POST /user/login/2fa": {Summary: "Finish logging in with a second factor", Public: true, Res: types.Session{}, Params: []paramDoc{
+		need(form("challenge", "")), form("code", "totp code"), form("recovery_code", "")}},
+	"POST /user/reset/request": {Summary: "Mail a password reset token", Public: true, Res: success, Params: []paramDoc{need(form("email", ""))}},
+	"POST /user/reset":         {Summary: "Reset the password with a token, revoking all api keys", Public: true, Res: success, Params: []paramDoc{need(form("token", "")), need(form("password", ""))}},
+	"GET /user/verify":         {Summary: "Verify the email with a token", Public: true, Res: success, Params: []paramDoc{need(query("token", ""))}},
+	"POST /user/verify/send":   {Summary: "Mail an email verification token", Res: success},
+	"POST /user/password": {Summary: "Change the password", Res: gin.H{"success": "", "revoked_keys": 0}, Params: []paramDoc{
+		need(form("current_password", "")), need(form("new_password", "")), form("revoke_keys", "true to revoke all api keys as well")}},
+	"DELETE /user": {Summary: "Delete the account and all its files", Res: success, Params: []paramDoc{
+		form("password", ""), form("code", "totp code"), form("recovery_code", "")}},
+	"POST /user/logout":             {Summary: "Log out", Res: success},
+	"GET /user/sessions":            {Summary: "Active sessions of the user", Res: []types.Session{}},
+	"DELETE /user/sessions":         {Summary: "Revoke all sessions", Res: successCount, Params: []paramDoc{query("keep_current", "true to keep the current session")}},
+	"DELETE /user/sessions/:id":     {Summary: "Revoke a session", Res: success},
+	"GET /user/2fa":                 {Summary: "Two factor login status", Res: gin.H{"enabled": false, "recovery_codes": 0}},
+	"POST /user/2fa/enroll":         {Summary: "Start enrolling in two factor login", Res: gin.H{"secret": "", "uri": ""}},
+	"POST /user/2fa/confirm":        {Summary: "Confirm enrolment with a code", Res: gin.H{"success": "", "recovery_codes": []string{}}, Params: []paramDoc{need(form("code", ""))}},
+	"DELETE /user/2fa":              {Summary: "Turn off two factor login", Res: success, Params: []paramDoc{form("code", ""), form("recovery_code", "")}},
+	"POST /user/2fa/recovery_codes": {Summary: "Replace the recovery codes", Res: gin.H{"recovery_codes": []string{}}, Params: []paramDoc{need(form("code", ""))}},
+	"POST /user/keys": {Summary: "Create an api key, its token only being shown once", Res: types.ApiKey{}, Params: []paramDoc{
+		need(form("name", "")), form("scope", "read or write"), form("path", "path prefix to limit the key to"), expiresParam}},
+	"GET /user/keys":        {Summary: "Api keys of the user", Res: []types.ApiKey{}},
+	"DELETE /user/keys/:id": {Summary: "Revoke an api key", Res: success},
+
+	"GET /user/ls":                 {Summary: "List the files of the user", Res: types.ListRes{}, Params: listParams},
+	"GET /user/path_ls":            {Summary: "Tree of the files of the user", Res: gin.H{}, Params: []paramDoc{query("dir", "")}},
+	"DELETE /user/delete_dir/:dir": {Summary: "Move the files of a dir to the trash", Res: success, Params: []paramDoc{bypassParam}},
+	"POST /user/mkdir":             {Summary: "Create a dir", Res: types.Dir{}, Params: []paramDoc{need(form("path", ""))}},
+	"DELETE /user/rmdir": {Summary: "Remove a dir", Res: success, Params: []paramDoc{
+		need(query("path", "")), query("recursive", "true to trash the files in it"), bypassParam}},
+	"GET /user/stat":     {Summary: "Stat a file or dir", Res: StatRes{}, Params: []paramDoc{query("path", "")}},
+	"GET /user/children": {Summary: "Immediate children of a dir", Res: ChildrenRes{}, Params: []paramDoc{query("path", "")}},
+	"GET /user/usage":    {Summary: "Storage used by the user", Res: usageRes},
+	"GET /user/shared":   {Summary: "Files shared with the user", Res: gin.H{"grants": []types.Grant{}, "files": types.ListRes{}}, Params: listParams},
+
+	"GET /user/trash":                   {Summary: "Files in the trash", Res: []types.Metadata{}},
+	"POST /user/trash/restore/:id":      {Summary: "Restore a file from the trash", Res: types.Metadata{}},
+	"POST /user/trash/restore_dir/:dir": {Summary: "Restore a dir from the trash", Res: success},
+	"DELETE /user/trash":                {Summary: "Empty the trash", Res: success},
+
+	"POST /user/lifecycle": {Summary: "Create a lifecycle rule", Res: types.LifecycleRule{}, Params: []paramDoc{
+		need(form("expire_days", "")), form("prefix", ""), form("bucket", ""), form("tag-<key>", "tag a file has to have")}},
+	"GET /user/lifecycle":             {Summary: "Lifecycle rules of the user", Res: []types.LifecycleRule{}},
+	"DELETE /user/lifecycle/:id":      {Summary: "Delete a lifecycle rule", Res: success},
+	"GET /user/lifecycle/:id/dry_run": {Summary: "Files a lifecycle rule would expire now", Res: gin.H{"rule": types.LifecycleRule{}, "expired": []types.Metadata{}}},
+
+	"GET /admin/users":                 {Summary: "List users", Res: []types.User{}},
+	"DELETE /admin/users/:id":          {Summary: "Delete a user and their files", Res: success},
+	"PUT /admin/users/:id/role":        {Summary: "Set the role of a user", Res: success, Params: []paramDoc{need(form("role", "admin, user or read_only"))}},
+	"DELETE /admin/users/:id/sessions": {Summary: "Revoke the sessions of a user", Res: successCount},
+	"DELETE /admin/users/:id/2fa":      {Summary: "Turn off two factor login of a user", Res: success},
+	"DELETE /admin/users/:id/lockout":  {Summary: "Lift the login lockout of a user", Res: success},
+	"GET /admin/buckets":               {Summary: "Bucket statistics", Res: []types.BucketStat{}},
+	"POST /admin/gc":                   {Summary: "Free the space of deleted blobs", Res: success},
+	"POST /admin/scrub":                {Summary: "Verify the checksums of all blobs", Res: gin.H{"checked": 0, "corrupt": []string{}}},
+	"GET /admin/quotas/:id":            {Summary: "Usage and quota of a user or group", Res: usageRes},
+	"PUT /admin/quotas/:id": {Summary: "Set the quota of a user or group", Res: types.Quota{}, Params: []paramDoc{
+		form("max_bytes", "size like 10GB, 0 for the default"), form("max_objects", "")}},
+	"GET /admin/audit": {Summary: "Read the audit log", Res: []types.AuditEntry{}, Params: []paramDoc{
+		query("actor", "id of the acting user"), query("before", "RFC3339 date"), query("limit", "")}},
+}
+
+// ginParam matches the :name and *name params of gin paths
+var ginParam = regexp.MustCompile(`[:*](\w+)`)
+
+// undocumented finds the routes missing from routeDocs and the routeDocs that are not routes, sorted
+func undocumented(routes gin.RoutesInfo) (missing, stale []string) {
+	seen := make(map[string]bool)
+	for _, route := range routes {
+		// WebDAV has methods of its own, which OpenAPI can not describe
+		if strings.HasPrefix(route.Path, davPrefix+"/") {
+			continue
+		}
+		key := route.Method + " " + route.Path
+		seen[key] = true
+		if _, ok := routeDocs[key]; !ok {
+			missing = append(missing, key)
+		}
+	}
+
+	for key := range routeDocs {
+		if !seen[key] {
+			stale = append(stale, key)
+		}
+	}
+	sort.Strings(missing)
+	sort.Strings(stale)
+	return missing, stale
+}
+
+// openAPI builds the OpenAPI 3 spec of the routes from routeDocs,
+// warning about routes and docs that have gone out of sync
+func (s *Server) openAPI(routes gin.RoutesInfo) gin.H {
+	schemas := gin.H{}
+	paths := gin.H{}
+
+	missing, stale := undocumented(routes)
+	for _, key := range missing {
+		s.logger.Warn("Route " + key + " is missing from the OpenAPI spec")
+	}
+	for _, key := range stale {
+		s.logger.Warn("OpenAPI spec documents " + key + " which is not a route")
+	}
+
+	for _, route := range routes {
+		if strings.HasPrefix(route.Path, davPrefix+"/") {
+			continue
+		}
+		doc := routeDocs[route.Method+" "+route.Path]
+
+		path := ginParam.ReplaceAllString(route.Path, "{$1}")
+		item, _ := paths[path].(gin.H)
+		if item == nil {
+			item = gin.H{}
+			paths[path] = item
+		}
+		item[strings.ToLower(route.Method)] = operation(route, doc, schemas)
+	}
+
+	schemaOf(types.ErrorRes{}, schemas)
+	return gin.H{
+		"openapi": "3.0.3",
+		"info":    gin.H{"title": "noob_store", "version": "0.1"},
+		"paths":   paths,
+		"components": gin.H{
+			"schemas": schemas,
+			"securitySchemes": gin.H{"token": gin.H{
+				"type": "http", "scheme": "bearer",
+				"description": "A session id, api key or JWT, with or without the Bearer prefix",
+			}},
+		},
+		"security": []gin.H{{"token": []string{}}},
+	}
+}
+
+// operation builds the OpenAPI operation of a route
+func operation(route gin.RouteInfo, doc routeDoc, schemas gin.H) gin.H {
+	tag := strings.Split(strings.TrimPrefix(route.Path, "/"), "/")[0]
+	switch tag {
+	case "user", "admin", "groups", "auth":
+	case "", "openapi.json":
+		tag = "server"
+	default:
+		tag = "files"
+	}
+	op := gin.H{"summary": doc.Summary, "tags": []string{tag}}
+	if doc.Public {
+		op["security"] = []gin.H{}
+	}
+
+	params := make([]gin.H, 0)
+	for _, m := range ginParam.FindAllStringSubmatch(route.Path, -1) {
+		params = append(params, gin.H{"name": m[1], "in": "path", "required": true, "schema": gin.H{"type": "string"}})
+	}
+	fields := gin.H{}
+	required := make([]string, 0)
+	multipart := false
+	for _, p := range doc.Params {
+		switch p.In {
+		case "form", "file":
+			field := gin.H{"type": "string", "description": p.Desc}
+			if p.In == "file" {
+				field["format"] = "binary"
+				multipart = true
+			}
+			fields[p.Name] = field
+			if p.Required {
+				required = append(required, p.Name)
+			}
+		default:
+			params = append(params, gin.H{"name": p.Name, "in": p.In, "required": p.Required, "description": p.Desc, "schema": gin.H{"type": "string"}})
+		}
+	}
+	op["parameters"] = params
+
+	if len(fields) > 0 {
+		schema := gin.H{"type": "object", "properties": fields}
+		if len(required) > 0 {
+			schema["required"] = required
+		}
+		content := gin.H{"multipart/form-data": gin.H{"schema": schema}}
+		if !multipart {
+			content["application/x-www-form-urlencoded"] = gin.H{"schema": schema}
+		}
+		op["requestBody"] = gin.H{"required": len(required) > 0, "content": content}
+	}
+	if doc.Body != "" {
+		op["requestBody"] = gin.H{"required": true, "content": gin.H{doc.Body: gin.H{"schema": gin.H{"type": "string", "format": "binary"}}}}
+	}
+
+	ok := gin.H{"description": "OK"}
+	switch {
+	case doc.Raw != "":
+		ok["content"] = gin.H{doc.Raw: gin.H{"schema": gin.H{"type": "string", "format": "binary"}}}
+	case doc.Res != nil:
+		ok["content"] = gin.H{"application/json": gin.H{"schema": schemaOf(doc.Res, schemas)}}
+	}
+	responses := gin.H{
+		"default": gin.H{"description": "Error", "content": gin.H{"application/json": gin.H{"schema": gin.H{"$ref": "#/components/schemas/ErrorRes"}}}},
+	}
+	if route.Path == "/auth/oidc/login" {
+		responses["302"] = gin.H{"description": "Redirect to the identity provider"}
+	} else {
+		responses["200"] = ok
+	}
+	op["responses"] = responses
+
+	return op
+}
+
+// schemaOf returns the json schema of a sample value, adding the structs it uses to schemas
+func schemaOf(v any, schemas gin.H) gin.H {
+	if h, ok := v.(gin.H); ok {
+		props := gin.H{}
+		keys := make([]string, 0, len(h))
+		for k := range h {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			props[k] = schemaOf(h[k], schemas)
+		}
+		return gin.H{"type": "object", "properties": props}
+	}
+	return schemaOfType(reflect.TypeOf(v), schemas)
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+func schemaOfType(t reflect.Type, schemas gin.H) gin.H {
+	if t == nil {
+		return gin.H{}
+	}
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if t == timeType {
+		return gin.H{"type": "string", "format": "date-time"}
+	}
+
+	switch t.Kind() {
+	case reflect.String:
+		return gin.H{"type": "string"}
+	case reflect.Bool:
+		return gin.H{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return gin.H{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return gin.H{"type": "number"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return gin.H{"type": "string", "format": "byte"}
+		}
+		return gin.H{"type": "array", "items": schemaOfType(t.Elem(), schemas)}
+	case reflect.Map:
+		return gin.H{"type": "object", "additionalProperties": schemaOfType(t.Elem(), schemas)}
+	case reflect.Struct:
+		ref := gin.H{"$ref": "#/components/schemas/" + t.Name()}
+		if _, exists := schemas[t.Name()]; exists {
+			return ref
+		}
+		// Claim the name first so that structs referring to themselves end
+		schemas[t.Name()] = gin.H{}
+		props := gin.H{}
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+			if !f.IsExported() || name == "-" {
+				continue
+			}
+			if name == "" {
+				name = f.Name
+			}
+			props[name] = schemaOfType(f.Type, schemas)
+		}
+		schemas[t.Name()] = gin.H{"type": "object", "properties": props}
+		return ref
+	}
+
+	return gin.H{}
+}
@@ -0,0 +1,22 @@
+package api
+
+import (
+	"io"
+	"log/slog"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestRoutesAreDocumented(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	s := &Server{logger: slog.New(slog.NewTextHandler(io.Discard, nil))}
+
+	missing, stale := undocumented(s.Router().Routes())
+	for _, key := range missing {
+		t.Errorf("route %s is missing from routeDocs", key)
+	}
+	for _, key := range stale {
+		t.Errorf("routeDocs has %s, which is not a route", key)
+	}
+}
@@ -0,0 +1,63 @@
+package api
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/newtoallofthis123/noob_store/types"
+)
+
+var errQuotaExceeded = types.Errorf(types.ErrQuotaExceeded, "storage quota exceeded")
+
+// quotaOf gets the quota of an owner, falling back to the default quota from the env
+func (s *Server) quotaOf(ownerId string) types.Quota {
+	quota, err := s.db.GetQuota(ownerId)
+	if err != nil {
+		return types.Quota{OwnerId: ownerId, MaxBytes: int64(s.env.QuotaBytes), MaxObjects: int64(s.env.QuotaObjects)}
+	}
+	return quota
+}
+
+// checkQuota checks if an owner can store the given number of bytes and objects on top of their usage.
+// The caller must hold the write lock so that the usage can not change before the files are stored.
+func (s *Server) checkQuota(ownerId string, bytes, objects int64) error {
+	usage, err := s.db.GetUsage(ownerId)
+	if err != nil {
+		return err
+	}
+
+	quota := s.quotaOf(ownerId)
+	if quota.MaxBytes > 0 && usage.Bytes+bytes > quota.MaxBytes {
+		return errQuotaExceeded
+	}
+	if quota.MaxObjects > 0 && usage.Objects+objects > quota.MaxObjects {
+		return errQuotaExceeded
+	}
+
+	return nil
+}
+
+// ReconcileUsage recomputes the usage counters from the stored files to fix any drift
+func (s *Server) ReconcileUsage() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.db.ReconcileUsage()
+}
+
+func (s *Server) handleUserUsage(c *gin.Context) {
+	authKey := c.GetHeader("Authorization")
+	session, exists := authSession(c)
+	if !exists {
+		s.logger.Error("Unauthorized session: " + authKey)
+		abort(c, 401, "Invalid Authorization or missing session")
+		return
+	}
+
+	usage, err := s.db.GetUsage(session.UserId)
+	if err != nil {
+		s.logger.Error("Unable to get usage of userId: " + session.UserId + " with err: " + err.Error())
+		abortErr(c, err, "Unable to get usage")
+		return
+	}
+
+	c.JSON(200, gin.H{"usage": usage, "quota": s.quotaOf(session.UserId)})
+}
@@ -0,0 +1,191 @@
+package api
+
+import (
+	"io"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/newtoallofthis123/noob_store/utils"
+)
+
+// authLimited are the routes that try credentials or send mail, limited by the stricter auth rate.
+// Logins are limited by the Login and LoginTwoFactor operations instead, so that every transport is.
+var authLimited = []string{"/user/create", "/user/reset/request", "/user/reset"}
+
+// allow takes a token for each of the keys from their buckets, returning how long to wait if any ran out.
+// Failing to reach the cache lets the request through rather than taking the whole api down.
+func (s *Server) allow(rate utils.Rate, keys ...string) (time.Duration, bool) {
+	if rate.PerSecond <= 0 {
+		return 0, true
+	}
+
+	for _, key := range keys {
+		wait, err := s.cache.TakeTokens(key, 1, rate.PerSecond, rate.Burst)
+		if err != nil {
+			s.logger.Error("Unable to rate limit " + key + " with err: " + err.Error())
+			continue
+		}
+		if wait > 0 {
+			return wait, false
+		}
+	}
+	return 0, true
+}
+
+// allowAuth takes a token of the auth rate for the client ip and, when given, the account of the email
+func (s *Server) allowAuth(ip, email string) (time.Duration, bool) {
+	keys := []string{"auth:ip:" + ip}
+	if email != "" {
+		keys = append(keys, "auth:account:"+strings.ToLower(email))
+	}
+	return s.allow(s.env.AuthRateLimit, keys...)
+}
+
+// tooManyRequests aborts a request that has to wait before it is retried
+func tooManyRequests(c *gin.Context, msg string, wait time.Duration) {
+	seconds := strconv.Itoa(int(math.Ceil(wait.Seconds())))
+	c.Header("Retry-After", seconds)
+	abort(c, 429, msg+", retry in "+seconds+"s")
+}
+
+// RateLimiter returns a gin.HandlerFunc that limits requests per client ip and per account with
+// token buckets kept in the cache, so the limits hold across replicas. Auth routes use the auth rate
+// keyed by the email they name, other routes the api rate keyed by the session user, whose uploads
+// and downloads are throttled to the bandwidth limits as well.
+func (s *Server) RateLimiter() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ip := "ip:" + c.ClientIP()
+
+		if slices.Contains(authLimited, c.FullPath()) {
+			if wait, ok := s.allowAuth(c.ClientIP(), c.PostForm("email")); !ok {
+				s.logger.Warn("Rate limited " + c.FullPath() + " from " + c.ClientIP())
+				tooManyRequests(c, "Too many attempts", wait)
+				return
+			}
+			c.Next()
+			return
+		}
+
+		keys := []string{"api:" + ip}
+		session, exists := authSession(c)
+		if exists {
+			keys = append(keys, "api:user:"+session.UserId)
+		}
+		if wait, ok := s.allow(s.env.ApiRateLimit, keys...); !ok {
+			tooManyRequests(c, "Too many requests", wait)
+			return
+		}
+
+		if exists {
+			s.limitBandwidth(c, session.UserId)
+		}
+		c.Next()
+	}
+}
+
+// limitBandwidth throttles the uploads and downloads of a request to the bandwidth limits of the user
+func (s *Server) limitBandwidth(c *gin.Context, userId string) {
+	if s.env.UploadRate > 0 && c.Request.Body != nil {
+		c.Request.Body = &throttledReader{ReadCloser: c.Request.Body, s: s, key: "upload:" + userId, rate: float64(s.env.UploadRate)}
+	}
+	if s.env.DownloadRate > 0 {
+		c.Writer = &throttledWriter{ResponseWriter: c.Writer, s: s, key: "download:" + userId, rate: float64(s.env.DownloadRate)}
+	}
+}
+
+// throttle waits long enough for n bytes to stay within the bandwidth of a user, shared by all of their requests
+func (s *Server) throttle(key string, n int, rate float64) {
+	wait, err := s.cache.ReserveTokens(key, float64(n), rate, rate)
+	if err != nil {
+		s.logger.Error("Unable to throttle " + key + " with err: " + err.Error())
+		return
+	}
+	time.Sleep(wait)
+}
+
+// throttledReader limits how fast a request body is read
+type throttledReader struct {
+	io.ReadCloser
+	s    *Server
+	key  string
+	rate float64
+}
+
+func (r *throttledReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	if n > 0 {
+		r.s.throttle(r.key, n, r.rate)
+	}
+	return n, err
+}
+
+// throttledWriter limits how fast a response is written, in chunks so that large writes are spread out
+type throttledWriter struct {
+	gin.ResponseWriter
+	s    *Server
+	key  string
+	rate float64
+}
+
+// throttleChunk is the most written at once by a throttledWriter
+const throttleChunk = 32 * 1024
+
+func (w *throttledWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		chunk := p[:min(len(p), throttleChunk)]
+		w.s.throttle(w.key, len(chunk), w.rate)
+		n, err := w.ResponseWriter.Write(chunk)
+		written += n
+		if err != nil {
+			return written, err
+		}
+		p = p[len(chunk):]
+	}
+	return written, nil
+}
+
+// loginLocked checks if an account is locked out after failed logins, returning for how long
+func (s *Server) loginLocked(userId string) (time.Duration, bool) {
+	wait, err := s.cache.LockedFor("login:" + userId)
+	if err != nil {
+		s.logger.Error("Unable to check lockout of userId: " + userId + " with err: " + err.Error())
+		return 0, false
+	}
+	return wait, wait > 0
+}
+
+// loginFailed counts a failed password or second factor of an account, locking it out at the threshold
+func (s *Server) loginFailed(userId string) {
+	if s.env.LockoutLimit <= 0 {
+		return
+	}
+
+	n, err := s.cache.AddFailure("login:"+userId, s.env.LockoutPeriod)
+	if err != nil {
+		s.logger.Error("Unable to count failed login of userId: " + userId + " with err: " + err.Error())
+		return
+	}
+	if n >= int64(s.env.LockoutLimit) {
+		_ = s.cache.Lock("login:"+userId, s.env.LockoutPeriod)
+		s.logger.Warn("Locked out userId: " + userId + " after " + strconv.FormatInt(n, 10) + " failed logins")
+	}
+}
+
+// loginSucceeded forgets the failed logins of an account
+func (s *Server) loginSucceeded(userId string) {
+	_ = s.cache.ClearFailures("login:" + userId)
+}
+
+// handleAdminUnlock lifts the lockout of a user before it runs out
+func (s *Server) handleAdminUnlock(c *gin.Context) {
+	id := c.Param("id")
+	s.loginSucceeded(id)
+
+	s.audit(c, "unlock_user", id, "")
+	c.JSON(200, gin.H{"success": "Unlocked user " + id})
+}
@@ -0,0 +1,148 @@
+package api
+
+import (
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/newtoallofthis123/noob_store/types"
+)
+
+var errObjectLocked = types.Errorf(types.ErrLocked, "object is under retention or legal hold")
+
+// bypassGovernance checks if the request asks to bypass governance retention
+func bypassGovernance(c *gin.Context) bool {
+	return c.GetHeader("X-Bypass-Governance-Retention") == "true"
+}
+
+func retentionRes(meta types.Metadata) gin.H {
+	return gin.H{
+		"id":             meta.Id,
+		"retention_mode": meta.RetentionMode,
+		"retain_until":   meta.RetainUntil,
+		"legal_hold":     meta.LegalHold,
+	}
+}
+
+func (s *Server) handleGetRetention(c *gin.Context) {
+	authKey := c.GetHeader("Authorization")
+	session, exists := authSession(c)
+	if !exists {
+		s.logger.Error("Unauthorized session: " + authKey)
+		abort(c, 401, "Invalid Authorization or missing session")
+		return
+	}
+
+	meta, ok := s.authorizedMetadata(c, session, types.PermOwner)
+	if !ok {
+		return
+	}
+
+	c.JSON(200, retentionRes(meta))
+}
+
+// handleSetRetention sets the retention of a file.
+// An empty mode clears the retention, which like shortening it is only allowed for
+// governance retention with the bypass header and never for compliance retention.
+// Retention is bounded by MAX_RETENTION, and compliance retention can only be set by the owner of the file.
+func (s *Server) handleSetRetention(c *gin.Context) {
+	authKey := c.GetHeader("Authorization")
+	session, exists := authSession(c)
+	if !exists {
+		s.logger.Error("Unauthorized session: " + authKey)
+		abort(c, 401, "Invalid Authorization or missing session")
+		return
+	}
+
+	meta, ok := s.authorizedMetadata(c, session, types.PermOwner)
+	if !ok {
+		return
+	}
+
+	now := time.Now()
+	mode := c.PostForm("mode")
+	var until *time.Time
+	if mode != "" {
+		if mode != types.RetentionGovernance && mode != types.RetentionCompliance {
+			abort(c, 400, "mode must be one of governance or compliance")
+			return
+		}
+		t, err := time.Parse(time.RFC3339, c.PostForm("retain_until"))
+		if err != nil {
+			abort(c, 400, "retain_until is needed as an RFC3339 date")
+			return
+		}
+		if !t.After(now) || t.After(now.Add(s.env.MaxRetention)) {
+			abort(c, 400, "retain_until must be in the future and at most "+s.env.MaxRetention.String()+" away")
+			return
+		}
+		until = &t
+	}
+	// Compliance retention can not be undone by anyone, so only the owner themselves may put it on a file
+	if mode == types.RetentionCompliance && meta.UserId != session.UserId {
+		s.logger.Warn("Prevented compliance retention of file: " + meta.Id + " by non owner " + session.UserId)
+		abort(c, 403, "Only the owner of a file can set compliance retention")
+		return
+	}
+
+	if meta.Retained(now) {
+		current, _ := time.Parse(time.RFC3339Nano, meta.RetainUntil)
+		weakened := until == nil || until.Before(current) ||
+			(meta.RetentionMode == types.RetentionCompliance && mode != types.RetentionCompliance)
+		if weakened && (meta.RetentionMode == types.RetentionCompliance || !bypassGovernance(c)) {
+			s.logger.Warn("Prevented weakening retention of file: " + meta.Id + " by user " + session.UserId)
+			abort(c, 403, "Retention of file can not be shortened or removed")
+			return
+		}
+	}
+
+	s.mu.Lock()
+	err := s.db.SetRetention(meta.Id, mode, until)
+	s.mu.Unlock()
+	if err != nil {
+		s.logger.Error("Unable to set retention of file: " + meta.Id + " with err: " + err.Error())
+		abortErr(c, err, "Unable to set retention: "+err.Error())
+		return
+	}
+	_ = s.cache.DeleteMetadata(meta.Id)
+
+	meta.RetentionMode = mode
+	meta.RetainUntil = ""
+	if until != nil {
+		meta.RetainUntil = until.Format(time.RFC3339Nano)
+	}
+	c.JSON(200, retentionRes(meta))
+}
+
+func (s *Server) handleSetLegalHold(c *gin.Context) {
+	authKey := c.GetHeader("Authorization")
+	session, exists := authSession(c)
+	if !exists {
+		s.logger.Error("Unauthorized session: " + authKey)
+		abort(c, 401, "Invalid Authorization or missing session")
+		return
+	}
+
+	meta, ok := s.authorizedMetadata(c, session, types.PermOwner)
+	if !ok {
+		return
+	}
+
+	hold, exists := c.GetPostForm("hold")
+	if !exists || (hold != "true" && hold != "false") {
+		abort(c, 400, "hold is needed as true or false")
+		return
+	}
+
+	s.mu.Lock()
+	err := s.db.SetLegalHold(meta.Id, hold == "true")
+	s.mu.Unlock()
+	if err != nil {
+		s.logger.Error("Unable to set legal hold of file: " + meta.Id + " with err: " + err.Error())
+		abortErr(c, err, "Unable to set legal hold: "+err.Error())
+		return
+	}
+	_ = s.cache.DeleteMetadata(meta.Id)
+
+	meta.LegalHold = hold == "true"
+	c.JSON(200, retentionRes(meta))
+}
@@ -0,0 +1,74 @@
+package api
+
+import (
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/newtoallofthis123/noob_store/types"
+)
+
+// handleSearch searches the metadata of the user's own and shared files with the query language of db.ParseSearchQuery
+func (s *Server) handleSearch(c *gin.Context) {
+	authKey := c.GetHeader("Authorization")
+	session, exists := authSession(c)
+	if !exists {
+		s.logger.Error("Unauthorized session: " + authKey)
+		abort(c, 401, "Invalid Authorization or missing session")
+		return
+	}
+
+	q, exists := c.GetQuery("q")
+	if !exists {
+		abort(c, 400, "q is needed")
+		return
+	}
+
+	opts, err := parseListOptions(c)
+	if err != nil {
+		abortErr(c, err, err.Error())
+		return
+	}
+
+	opts.Shared = true
+	res, err := s.db.SearchMetadata(session.UserId, q, opts)
+	if err != nil {
+		s.logger.Debug("Unable to search for userId: " + session.UserId + " with err: " + err.Error())
+		abortErr(c, err, "Unable to search: "+err.Error())
+		return
+	}
+
+	c.JSON(200, res)
+}
+
+// handleContentSearch searches the text content of the user's own and shared text like files
+func (s *Server) handleContentSearch(c *gin.Context) {
+	authKey := c.GetHeader("Authorization")
+	session, exists := authSession(c)
+	if !exists {
+		s.logger.Error("Unauthorized session: " + authKey)
+		abort(c, 401, "Invalid Authorization or missing session")
+		return
+	}
+
+	q, exists := c.GetQuery("q")
+	if !exists {
+		abort(c, 400, "q is needed")
+		return
+	}
+	limit, _ := strconv.Atoi(c.Query("limit"))
+
+	matches, err := s.db.SearchContent(session.UserId, q, limit)
+	if err != nil {
+		s.logger.Error("Unable to search content for userId: " + session.UserId + " with err: " + err.Error())
+		abortErr(c, err, "Unable to search content: "+err.Error())
+		return
+	}
+	allowed := make([]types.ContentMatch, 0, len(matches))
+	for _, match := range matches {
+		if session.AllowsPath(match.File.Path) {
+			allowed = append(allowed, match)
+		}
+	}
+
+	c.JSON(200, allowed)
+}
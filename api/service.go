@@ -0,0 +1,701 @@
+package api
+
+import (
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/newtoallofthis123/noob_store/db"
+	"github.com/newtoallofthis123/noob_store/types"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// The methods in this file are the operations shared by the http api and the other transports
+// of the store. They take an authenticated session, hold the write checks the http middlewares
+// also make and return typed errors for the transport to answer with.
+
+// ClientInfo identifies the client a session is created for
+type ClientInfo struct {
+	Ip        string
+	UserAgent string
+}
+
+func clientInfo(c *gin.Context) ClientInfo {
+	return ClientInfo{Ip: c.ClientIP(), UserAgent: c.Request.UserAgent()}
+}
+
+// LockedOutError is returned by logins to accounts locked after too many failed logins
+type LockedOutError struct {
+	RetryAfter time.Duration
+}
+
+func (e *LockedOutError) Error() string {
+	return "account is locked after too many failed logins"
+}
+
+func (e *LockedOutError) Unwrap() error {
+	return types.ErrLocked
+}
+
+// RateLimitedError is returned by logins from clients or to accounts past the auth rate limit
+type RateLimitedError struct {
+	RetryAfter time.Duration
+}
+
+func (e *RateLimitedError) Error() string {
+	return "too many attempts"
+}
+
+// UploadInfo describes a file being uploaded
+type UploadInfo struct {
+	Path string
+	// Group stores the file for a group the user is a member of
+	Group    string
+	UserMeta map[string]string
+	Tags     map[string]string
+	// Overwrite replaces the file already at the path instead of failing with a conflict
+	Overwrite bool
+}
+
+// StatRes is what a path holds, either a file or a dir
+type StatRes struct {
+	Type string          `json:"type"`
+	File *types.Metadata `json:"file,omitempty"`
+	Dir  *types.Dir      `json:"dir,omitempty"`
+}
+
+// Authenticate resolves a session id, api key or JWT into its session
+func (s *Server) Authenticate(token string) (types.Session, error) {
+	session, exists := s.checkAuth(token)
+	if !exists {
+		return types.Session{}, types.Errorf(types.ErrUnauthorized, "invalid authorization or missing session")
+	}
+	return session, nil
+}
+
+// checkWrite makes sure a session can change the store, which read only users and read api keys can not
+func (s *Server) checkWrite(session types.Session) error {
+	if session.KeyId != "" && session.Scope != types.PermWrite {
+		return types.Errorf(types.ErrForbidden, "api key is read only")
+	}
+
+	user, err := s.db.GetUser(session.UserId)
+	if err != nil {
+		return err
+	}
+	if user.Role == types.UserReadOnly {
+		s.logger.Warn("Prevented write from read only userId " + user.Id)
+		return types.Errorf(types.ErrForbidden, "user is read only")
+	}
+	return nil
+}
+
+// checkPassword checks the password of a local user, failures counting towards locking the account
+func (s *Server) checkPassword(email, password string) (types.User, error) {
+	if !s.env.LocalLogin {
+		return types.User{}, types.Errorf(types.ErrForbidden, "local logins are disabled, log in through the identity provider")
+	}
+
+	user, err := s.db.GetUserByEmail(email)
+	if err != nil {
+		return types.User{}, err
+	}
+
+	if wait, locked := s.loginLocked(user.Id); locked {
+		return types.User{}, &LockedOutError{RetryAfter: wait}
+	}
+
+	if bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(password)) != nil {
+		s.logger.Error("Matching passwords not found for")
+		s.loginFailed(user.Id)
+		return types.User{}, types.Errorf(types.ErrUnauthorized, "authorization failed")
+	}
+	s.rehashPassword(user, password)
+
+	return user, nil
+}
+
+// Login checks the password of a local user. It returns a new session, or for accounts with
+// two factor login on the challenge to finish logging in with through LoginTwoFactor.
+func (s *Server) Login(client ClientInfo, email, password string) (types.Session, string, error) {
+	if wait, ok := s.allowAuth(client.Ip, email); !ok {
+		return types.Session{}, "", &RateLimitedError{RetryAfter: wait}
+	}
+
+	user, err := s.checkPassword(email, password)
+	if err != nil {
+		return types.Session{}, "", err
+	}
+
+	// With two factor login on the password only gets a challenge to send along with a code
+	if totp, err := s.db.GetTOTP(user.Id); err == nil && totp.Enabled {
+		return types.Session{}, s.loginChallenge(user.Id), nil
+	}
+
+	s.loginSucceeded(user.Id)
+	session, err := s.createSession(client, user.Id)
+	if err != nil {
+		s.logger.Error("Failed to create session for " + user.Id + " with err: " + err.Error())
+		return types.Session{}, "", err
+	}
+	return session, "", nil
+}
+
+// LoginTwoFactor finishes a login with the challenge from Login and a totp or recovery code
+func (s *Server) LoginTwoFactor(client ClientInfo, challenge, code, recoveryCode string) (types.Session, error) {
+	if wait, ok := s.allowAuth(client.Ip, ""); !ok {
+		return types.Session{}, &RateLimitedError{RetryAfter: wait}
+	}
+
+	userId, ok := s.checkChallenge(challenge)
+	if !ok {
+		return types.Session{}, types.Errorf(types.ErrUnauthorized, "invalid or expired login challenge")
+	}
+
+	totp, err := s.db.GetTOTP(userId)
+	if err != nil || !totp.Enabled {
+		return types.Session{}, types.Errorf(types.ErrInvalid, "two factor login is not enabled")
+	}
+	if wait, locked := s.loginLocked(userId); locked {
+		return types.Session{}, &LockedOutError{RetryAfter: wait}
+	}
+	if !s.checkSecondFactor(totp, code, recoveryCode) {
+		s.logger.Warn("Failed second factor for userId: " + userId)
+		s.loginFailed(userId)
+		return types.Session{}, types.Errorf(types.ErrUnauthorized, "authorization failed")
+	}
+
+	s.loginSucceeded(userId)
+	session, err := s.createSession(client, userId)
+	if err != nil {
+		s.logger.Error("Failed to create session for " + userId + " with err: " + err.Error())
+		return types.Session{}, err
+	}
+	return session, nil
+}
+
+// Upload stores content as a new file of the session user, or of a group they can write to
+func (s *Server) Upload(session types.Session, info UploadInfo, content []byte) (types.Metadata, error) {
+	owner, err := s.uploadOwner(session, info)
+	if err != nil {
+		return types.Metadata{}, err
+	}
+
+	attrs := fileAttrs{UserMeta: info.UserMeta, Tags: info.Tags}
+	err = validateUserMeta(attrs.UserMeta)
+	if err == nil {
+		err = validateTags(attrs.Tags)
+	}
+	if err != nil {
+		return types.Metadata{}, err
+	}
+
+	if info.Overwrite {
+		return s.replaceFile(session, owner, info.Path, content, attrs)
+	}
+	return s.addFile(owner, info.Path, content, attrs)
+}
+
+// uploadOwner checks the session can upload to the path, returning who the file is stored for
+func (s *Server) uploadOwner(session types.Session, info UploadInfo) (string, error) {
+	if info.Path == "" {
+		return "", types.Errorf(types.ErrInvalid, "path is needed")
+	}
+	if !session.AllowsPath(filepath.Clean(info.Path)) {
+		return "", types.Errorf(types.ErrForbidden, "api key can not access path: %s", info.Path)
+	}
+	err := s.checkWrite(session)
+	if err != nil {
+		return "", err
+	}
+
+	return s.ownerFor(session, info.Group)
+}
+
+// UploadLimit checks an upload can be made before its content is received, for transports that
+// stream it in. It returns how many bytes the upload may hold, being the max upload size or less
+// when the quota of the owner has less room left for a new file.
+func (s *Server) UploadLimit(session types.Session, info UploadInfo) (int64, error) {
+	owner, err := s.uploadOwner(session, info)
+	if err != nil {
+		return 0, err
+	}
+	err = s.checkQuota(owner, 0, 1)
+	if err != nil {
+		return 0, err
+	}
+
+	limit := int64(s.env.MaxUpload)
+	quota := s.quotaOf(owner)
+	if quota.MaxBytes > 0 {
+		usage, err := s.db.GetUsage(owner)
+		if err != nil {
+			return 0, err
+		}
+		limit = min(limit, quota.MaxBytes-usage.Bytes)
+	}
+	return limit, nil
+}
+
+// Info gets the metadata of a file the session user can read
+func (s *Server) Info(session types.Session, id string) (types.Metadata, error) {
+	s.mu.RLock()
+	meta, err := s.cache.GetMetadata(id)
+	if err != nil {
+		s.logger.Debug("Cache miss for metadata: " + id + " with err: " + err.Error())
+		meta, err = s.db.GetMetaDataById(id)
+		if err != nil {
+			s.mu.RUnlock()
+			s.logger.Error("No metadata with id: " + id + " with err: " + err.Error())
+			return types.Metadata{}, err
+		}
+
+		_ = s.cache.InsertMetadata(meta)
+		s.logger.Debug("Cache refreshed for metadata with id: " + meta.Id)
+	}
+	s.mu.RUnlock()
+
+	if !s.authorize(session, meta, types.PermRead) {
+		s.logger.Warn("Prevented Unauthorized access for file from userId" + session.UserId)
+		return types.Metadata{}, types.Errorf(types.ErrForbidden, "unauthorized access to file from userId: %s", session.UserId)
+	}
+	return meta, nil
+}
+
+// Download gets the metadata and verified content of a file the session user can read
+func (s *Server) Download(session types.Session, id string) (types.Metadata, []byte, error) {
+	meta, err := s.Info(session, id)
+	if err != nil {
+		return types.Metadata{}, nil, err
+	}
+
+	s.mu.RLock()
+	content, err := s.readContent(meta)
+	s.mu.RUnlock()
+	if err != nil {
+		s.logger.Error("Failed to read blob: " + meta.Blob + " with err: " + err.Error())
+		return types.Metadata{}, nil, err
+	}
+	return meta, content, nil
+}
+
+// Stat gets the file or dir of the session user at a path
+func (s *Server) Stat(session types.Session, path string) (StatRes, error) {
+	path = filepath.Clean(path)
+	if !session.AllowsPath(path) {
+		return StatRes{}, types.Errorf(types.ErrForbidden, "api key can not access path: %s", path)
+	}
+
+	meta, err := s.db.GetMetaDataByUserPath(session.UserId, path)
+	if err == nil {
+		return StatRes{Type: "file", File: &meta}, nil
+	}
+
+	dir, err := s.statDir(session.UserId, path)
+	if err != nil {
+		return StatRes{}, err
+	}
+	return StatRes{Type: "dir", Dir: &dir}, nil
+}
+
+// List gets a page of the files of the session user, narrowed down to the path prefix of api keys
+func (s *Server) List(session types.Session, opts types.ListOptions) (types.ListRes, error) {
+	if session.PathPrefix != "" && !strings.HasPrefix(opts.Prefix, session.PathPrefix+"/") {
+		opts.Prefix = session.PathPrefix + "/"
+	}
+
+	res, err := s.db.ListMetadata(session.UserId, opts)
+	if err != nil {
+		s.logger.Error("Unable to fetch user files for userId: " + session.UserId + " with err: " + err.Error())
+		return types.ListRes{}, err
+	}
+	return res, nil
+}
+
+// Delete moves a file the session user can write to into the trash, bypassGovernance
+// allowing files under governance retention to be deleted
+func (s *Server) Delete(session types.Session, id string, bypassGovernance bool) error {
+	err := s.checkWrite(session)
+	if err != nil {
+		return err
+	}
+
+	s.mu.RLock()
+	meta, err := s.db.GetMetaDataById(id)
+	s.mu.RUnlock()
+	if err != nil {
+		s.logger.Error("Unable to find file with id: " + id + " with err: " + err.Error())
+		return err
+	}
+
+	if !s.authorize(session, meta, types.PermWrite) {
+		s.logger.Warn("Prevented Unauthorized access of file: " + id + " by user " + session.UserId)
+		return types.Errorf(types.ErrForbidden, "unauthorized file access")
+	}
+
+	if meta.Locked(time.Now(), bypassGovernance) {
+		s.logger.Warn("Prevented deletion of locked file: " + id + " by user " + session.UserId)
+		return types.Errorf(types.ErrLocked, "file is under retention or legal hold")
+	}
+
+	s.mu.Lock()
+	err = s.db.TrashMetadataById(meta.Id, time.Now())
+	s.mu.Unlock()
+	if err != nil {
+		s.logger.Error("Unable to trash file with id: " + id + " with err: " + err.Error())
+		return err
+	}
+	_ = s.cache.DeleteMetadata(meta.Id)
+
+	return nil
+}
+
+// ChildrenRes is a dir with its immediate sub dirs and files
+type ChildrenRes struct {
+	Dir   types.Dir        `json:"dir"`
+	Dirs  []types.Dir      `json:"dirs"`
+	Files []types.Metadata `json:"files"`
+}
+
+// Mkdir creates a dir of the session user along with its parents
+func (s *Server) Mkdir(session types.Session, path string) (types.Dir, error) {
+	path = filepath.Clean(path)
+	if !session.AllowsPath(path) {
+		return types.Dir{}, types.Errorf(types.ErrForbidden, "api key can not access path: %s", path)
+	}
+	err := s.checkWrite(session)
+	if err != nil {
+		return types.Dir{}, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, err := s.db.GetMetaDataByUserPath(session.UserId, path); err == nil {
+		return types.Dir{}, types.Errorf(types.ErrConflict, "a file already exists at path: %s", path)
+	}
+
+	err = s.db.EnsureDirs(session.UserId, path)
+	if err != nil {
+		s.logger.Error("Unable to create dir: " + path + " with err: " + err.Error())
+		return types.Dir{}, err
+	}
+
+	return s.statDir(session.UserId, path)
+}
+
+// Rmdir removes an empty dir of the session user, or with recursive set trashes everything under it
+func (s *Server) Rmdir(session types.Session, path string, recursive, bypassGovernance bool) error {
+	path = filepath.Clean(path)
+	if db.IsRootDir(path) {
+		return types.Errorf(types.ErrInvalid, "can not remove the root dir")
+	}
+	if !session.AllowsPath(path) {
+		return types.Errorf(types.ErrForbidden, "api key can not access path: %s", path)
+	}
+	err := s.checkWrite(session)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	dir, err := s.statDir(session.UserId, path)
+	if err != nil {
+		return err
+	}
+	if !recursive && (dir.Files > 0 || dir.Dirs > 0) {
+		return types.Errorf(types.ErrConflict, "dir is not empty: %s", path)
+	}
+
+	metas, err := s.db.GetMetadataSubtreeByUser(session.UserId, path)
+	if err != nil {
+		s.logger.Error("Unable to find files under: " + path + " with err: " + err.Error())
+		return err
+	}
+
+	now := time.Now()
+	for _, meta := range metas {
+		if meta.Locked(now, bypassGovernance) {
+			return types.Errorf(types.ErrLocked, "dir has a file under retention or legal hold: %s", meta.Path)
+		}
+	}
+
+	for _, meta := range metas {
+		err = s.db.TrashMetadataById(meta.Id, now)
+		if err != nil {
+			s.logger.Error("Unable to trash file with id: " + meta.Id + " with err: " + err.Error())
+			return err
+		}
+		_ = s.cache.DeleteMetadata(meta.Id)
+	}
+
+	err = s.db.DeleteDirSubtree(session.UserId, path)
+	if err != nil {
+		s.logger.Error("Unable to remove dir: " + path + " with err: " + err.Error())
+		return err
+	}
+	return nil
+}
+
+// Move moves or renames a file or a whole dir of the session user without touching the blobs.
+// Files already at the destination are a conflict, unless overwrite is set in which case they are trashed.
+// A file landing on a dir or a dir on a file is always a conflict.
+func (s *Server) Move(session types.Session, src, dst string, overwrite, bypassGovernance bool) ([]types.Metadata, error) {
+	src = filepath.Clean(src)
+	dst = filepath.Clean(dst)
+	if src == dst || strings.HasPrefix(dst, src+"/") {
+		return nil, types.Errorf(types.ErrInvalid, "can not move %s into itself", src)
+	}
+	if !session.AllowsPath(src) || !session.AllowsPath(dst) {
+		return nil, types.Errorf(types.ErrForbidden, "api key can not access path: %s", src)
+	}
+	err := s.checkWrite(session)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var moved []types.Metadata
+	file, err := s.db.GetMetaDataByUserPath(session.UserId, src)
+	if err == nil {
+		moved = []types.Metadata{file}
+	} else {
+		moved, err = s.db.GetMetadataSubtreeByUser(session.UserId, src)
+		if err != nil {
+			s.logger.Error("Unable to find files under: " + src + " with err: " + err.Error())
+			return nil, err
+		}
+	}
+	if len(moved) == 0 {
+		if _, err := s.db.GetDirByUserPath(session.UserId, src); err != nil {
+			return nil, types.Errorf(types.ErrNotFound, "nothing found at path: %s", src)
+		}
+	}
+
+	// Files and dirs share paths, so none of the dirs there are after the move may be a file already
+	dirs := make([]string, 0)
+	for dir := filepath.Dir(dst); !db.IsRootDir(dir); dir = filepath.Dir(dir) {
+		dirs = append(dirs, dir)
+	}
+	if file.Id == "" {
+		subtree, err := s.db.GetDirSubtree(session.UserId, src)
+		if err != nil {
+			s.logger.Error("Unable to find dirs under: " + src + " with err: " + err.Error())
+			return nil, err
+		}
+		for _, dir := range subtree {
+			dirs = append(dirs, dst+strings.TrimPrefix(dir.Path, src))
+		}
+	}
+	for _, dir := range dirs {
+		if _, err := s.db.GetMetaDataByUserPath(session.UserId, dir); err == nil {
+			return nil, types.Errorf(types.ErrConflict, "a file already exists at destination dir: %s", dir)
+		}
+	}
+
+	now := time.Now()
+	replaced := make([]string, 0)
+	for i := range moved {
+		path := dst + strings.TrimPrefix(moved[i].Path, src)
+		moved[i].Path = path
+		moved[i].Parent = filepath.Dir(path)
+		moved[i].Name = filepath.Base(path)
+
+		// Unlike files, dirs in the way are never replaced
+		if _, err := s.db.GetDirByUserPath(session.UserId, path); err == nil {
+			return nil, types.Errorf(types.ErrConflict, "a dir already exists at destination: %s", path)
+		}
+		existing, err := s.db.GetMetaDataByUserPath(session.UserId, path)
+		if err != nil {
+			continue
+		}
+		if !overwrite {
+			return nil, types.Errorf(types.ErrConflict, "path already exists at destination: %s", path)
+		}
+		if existing.Locked(now, bypassGovernance) {
+			return nil, types.Errorf(types.ErrLocked, "can not overwrite file under retention or legal hold: %s", path)
+		}
+		replaced = append(replaced, existing.Id)
+	}
+
+	err = s.db.MoveMetadatas(session.UserId, src, dst, moved, replaced, now)
+	if err != nil {
+		s.logger.Error("Unable to move: " + src + " to: " + dst + " with err: " + err.Error())
+		return nil, err
+	}
+
+	for _, meta := range moved {
+		_ = s.cache.DeleteMetadata(meta.Id)
+	}
+	for _, id := range replaced {
+		_ = s.cache.DeleteMetadata(id)
+	}
+
+	s.logger.Debug("Moved " + src + " to " + dst)
+	return moved, nil
+}
+
+// Copy copies a file or a whole dir of an owner to a new path in the tree of the session user, writing the
+// bytes into new blobs. Another owner's src has to be readable by the session user, a src that is not
+// being answered the same as one that does not exist. Files already at the destination are a conflict,
+// unless overwrite is set in which case they are trashed.
+func (s *Server) Copy(session types.Session, owner, src, dst string, overwrite, bypassGovernance bool) ([]types.Metadata, error) {
+	src = filepath.Clean(src)
+	dst = filepath.Clean(dst)
+	if owner == session.UserId && (src == dst || strings.HasPrefix(dst, src+"/")) {
+		return nil, types.Errorf(types.ErrInvalid, "can not copy %s into itself", src)
+	}
+	if !session.AllowsPath(src) || !session.AllowsPath(dst) {
+		return nil, types.Errorf(types.ErrForbidden, "api key can not access path: %s", src)
+	}
+	err := s.checkWrite(session)
+	if err != nil {
+		return nil, err
+	}
+	if !s.authorizePath(session, owner, src, types.PermRead) {
+		s.logger.Warn("Prevented Unauthorized copy of: " + src + " of " + owner + " by user " + session.UserId)
+		return nil, types.Errorf(types.ErrNotFound, "nothing found at path: %s", src)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var sources []types.Metadata
+	file, err := s.db.GetMetaDataByUserPath(owner, src)
+	if err == nil {
+		sources = []types.Metadata{file}
+	} else {
+		sources, err = s.db.GetMetadataSubtreeByUser(owner, src)
+		if err != nil {
+			s.logger.Error("Unable to find files under: " + src + " with err: " + err.Error())
+			return nil, err
+		}
+	}
+	dirs, err := s.db.GetDirSubtree(owner, src)
+	if err != nil {
+		s.logger.Error("Unable to find dirs under: " + src + " with err: " + err.Error())
+		return nil, err
+	}
+	if len(sources) == 0 && len(dirs) == 0 {
+		return nil, types.Errorf(types.ErrNotFound, "nothing found at path: %s", src)
+	}
+
+	now := time.Now()
+	replaced := make([]types.Metadata, 0)
+	for _, meta := range sources {
+		path := dst + strings.TrimPrefix(meta.Path, src)
+		existing, err := s.db.GetMetaDataByUserPath(session.UserId, path)
+		if err != nil {
+			continue
+		}
+		if !overwrite {
+			return nil, types.Errorf(types.ErrConflict, "path already exists at destination: %s", path)
+		}
+		if existing.Locked(now, bypassGovernance) {
+			return nil, types.Errorf(types.ErrLocked, "can not overwrite file under retention or legal hold: %s", path)
+		}
+		replaced = append(replaced, existing)
+	}
+
+	var total int64
+	for _, meta := range sources {
+		blob, err := s.db.GetBlobById(meta.Blob)
+		if err != nil {
+			s.logger.Error("Unable to find blob of file to copy: " + meta.Id + " with err: " + err.Error())
+			return nil, err
+		}
+		total += int64(blob.Size)
+	}
+	err = s.checkQuota(session.UserId, total, int64(len(sources)))
+	if err != nil {
+		s.logger.Warn("Rejected copy over quota for userId: " + session.UserId)
+		return nil, err
+	}
+
+	for _, meta := range replaced {
+		err = s.db.TrashMetadataById(meta.Id, now)
+		if err != nil {
+			s.logger.Error("Unable to trash overwritten file: " + meta.Id + " with err: " + err.Error())
+			return nil, err
+		}
+		_ = s.cache.DeleteMetadata(meta.Id)
+	}
+
+	copies := make([]types.Metadata, 0, len(sources))
+	for _, meta := range sources {
+		var content []byte
+		var copied types.Metadata
+
+		content, err = s.readContent(meta)
+		if err != nil {
+			s.logger.Error("Unable to read file to copy: " + meta.Id + " with err: " + err.Error())
+			break
+		}
+		_, copied, err = s.insertFile(dst+strings.TrimPrefix(meta.Path, src), content, session.UserId,
+			fileAttrs{Mime: meta.Mime, UserMeta: meta.UserMeta, Tags: meta.Tags})
+		if err != nil {
+			break
+		}
+		copies = append(copies, copied)
+	}
+
+	if err != nil {
+		// Undo the partial copy so that the destination is left as it was
+		for _, meta := range copies {
+			_ = s.purgeMetadata(meta)
+		}
+		for _, meta := range replaced {
+			_ = s.db.RestoreMetadataById(meta.Id)
+		}
+		return nil, err
+	}
+
+	for _, dir := range dirs {
+		err = s.db.EnsureDirs(session.UserId, dst+strings.TrimPrefix(dir.Path, src))
+		if err != nil {
+			s.logger.Error("Unable to copy dir: " + dir.Path + " with err: " + err.Error())
+		}
+	}
+
+	s.logger.Debug("Copied " + src + " to " + dst)
+	return copies, nil
+}
+
+// Children lists the immediate sub dirs and files of a dir of the session user
+func (s *Server) Children(session types.Session, path string) (ChildrenRes, error) {
+	path = filepath.Clean(path)
+	if !session.AllowsPath(path) {
+		return ChildrenRes{}, types.Errorf(types.ErrForbidden, "api key can not access path: %s", path)
+	}
+
+	dir, err := s.statDir(session.UserId, path)
+	if err != nil {
+		return ChildrenRes{}, err
+	}
+
+	dirs, err := s.db.GetChildDirs(session.UserId, path)
+	if err != nil {
+		s.logger.Error("Unable to list dirs under: " + path + " with err: " + err.Error())
+		return ChildrenRes{}, err
+	}
+	for i := range dirs {
+		err = s.db.FillDirStats(&dirs[i])
+		if err != nil {
+			s.logger.Error("Unable to stat dir: " + dirs[i].Path + " with err: " + err.Error())
+			return ChildrenRes{}, err
+		}
+	}
+
+	files, err := s.db.GetMetadataDirByUser(session.UserId, path)
+	if err != nil {
+		s.logger.Error("Unable to list files under: " + path + " with err: " + err.Error())
+		return ChildrenRes{}, err
+	}
+
+	return ChildrenRes{Dir: dir, Dirs: dirs, Files: files}, nil
+}
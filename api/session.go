@@ -0,0 +1,239 @@
+package api
+
+import (
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/newtoallofthis123/noob_store/types"
+	"github.com/newtoallofthis123/noob_store/utils"
+)
+
+// sessionTouchInterval is how stale the last seen time of a session can get before it is written back
+const sessionTouchInterval = time.Minute
+
+// newSession creates a session for a user logging in with the request
+func (s *Server) newSession(c *gin.Context, userId string) (types.Session, error) {
+	return s.createSession(clientInfo(c), userId)
+}
+
+// createSession creates a session for a user logging in from a client
+func (s *Server) createSession(client ClientInfo, userId string) (types.Session, error) {
+	now := time.Now()
+	session := types.Session{
+		Id:         utils.RandomToken(16),
+		UserId:     userId,
+		Ip:         client.Ip,
+		UserAgent:  client.UserAgent,
+		ExpiresAt:  now.Add(s.env.SessionTTL).Format(time.RFC3339Nano),
+		LastSeenAt: now.Format(time.RFC3339Nano),
+		CreatedAt:  now.Format(time.RFC3339Nano),
+	}
+
+	err := s.db.CreateSession(session)
+	if err != nil {
+		return types.Session{}, err
+	}
+
+	err = s.cache.InsertSession(session, s.env.SessionTTL)
+	if err != nil {
+		s.logger.Error("Error in inserting session to cache" + err.Error())
+	}
+
+	return session, nil
+}
+
+// sessionExpiry gets when a session expires, sessions from before expiry was tracked expiring
+// the session TTL after they were created
+func (s *Server) sessionExpiry(session types.Session) time.Time {
+	expiresAt, err := time.Parse(time.RFC3339Nano, session.ExpiresAt)
+	if err == nil {
+		return expiresAt
+	}
+	createdAt, err := time.Parse(time.RFC3339Nano, session.CreatedAt)
+	if err != nil {
+		return time.Time{}
+	}
+	return createdAt.Add(s.env.SessionTTL)
+}
+
+// sessionExpired checks if a session is past its absolute expiry or has been idle for too long
+func (s *Server) sessionExpired(session types.Session, now time.Time) bool {
+	if !now.Before(s.sessionExpiry(session)) {
+		return true
+	}
+
+	lastSeen, err := time.Parse(time.RFC3339Nano, session.LastSeenAt)
+	return err == nil && now.Sub(lastSeen) > s.env.SessionIdle
+}
+
+// touchSession moves the last seen time of a session forward, writing it back only every sessionTouchInterval
+func (s *Server) touchSession(session types.Session, now time.Time) types.Session {
+	lastSeen, err := time.Parse(time.RFC3339Nano, session.LastSeenAt)
+	if err == nil && now.Sub(lastSeen) < sessionTouchInterval {
+		return session
+	}
+
+	err = s.db.TouchSession(session.Id, now)
+	if err != nil {
+		s.logger.Error("Unable to touch session: " + session.Id + " with err: " + err.Error())
+		return session
+	}
+	session.LastSeenAt = now.Format(time.RFC3339Nano)
+	_ = s.cache.InsertSession(session, time.Until(s.sessionExpiry(session)))
+
+	return session
+}
+
+// revokeSession deletes a session from the db and the cache
+func (s *Server) revokeSession(id string) error {
+	err := s.db.DeleteSessionById(id)
+	if err != nil {
+		return err
+	}
+
+	err = s.cache.DeleteSession(id)
+	if err != nil {
+		s.logger.Error("Unable to drop session from cache: " + id + " with err: " + err.Error())
+	}
+	return nil
+}
+
+// revokeSessions deletes all the sessions of a user but the one to keep, if any,
+// along with the webdav credentials they have cached
+func (s *Server) revokeSessions(userId, keep string) (int, error) {
+	s.forgetDavCredentials(userId)
+
+	sessions, err := s.db.GetSessionsByUser(userId)
+	if err != nil {
+		return 0, err
+	}
+
+	n := 0
+	for _, session := range sessions {
+		if session.Id == keep {
+			continue
+		}
+		err = s.revokeSession(session.Id)
+		if err != nil {
+			return n, err
+		}
+		n++
+	}
+
+	return n, nil
+}
+
+// PurgeSessions deletes the expired and idle sessions from the db,
+// their cache entries expiring on their own
+func (s *Server) PurgeSessions() error {
+	n, err := s.db.DeleteExpiredSessions(time.Now(), s.env.SessionIdle)
+	if err != nil {
+		return err
+	}
+
+	if n > 0 {
+		s.logger.Info("Purged expired sessions")
+	}
+	return nil
+}
+
+func (s *Server) handleLogout(c *gin.Context) {
+	authKey := c.GetHeader("Authorization")
+	session, exists := authSession(c)
+	if !exists {
+		s.logger.Error("Unauthorized session: " + authKey)
+		abort(c, 401, "Invalid Authorization or missing session")
+		return
+	}
+
+	err := s.revokeSession(session.Id)
+	if err != nil {
+		s.logger.Error("Unable to delete session: " + session.Id + " with err: " + err.Error())
+		abortErr(c, err, "Unable to logout")
+		return
+	}
+
+	c.JSON(200, gin.H{"success": "Logged out"})
+}
+
+// handleUserSessions lists the active sessions of the session user, marking the one making the request
+func (s *Server) handleUserSessions(c *gin.Context) {
+	authKey := c.GetHeader("Authorization")
+	session, exists := authSession(c)
+	if !exists {
+		s.logger.Error("Unauthorized session: " + authKey)
+		abort(c, 401, "Invalid Authorization or missing session")
+		return
+	}
+
+	sessions, err := s.db.GetSessionsByUser(session.UserId)
+	if err != nil {
+		s.logger.Error("Unable to get sessions of userId: " + session.UserId + " with err: " + err.Error())
+		abortErr(c, err, "Unable to get sessions")
+		return
+	}
+
+	now := time.Now()
+	active := make([]types.Session, 0, len(sessions))
+	for _, other := range sessions {
+		if s.sessionExpired(other, now) {
+			continue
+		}
+		other.Current = other.Id == session.Id
+		active = append(active, other)
+	}
+
+	c.JSON(200, active)
+}
+
+func (s *Server) handleRevokeSession(c *gin.Context) {
+	authKey := c.GetHeader("Authorization")
+	session, exists := authSession(c)
+	if !exists {
+		s.logger.Error("Unauthorized session: " + authKey)
+		abort(c, 401, "Invalid Authorization or missing session")
+		return
+	}
+
+	id := c.Param("id")
+	other, err := s.db.GetSession(id)
+	if err != nil || other.UserId != session.UserId {
+		abort(c, 404, "Unable to find session")
+		return
+	}
+
+	err = s.revokeSession(id)
+	if err != nil {
+		s.logger.Error("Unable to delete session: " + id + " with err: " + err.Error())
+		abortErr(c, err, "Unable to revoke session")
+		return
+	}
+
+	c.JSON(200, gin.H{"success": "Revoked session"})
+}
+
+// handleRevokeAllSessions revokes every session of the session user, keeping the current one
+// when keep_current is true
+func (s *Server) handleRevokeAllSessions(c *gin.Context) {
+	authKey := c.GetHeader("Authorization")
+	session, exists := authSession(c)
+	if !exists {
+		s.logger.Error("Unauthorized session: " + authKey)
+		abort(c, 401, "Invalid Authorization or missing session")
+		return
+	}
+
+	keep := ""
+	if c.Query("keep_current") == "true" {
+		keep = session.Id
+	}
+
+	n, err := s.revokeSessions(session.UserId, keep)
+	if err != nil {
+		s.logger.Error("Unable to revoke sessions of userId: " + session.UserId + " with err: " + err.Error())
+		abortErr(c, err, "Unable to revoke sessions")
+		return
+	}
+
+	c.JSON(200, gin.H{"success": "Revoked sessions", "count": n})
+}
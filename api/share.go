@@ -0,0 +1,222 @@
+package api
+
+import (
+	"path/filepath"
+
+	"github.com/gin-gonic/gin"
+	"github.com/newtoallofthis123/noob_store/db"
+	"github.com/newtoallofthis123/noob_store/types"
+	"github.com/newtoallofthis123/ranhash"
+)
+
+// authorize checks if the session user has at least the needed permission on a file,
+// either as its owner, through their role in the group owning it or through a grant on it or on a dir above it.
+// Sessions of api keys limited to a path prefix only reach the files under it.
+func (s *Server) authorize(session types.Session, meta types.Metadata, needed string) bool {
+	return s.authorizePath(session, meta.UserId, meta.Path, needed)
+}
+
+// authorizePath checks if the session user has at least the needed permission on a path in the tree of an owner,
+// which may be a dir, in the same ways as authorize
+func (s *Server) authorizePath(session types.Session, ownerId, path, needed string) bool {
+	if !session.AllowsPath(path) {
+		return false
+	}
+	if ownerId == session.UserId {
+		return true
+	}
+	if isGroupId(ownerId) && types.PermissionAllows(types.RolePermission(s.groupRole(session.UserId, ownerId)), needed) {
+		return true
+	}
+
+	grants, err := s.db.GetGrantsCovering(ownerId, path, session.UserId)
+	if err != nil {
+		s.logger.Error("Unable to get grants for path: " + path + " of owner: " + ownerId + " with err: " + err.Error())
+		return false
+	}
+	for _, grant := range grants {
+		if types.PermissionAllows(grant.Permission, needed) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// authorizedMetadata gets the metadata in the id param if the session user has the needed permission on it
+func (s *Server) authorizedMetadata(c *gin.Context, session types.Session, needed string) (types.Metadata, bool) {
+	id := c.Param("id")
+
+	s.mu.RLock()
+	meta, err := s.db.GetMetaDataById(id)
+	s.mu.RUnlock()
+	if err != nil {
+		s.logger.Error("No metadata with id: " + id + " with err: " + err.Error())
+		abortErr(c, err, "Failed to retrieve metadata: "+err.Error())
+		return types.Metadata{}, false
+	}
+
+	if !s.authorize(session, meta, needed) {
+		s.logger.Warn("Prevented Unauthorized access for file from userId" + session.UserId)
+		abort(c, 403, "Unauthorized access to file from userId: "+session.UserId)
+		return types.Metadata{}, false
+	}
+
+	return meta, true
+}
+
+// handleShare grants a user or a group a permission on a file or dir of the session user
+func (s *Server) handleShare(c *gin.Context) {
+	authKey := c.GetHeader("Authorization")
+	session, exists := authSession(c)
+	if !exists {
+		s.logger.Error("Unauthorized session: " + authKey)
+		abort(c, 401, "Invalid Authorization or missing session")
+		return
+	}
+
+	path, exists := c.GetPostForm("path")
+	if !exists {
+		abort(c, 400, "Path is needed in the post form")
+		return
+	}
+	email := c.PostForm("email")
+	groupId := c.PostForm("group")
+	if email == "" && groupId == "" {
+		abort(c, 400, "Email of the user or id of the group to share with is needed")
+		return
+	}
+	permission := c.DefaultPostForm("permission", types.PermRead)
+	if !types.ValidPermission(permission) {
+		abort(c, 400, "permission must be one of read, write or owner")
+		return
+	}
+
+	path = filepath.Clean(path)
+	if !db.IsRootDir(path) {
+		_, fileErr := s.db.GetMetaDataByUserPath(session.UserId, path)
+		_, dirErr := s.db.GetDirByUserPath(session.UserId, path)
+		if fileErr != nil && dirErr != nil {
+			abort(c, 404, "Nothing found at path: "+path)
+			return
+		}
+	}
+
+	granteeId := groupId
+	if groupId != "" {
+		if _, err := s.db.GetGroup(groupId); err != nil {
+			abort(c, 404, "Group not found")
+			return
+		}
+	} else {
+		grantee, err := s.db.GetUserByEmail(email)
+		if err != nil {
+			abortErr(c, err, "User not found")
+			return
+		}
+		granteeId = grantee.Id
+	}
+	if granteeId == session.UserId {
+		abort(c, 400, "Can not share with yourself")
+		return
+	}
+
+	grant := types.Grant{
+		Id:         ranhash.GenerateRandomString(8),
+		OwnerId:    session.UserId,
+		Path:       path,
+		GranteeId:  granteeId,
+		Permission: permission,
+	}
+	err := s.db.CreateGrant(grant)
+	if err != nil {
+		s.logger.Error("Unable to share path: " + path + " with err: " + err.Error())
+		abortErr(c, err, "Unable to share: "+err.Error())
+		return
+	}
+
+	c.JSON(200, grant)
+}
+
+func (s *Server) handleUserGrants(c *gin.Context) {
+	authKey := c.GetHeader("Authorization")
+	session, exists := authSession(c)
+	if !exists {
+		s.logger.Error("Unauthorized session: " + authKey)
+		abort(c, 401, "Invalid Authorization or missing session")
+		return
+	}
+
+	grants, err := s.db.GetGrantsByOwner(session.UserId)
+	if err != nil {
+		s.logger.Error("Unable to get grants of userId: " + session.UserId + " with err: " + err.Error())
+		abortErr(c, err, "Unable to get shares")
+		return
+	}
+
+	c.JSON(200, grants)
+}
+
+func (s *Server) handleUnshare(c *gin.Context) {
+	authKey := c.GetHeader("Authorization")
+	session, exists := authSession(c)
+	if !exists {
+		s.logger.Error("Unauthorized session: " + authKey)
+		abort(c, 401, "Invalid Authorization or missing session")
+		return
+	}
+
+	id := c.Param("id")
+	grant, err := s.db.GetGrantById(id)
+	if err != nil {
+		abortErr(c, err, "Unable to find share")
+		return
+	}
+	if grant.OwnerId != session.UserId {
+		s.logger.Warn("Prevented Unauthorized removal of share: " + id + " by user " + session.UserId)
+		abort(c, 403, "Unauthorized share access")
+		return
+	}
+
+	err = s.db.DeleteGrantById(id)
+	if err != nil {
+		s.logger.Error("Unable to remove share: " + id + " with err: " + err.Error())
+		abortErr(c, err, "Unable to remove share")
+		return
+	}
+
+	c.JSON(200, gin.H{"success": "Removed share with id: " + id})
+}
+
+// handleSharedWithMe lists the grants given to the session user and a page of the files they cover
+func (s *Server) handleSharedWithMe(c *gin.Context) {
+	authKey := c.GetHeader("Authorization")
+	session, exists := authSession(c)
+	if !exists {
+		s.logger.Error("Unauthorized session: " + authKey)
+		abort(c, 401, "Invalid Authorization or missing session")
+		return
+	}
+
+	opts, err := parseListOptions(c)
+	if err != nil {
+		abortErr(c, err, err.Error())
+		return
+	}
+
+	grants, err := s.db.GetGrantsForGrantee(session.UserId)
+	if err != nil {
+		s.logger.Error("Unable to get grants for userId: " + session.UserId + " with err: " + err.Error())
+		abortErr(c, err, "Unable to get shared files")
+		return
+	}
+
+	files, err := s.db.ListSharedMetadata(session.UserId, opts)
+	if err != nil {
+		s.logger.Error("Unable to list shared files for userId: " + session.UserId + " with err: " + err.Error())
+		abortErr(c, err, "Unable to get shared files: "+err.Error())
+		return
+	}
+
+	c.JSON(200, gin.H{"grants": grants, "files": files})
+}
@@ -0,0 +1,132 @@
+package api
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/newtoallofthis123/noob_store/types"
+)
+
+// maxTags is the most tags a file can have
+const maxTags = 10
+
+// maxUserMetaSize is the largest total size in bytes of the user metadata of a file
+const maxUserMetaSize = 2048
+
+// prefixedFields collects the post form fields and headers with the given prefixes into a map,
+// keyed by the lowercased rest of the name. An empty prefix skips that source.
+func prefixedFields(c *gin.Context, formPrefix, headerPrefix string) map[string]string {
+	fields := make(map[string]string)
+
+	if headerPrefix != "" {
+		headerPrefix = http.CanonicalHeaderKey(headerPrefix)
+		for name, values := range c.Request.Header {
+			if strings.HasPrefix(name, headerPrefix) && len(values) > 0 {
+				fields[strings.ToLower(strings.TrimPrefix(name, headerPrefix))] = values[0]
+			}
+		}
+	}
+
+	if formPrefix != "" {
+		_, _ = c.MultipartForm()
+		_ = c.Request.ParseForm()
+		for name, values := range c.Request.PostForm {
+			if strings.HasPrefix(name, formPrefix) && len(values) > 0 {
+				fields[strings.ToLower(strings.TrimPrefix(name, formPrefix))] = values[0]
+			}
+		}
+	}
+
+	return fields
+}
+
+// validateTags checks the count and length limits of tags
+func validateTags(tags map[string]string) error {
+	if len(tags) > maxTags {
+		return types.Errorf(types.ErrInvalid, "at most %d tags are allowed", maxTags)
+	}
+	for k, v := range tags {
+		if k == "" || len(k) > 128 || len(v) > 256 {
+			return types.Errorf(types.ErrInvalid, "tag keys must be 1 to 128 and values up to 256 characters")
+		}
+	}
+	return nil
+}
+
+// validateUserMeta checks the total size of user metadata
+func validateUserMeta(meta map[string]string) error {
+	size := 0
+	for k, v := range meta {
+		size += len(k) + len(v)
+	}
+	if size > maxUserMetaSize {
+		return types.Errorf(types.ErrInvalid, "user metadata can be at most %d bytes", maxUserMetaSize)
+	}
+	return nil
+}
+
+// parseTagFilter parses tag filters of the form key=value
+func parseTagFilter(filters []string) (map[string]string, error) {
+	tags := make(map[string]string)
+	for _, f := range filters {
+		k, v, ok := strings.Cut(f, "=")
+		if !ok || k == "" {
+			return nil, types.Errorf(types.ErrInvalid, "tag filters must be of the form key=value")
+		}
+		tags[strings.ToLower(k)] = v
+	}
+	return tags, nil
+}
+
+func (s *Server) handleGetTags(c *gin.Context) {
+	authKey := c.GetHeader("Authorization")
+	session, exists := authSession(c)
+	if !exists {
+		s.logger.Error("Unauthorized session: " + authKey)
+		abort(c, 401, "Invalid Authorization or missing session")
+		return
+	}
+
+	meta, ok := s.authorizedMetadata(c, session, types.PermRead)
+	if !ok {
+		return
+	}
+
+	c.JSON(200, gin.H{"id": meta.Id, "tags": meta.Tags})
+}
+
+// handleSetTags replaces the tags of a file with the tag-<key> fields of the post form
+func (s *Server) handleSetTags(c *gin.Context) {
+	authKey := c.GetHeader("Authorization")
+	session, exists := authSession(c)
+	if !exists {
+		s.logger.Error("Unauthorized session: " + authKey)
+		abort(c, 401, "Invalid Authorization or missing session")
+		return
+	}
+
+	meta, ok := s.authorizedMetadata(c, session, types.PermWrite)
+	if !ok {
+		return
+	}
+
+	tags := prefixedFields(c, "tag-", "")
+	err := validateTags(tags)
+	if err != nil {
+		abortErr(c, err, err.Error())
+		return
+	}
+
+	s.mu.Lock()
+	err = s.db.SetTags(meta.Id, tags)
+	s.mu.Unlock()
+	if err != nil {
+		s.logger.Error("Unable to set tags of file: " + meta.Id + " with err: " + err.Error())
+		abortErr(c, err, "Unable to set tags: "+err.Error())
+		return
+	}
+	_ = s.cache.DeleteMetadata(meta.Id)
+
+	c.JSON(200, gin.H{"id": meta.Id, "tags": tags})
+}
@@ -0,0 +1,204 @@
+package api
+
+import (
+	"errors"
+	"path/filepath"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/newtoallofthis123/noob_store/types"
+)
+
+func (s *Server) handleUserTrash(c *gin.Context) {
+	authKey := c.GetHeader("Authorization")
+	session, exists := authSession(c)
+	if !exists {
+		s.logger.Error("Unauthorized session: " + authKey)
+		abort(c, 401, "Invalid Authorization or missing session")
+		return
+	}
+
+	metas, err := s.db.GetTrashByUser(session.UserId)
+	if err != nil {
+		s.logger.Error("Unable to fetch trash for userId: " + session.UserId + " with err: " + err.Error())
+		abortErr(c, err, "Unable to fetch trash")
+		return
+	}
+
+	c.JSON(200, metas)
+}
+
+// restore moves the given trashed metadatas back to their original paths.
+// It fails without restoring anything if any of the paths are taken in the meantime,
+// or if the same path was trashed more than once and would be restored twice.
+func (s *Server) restore(userId string, metas []types.Metadata) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	paths := make(map[string]bool, len(metas))
+	for _, meta := range metas {
+		if paths[meta.Path] {
+			return types.Errorf(types.ErrConflict, "path %s is in the trash more than once, restore one of them by id", meta.Path)
+		}
+		paths[meta.Path] = true
+
+		_, err := s.db.GetMetaDataByUserPath(userId, meta.Path)
+		if err == nil {
+			return types.Errorf(types.ErrConflict, "path %s already exists", meta.Path)
+		}
+	}
+
+	return s.db.RestoreMetadatas(metas)
+}
+
+func (s *Server) handleRestoreTrash(c *gin.Context) {
+	authKey := c.GetHeader("Authorization")
+	session, exists := authSession(c)
+	if !exists {
+		s.logger.Error("Unauthorized session: " + authKey)
+		abort(c, 401, "Invalid Authorization or missing session")
+		return
+	}
+
+	id := c.Param("id")
+	meta, err := s.db.GetTrashedMetadataById(id)
+	if err != nil {
+		s.logger.Error("Unable to find trashed file with id: " + id + " with err: " + err.Error())
+		abortErr(c, err, "Unable to find file in trash")
+		return
+	}
+
+	if meta.UserId != session.UserId {
+		s.logger.Warn("Prevented Unauthorized restore of file: " + id + " by user " + session.UserId)
+		abort(c, 403, "Unauthorized file access")
+		return
+	}
+
+	err = s.restore(session.UserId, []types.Metadata{meta})
+	if err != nil {
+		s.logger.Error("Unable to restore file with id: " + id + " with err: " + err.Error())
+		abortErr(c, err, "Unable to restore file: "+err.Error())
+		return
+	}
+
+	meta.TrashedAt = ""
+	c.JSON(200, meta)
+}
+
+func (s *Server) handleRestoreTrashDir(c *gin.Context) {
+	authKey := c.GetHeader("Authorization")
+	session, exists := authSession(c)
+	if !exists {
+		s.logger.Error("Unauthorized session: " + authKey)
+		abort(c, 401, "Invalid Authorization or missing session")
+		return
+	}
+
+	dir := filepath.Clean(c.Param("dir"))
+	metas, err := s.db.GetTrashDirByUser(session.UserId, dir)
+	if err != nil {
+		s.logger.Error("Unable to find trashed dir: " + dir + " with err: " + err.Error())
+		abortErr(c, err, "Unable to find dir in trash")
+		return
+	}
+	if len(metas) == 0 {
+		abort(c, 404, "No files of dir "+dir+" found in trash")
+		return
+	}
+
+	err = s.restore(session.UserId, metas)
+	if err != nil {
+		s.logger.Error("Unable to restore dir: " + dir + " with err: " + err.Error())
+		abortErr(c, err, "Unable to restore dir: "+err.Error())
+		return
+	}
+
+	c.JSON(200, gin.H{"success": "Restored dir: " + dir})
+}
+
+func (s *Server) handleEmptyTrash(c *gin.Context) {
+	authKey := c.GetHeader("Authorization")
+	session, exists := authSession(c)
+	if !exists {
+		s.logger.Error("Unauthorized session: " + authKey)
+		abort(c, 401, "Invalid Authorization or missing session")
+		return
+	}
+
+	metas, err := s.db.GetTrashByUser(session.UserId)
+	if err != nil {
+		s.logger.Error("Unable to fetch trash for userId: " + session.UserId + " with err: " + err.Error())
+		abortErr(c, err, "Unable to fetch trash")
+		return
+	}
+
+	s.mu.Lock()
+	for _, meta := range metas {
+		err = s.purgeMetadata(meta)
+		if errors.Is(err, errObjectLocked) {
+			err = nil
+			continue
+		}
+		if err != nil {
+			break
+		}
+	}
+	s.mu.Unlock()
+	if err != nil {
+		s.logger.Error("Unable to empty trash for userId: " + session.UserId + " with err: " + err.Error())
+		abortErr(c, err, "Unable to empty trash")
+		return
+	}
+
+	c.JSON(200, gin.H{"success": "Emptied trash"})
+}
+
+// purgeMetadata permanently deletes a metadata and marks its blob as deleted,
+// making the blob eligible to be freed up by DeleteFreeSpace
+func (s *Server) purgeMetadata(meta types.Metadata) error {
+	if meta.Locked(time.Now(), false) {
+		return errObjectLocked
+	}
+
+	blob, err := s.db.GetBlobById(meta.Blob)
+	if err != nil {
+		return err
+	}
+
+	err = s.db.DeleteMetadataById(meta.Id)
+	if err != nil {
+		return err
+	}
+	_ = s.cache.DeleteMetadata(meta.Id)
+
+	err = s.db.AddUsage(meta.UserId, -int64(blob.Size), -1)
+	if err != nil {
+		s.logger.Error("Unable to reduce usage of userId: " + meta.UserId + " with err: " + err.Error())
+	}
+
+	return s.db.MarkBlobDelete(meta.Blob)
+}
+
+// PurgeTrash permanently deletes every trashed file older than the trash retention period
+func (s *Server) PurgeTrash() error {
+	metas, err := s.db.GetExpiredTrash(time.Now().Add(-s.env.TrashRetention))
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, meta := range metas {
+		err = s.purgeMetadata(meta)
+		if errors.Is(err, errObjectLocked) {
+			s.logger.Warn("Keeping locked trashed file with id: " + meta.Id)
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		s.logger.Info("Purged trashed file with id: " + meta.Id)
+	}
+
+	return nil
+}
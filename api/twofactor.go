@@ -0,0 +1,265 @@
+package api
+
+import (
+	"crypto/hmac"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/newtoallofthis123/noob_store/auth"
+	"github.com/newtoallofthis123/noob_store/types"
+	"github.com/newtoallofthis123/noob_store/utils"
+)
+
+const (
+	// totpIssuer is the name authenticator apps show the codes under
+	totpIssuer = "noob_store"
+	// recoveryCodeCount is how many single use recovery codes a user gets
+	recoveryCodeCount = 10
+	// challengeExpiry is how long a user has to enter their code after their password
+	challengeExpiry = 5 * time.Minute
+)
+
+// loginChallenge creates a token proving that the password of a user was checked,
+// to be exchanged along with a second factor for a session
+func (s *Server) loginChallenge(userId string) string {
+	exp := strconv.FormatInt(time.Now().Add(challengeExpiry).Unix(), 10)
+	return userId + "." + exp + "." + s.signature("2fa", userId, exp)
+}
+
+// checkChallenge gets the user a login challenge was created for, if it is valid
+func (s *Server) checkChallenge(challenge string) (string, bool) {
+	parts := strings.Split(challenge, ".")
+	if len(parts) != 3 || !hmac.Equal([]byte(parts[2]), []byte(s.signature("2fa", parts[0], parts[1]))) {
+		return "", false
+	}
+	exp, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil || time.Now().Unix() > exp {
+		return "", false
+	}
+
+	return parts[0], true
+}
+
+// checkSecondFactor checks a totp code or, without one, a recovery code of a user, using it up
+func (s *Server) checkSecondFactor(totp types.TOTP, code, recovery string) bool {
+	if code != "" {
+		counter, ok := auth.ValidateTOTP(totp.Secret, strings.TrimSpace(code), time.Now(), totp.LastCounter)
+		if !ok {
+			return false
+		}
+		ok, err := s.db.UseTOTPCounter(totp.UserId, counter)
+		return err == nil && ok
+	}
+
+	if recovery != "" {
+		ok, err := s.db.UseRecoveryCode(totp.UserId, utils.CalHash([]byte(strings.ToLower(strings.TrimSpace(recovery)))))
+		if err == nil && ok {
+			s.logger.Warn("Recovery code used by userId: " + totp.UserId)
+		}
+		return err == nil && ok
+	}
+
+	return false
+}
+
+// newRecoveryCodes replaces the recovery codes of a user, returning the codes which are only stored hashed
+func (s *Server) newRecoveryCodes(userId string) ([]string, error) {
+	codes := make([]string, recoveryCodeCount)
+	hashes := make([]string, recoveryCodeCount)
+	for i := range codes {
+		codes[i] = utils.RandomToken(5)
+		hashes[i] = utils.CalHash([]byte(codes[i]))
+	}
+
+	err := s.db.SetRecoveryCodes(userId, hashes)
+	if err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// enabledTOTP gets the enabled totp secret of the session user
+func (s *Server) enabledTOTP(c *gin.Context, session types.Session) (types.TOTP, bool) {
+	totp, err := s.db.GetTOTP(session.UserId)
+	if err != nil || !totp.Enabled {
+		abort(c, 409, "Two factor login is not enabled")
+		return types.TOTP{}, false
+	}
+	return totp, true
+}
+
+func (s *Server) handleTOTPStatus(c *gin.Context) {
+	authKey := c.GetHeader("Authorization")
+	session, exists := authSession(c)
+	if !exists {
+		s.logger.Error("Unauthorized session: " + authKey)
+		abort(c, 401, "Invalid Authorization or missing session")
+		return
+	}
+
+	totp, err := s.db.GetTOTP(session.UserId)
+	if err != nil || !totp.Enabled {
+		c.JSON(200, gin.H{"enabled": false})
+		return
+	}
+	remaining, err := s.db.CountRecoveryCodes(session.UserId)
+	if err != nil {
+		s.logger.Error("Unable to count recovery codes of userId: " + session.UserId + " with err: " + err.Error())
+	}
+
+	c.JSON(200, gin.H{"enabled": true, "recovery_codes": remaining})
+}
+
+// handleEnrollTOTP creates a new secret for the session user, which only takes effect once confirmed
+func (s *Server) handleEnrollTOTP(c *gin.Context) {
+	authKey := c.GetHeader("Authorization")
+	session, exists := authSession(c)
+	if !exists {
+		s.logger.Error("Unauthorized session: " + authKey)
+		abort(c, 401, "Invalid Authorization or missing session")
+		return
+	}
+
+	if totp, err := s.db.GetTOTP(session.UserId); err == nil && totp.Enabled {
+		abort(c, 409, "Two factor login is already enabled")
+		return
+	}
+	user, err := s.db.GetUser(session.UserId)
+	if err != nil {
+		abortErr(c, err, "No valid user found for sessionId "+session.Id)
+		return
+	}
+
+	secret := auth.GenerateTOTPSecret()
+	err = s.db.SetTOTP(user.Id, secret)
+	if err != nil {
+		s.logger.Error("Unable to store totp secret of userId: " + user.Id + " with err: " + err.Error())
+		abortErr(c, err, "Unable to enroll")
+		return
+	}
+
+	c.JSON(200, gin.H{"secret": secret, "uri": auth.TOTPURI(totpIssuer, user.Email, secret)})
+}
+
+// handleConfirmTOTP enables the enrolled secret with a code from it, returning the recovery codes once
+func (s *Server) handleConfirmTOTP(c *gin.Context) {
+	authKey := c.GetHeader("Authorization")
+	session, exists := authSession(c)
+	if !exists {
+		s.logger.Error("Unauthorized session: " + authKey)
+		abort(c, 401, "Invalid Authorization or missing session")
+		return
+	}
+
+	totp, err := s.db.GetTOTP(session.UserId)
+	if err != nil {
+		abortErr(c, err, "Enroll before confirming")
+		return
+	}
+	if totp.Enabled {
+		abort(c, 409, "Two factor login is already enabled")
+		return
+	}
+	if !s.checkSecondFactor(totp, c.PostForm("code"), "") {
+		abort(c, 400, "Invalid code")
+		return
+	}
+
+	codes, err := s.newRecoveryCodes(session.UserId)
+	if err == nil {
+		err = s.db.EnableTOTP(session.UserId)
+	}
+	if err != nil {
+		s.logger.Error("Unable to enable totp of userId: " + session.UserId + " with err: " + err.Error())
+		abortErr(c, err, "Unable to enable two factor login")
+		return
+	}
+
+	c.JSON(200, gin.H{"success": "Enabled two factor login", "recovery_codes": codes})
+}
+
+// handleDisableTOTP turns off two factor logins, which needs a current code or a recovery code
+func (s *Server) handleDisableTOTP(c *gin.Context) {
+	authKey := c.GetHeader("Authorization")
+	session, exists := authSession(c)
+	if !exists {
+		s.logger.Error("Unauthorized session: " + authKey)
+		abort(c, 401, "Invalid Authorization or missing session")
+		return
+	}
+
+	totp, ok := s.enabledTOTP(c, session)
+	if !ok {
+		return
+	}
+	if !s.checkSecondFactor(totp, c.PostForm("code"), c.PostForm("recovery_code")) {
+		abort(c, 400, "Invalid code")
+		return
+	}
+
+	err := s.db.DeleteTOTP(session.UserId)
+	if err != nil {
+		s.logger.Error("Unable to disable totp of userId: " + session.UserId + " with err: " + err.Error())
+		abortErr(c, err, "Unable to disable two factor login")
+		return
+	}
+
+	c.JSON(200, gin.H{"success": "Disabled two factor login"})
+}
+
+func (s *Server) handleRegenerateRecoveryCodes(c *gin.Context) {
+	authKey := c.GetHeader("Authorization")
+	session, exists := authSession(c)
+	if !exists {
+		s.logger.Error("Unauthorized session: " + authKey)
+		abort(c, 401, "Invalid Authorization or missing session")
+		return
+	}
+
+	totp, ok := s.enabledTOTP(c, session)
+	if !ok {
+		return
+	}
+	if !s.checkSecondFactor(totp, c.PostForm("code"), "") {
+		abort(c, 400, "Invalid code")
+		return
+	}
+
+	codes, err := s.newRecoveryCodes(session.UserId)
+	if err != nil {
+		s.logger.Error("Unable to create recovery codes of userId: " + session.UserId + " with err: " + err.Error())
+		abortErr(c, err, "Unable to create recovery codes")
+		return
+	}
+
+	c.JSON(200, gin.H{"recovery_codes": codes})
+}
+
+// handleLoginTwoFactor finishes a login of a user with two factor login enabled,
+// exchanging the challenge from handleLoginUser and a code or recovery code for a session
+func (s *Server) handleLoginTwoFactor(c *gin.Context) {
+	session, err := s.LoginTwoFactor(clientInfo(c), c.PostForm("challenge"), c.PostForm("code"), c.PostForm("recovery_code"))
+	if err != nil {
+		loginErr(c, err)
+		return
+	}
+
+	c.JSON(200, session)
+}
+
+// handleAdminResetTOTP turns off two factor logins of a user who lost their device and recovery codes
+func (s *Server) handleAdminResetTOTP(c *gin.Context) {
+	id := c.Param("id")
+
+	err := s.db.DeleteTOTP(id)
+	if err != nil {
+		s.logger.Error("Unable to reset totp of user: " + id + " with err: " + err.Error())
+		abortErr(c, err, "Unable to reset two factor login")
+		return
+	}
+
+	s.audit(c, "reset_2fa", id, "")
+	c.JSON(200, gin.H{"success": "Reset two factor login of user " + id})
+}
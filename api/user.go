@@ -1,62 +1,67 @@
 package api
 
 import (
+	"errors"
 	"path/filepath"
 	"strings"
 
 	"github.com/gin-gonic/gin"
 	"github.com/newtoallofthis123/noob_store/types"
 	"github.com/newtoallofthis123/ranhash"
-	"golang.org/x/crypto/bcrypt"
 )
 
 func (s *Server) handleCreateUser(c *gin.Context) {
+	if !s.env.LocalLogin {
+		abort(c, 403, "Local logins are disabled, log in through the identity provider")
+		return
+	}
+
 	email, exists := c.GetPostForm("email")
 	if !exists {
-		c.JSON(500, gin.H{"err": "Email is needed"})
+		abort(c, 400, "Email is needed")
 		return
 	}
 	password, exists := c.GetPostForm("password")
 	if !exists {
-		c.JSON(500, gin.H{"err": "Password is needed"})
+		abort(c, 400, "Password is needed")
+		return
+	}
+
+	if _, err := s.db.GetUserByEmail(email); err == nil {
+		abort(c, 409, "Email is already registered")
 		return
 	}
 
-	passHash, err := bcrypt.GenerateFromPassword([]byte(password), 0)
+	passHash, err := s.hashPassword(password)
 	if err != nil {
 		s.logger.Error("Failure in hashing password: " + err.Error())
-		c.JSON(500, gin.H{"err": "Password hashing failed with err: " + err.Error()})
+		abortErr(c, err, "Password hashing failed with err: "+err.Error())
 		return
 	}
 
 	user := types.User{
 		Id:       ranhash.GenerateRandomString(8),
 		Email:    email,
-		Password: string(passHash),
+		Password: passHash,
+		Role:     types.UserRegular,
 	}
-
 	err = s.db.CreateUser(user)
 	if err != nil {
 		s.logger.Error("Failed to create user" + user.Id + " with err: " + err.Error())
-		c.JSON(500, gin.H{"err": "Error creating user: " + err.Error()})
+		abortErr(c, err, "Error creating user: "+err.Error())
 		return
 	}
 
-	session := types.Session{
-		Id:     ranhash.GenerateRandomString(16),
-		UserId: user.Id,
-	}
-
-	err = s.db.CreateSession(session)
+	err = s.sendUserToken(user, types.TokenVerify)
 	if err != nil {
-		s.logger.Error("Failed to create session" + session.Id + " with err: " + err.Error())
-		c.JSON(500, gin.H{"err": "Error creating session: " + err.Error()})
-		return
+		s.logger.Error("Unable to send verification mail to " + user.Id + " with err: " + err.Error())
 	}
 
-	err = s.cache.InsertSession(session)
+	session, err := s.newSession(c, user.Id)
 	if err != nil {
-		s.logger.Error("Error in inserting session to cache" + err.Error())
+		s.logger.Error("Failed to create session for " + user.Id + " with err: " + err.Error())
+		abortErr(c, err, "Error creating session: "+err.Error())
+		return
 	}
 
 	c.JSON(200, session)
@@ -65,44 +70,44 @@ func (s *Server) handleCreateUser(c *gin.Context) {
 func (s *Server) handleLoginUser(c *gin.Context) {
 	email, exists := c.GetPostForm("email")
 	if !exists {
-		c.JSON(500, gin.H{"err": "Email is needed"})
+		abort(c, 400, "Email is needed")
 		return
 	}
 	password, exists := c.GetPostForm("password")
 	if !exists {
-		c.JSON(500, gin.H{"err": "Password is needed"})
+		abort(c, 400, "Password is needed")
 		return
 	}
 
-	user, err := s.db.GetUserByEmail(email)
+	session, challenge, err := s.Login(clientInfo(c), email, password)
 	if err != nil {
-		c.JSON(500, gin.H{"err": "User not found"})
+		loginErr(c, err)
 		return
 	}
-
-	if bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(password)) != nil {
-		s.logger.Error("Matching passwords not found for")
-		c.JSON(500, gin.H{"err": "Authorization failed"})
+	if challenge != "" {
+		c.JSON(200, gin.H{"two_factor": true, "challenge": challenge})
 		return
 	}
 
-	session := types.Session{
-		Id:     ranhash.GenerateRandomString(16),
-		UserId: user.Id,
-	}
-	err = s.db.CreateSession(session)
-	if err != nil {
-		s.logger.Error("Failed to create session" + session.Id + " with err: " + err.Error())
-		c.JSON(500, gin.H{"err": "Error creating session: " + err.Error()})
-		return
-	}
+	c.JSON(200, session)
+}
 
-	err = s.cache.InsertSession(session)
-	if err != nil {
-		s.logger.Error("Error in inserting session to cache" + err.Error())
+// loginErr answers a failed login, telling locked out and rate limited clients when to retry
+func loginErr(c *gin.Context, err error) {
+	var locked *LockedOutError
+	var limited *RateLimitedError
+	switch {
+	case errors.As(err, &locked):
+		tooManyRequests(c, "Account is locked after too many failed logins", locked.RetryAfter)
+	case errors.As(err, &limited):
+		tooManyRequests(c, "Too many attempts", limited.RetryAfter)
+	case errors.Is(err, types.ErrNotFound):
+		abortErr(c, err, "User not found")
+	case errors.Is(err, types.ErrUnauthorized), errors.Is(err, types.ErrForbidden), errors.Is(err, types.ErrInvalid):
+		abortErr(c, err, err.Error())
+	default:
+		abortErr(c, err, "Error creating session: "+err.Error())
 	}
-
-	c.JSON(200, session)
 }
 
 // buildDir constructs a multi-directory system as a nested map.
@@ -130,53 +135,42 @@ func buildDir(files []types.Metadata) map[string]interface{} {
 
 func (s *Server) handleUserLs(c *gin.Context) {
 	authKey := c.GetHeader("Authorization")
-	session, exists := s.checkAuth(authKey)
+	session, exists := authSession(c)
 	if !exists {
 		s.logger.Error("Unauthorized session: " + authKey)
-		c.JSON(500, gin.H{"err": "Invalid Authorization or missing session"})
+		abort(c, 401, "Invalid Authorization or missing session")
 		return
 	}
 
-	user, err := s.db.GetUser(session.UserId)
+	opts, err := parseListOptions(c)
 	if err != nil {
-		s.logger.Error("Unable to get user with id: " + user.Id + " with err: " + err.Error())
-		c.JSON(500, gin.H{"err": "No valid user found for sessionId " + session.Id})
+		abortErr(c, err, err.Error())
 		return
 	}
 
-	dir, exists := c.GetQuery("dir")
-	var metas []types.Metadata
-
-	if exists {
-		dir = filepath.Clean(dir)
-		metas, err = s.db.GetMetadataDirByUser(user.Id, dir)
-	} else {
-		metas, err = s.db.GetMetadatasByUser(user.Id)
-	}
-
+	res, err := s.List(session, opts)
 	if err != nil {
-		s.logger.Error("Unable to fetch user files for userId: " + user.Id + " with err: " + err.Error())
-		c.JSON(500, gin.H{"err": "Unable to fetch user files"})
+		abortErr(c, err, "Unable to fetch user files: "+err.Error())
 		return
 	}
 
 	s.logger.Debug("Successfully returned user ls")
-	c.JSON(200, metas)
+	c.JSON(200, res)
 }
 
 func (s *Server) handleUserPathLs(c *gin.Context) {
 	authKey := c.GetHeader("Authorization")
-	session, exists := s.checkAuth(authKey)
+	session, exists := authSession(c)
 	if !exists {
 		s.logger.Error("Unauthorized session: " + authKey)
-		c.JSON(500, gin.H{"err": "Invalid Authorization or missing session"})
+		abort(c, 401, "Invalid Authorization or missing session")
 		return
 	}
 
 	user, err := s.db.GetUser(session.UserId)
 	if err != nil {
 		s.logger.Error("Unable to get user with id: " + user.Id + " with err: " + err.Error())
-		c.JSON(500, gin.H{"err": "No valid user found for sessionId " + session.Id})
+		abortErr(c, err, "No valid user found for sessionId "+session.Id)
 		return
 	}
 
@@ -192,10 +186,17 @@ func (s *Server) handleUserPathLs(c *gin.Context) {
 
 	if err != nil {
 		s.logger.Error("Unable to fetch user files for userId: " + user.Id + " with err: " + err.Error())
-		c.JSON(500, gin.H{"err": "Unable to fetch user files"})
+		abortErr(c, err, "Unable to fetch user files")
 		return
 	}
 
+	allowed := make([]types.Metadata, 0, len(metas))
+	for _, meta := range metas {
+		if session.AllowsPath(meta.Path) {
+			allowed = append(allowed, meta)
+		}
+	}
+
 	s.logger.Debug("Successfully returned user ls")
-	c.JSON(200, buildDir(metas))
+	c.JSON(200, buildDir(allowed))
 }
@@ -0,0 +1,34 @@
+package api
+
+import "time"
+
+// workerInterval is how often the background jobs run
+const workerInterval = time.Hour
+
+// runWorkers starts the background jobs of the server
+func (s *Server) runWorkers() {
+	go func() {
+		ticker := time.NewTicker(workerInterval)
+		defer ticker.Stop()
+
+		for {
+			err := s.PurgeTrash()
+			if err != nil {
+				s.logger.Error("Failed to purge trash: With err: " + err.Error())
+			}
+			err = s.ApplyLifecycleRules()
+			if err != nil {
+				s.logger.Error("Failed to apply lifecycle rules: With err: " + err.Error())
+			}
+			err = s.ReconcileUsage()
+			if err != nil {
+				s.logger.Error("Failed to reconcile usage: With err: " + err.Error())
+			}
+			err = s.PurgeSessions()
+			if err != nil {
+				s.logger.Error("Failed to purge sessions: With err: " + err.Error())
+			}
+			<-ticker.C
+		}
+	}()
+}
@@ -0,0 +1,111 @@
+package auth
+
+import (
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"os"
+)
+
+// Key is a verification key of a KeySet, either an HMAC secret or an RSA public key
+type Key struct {
+	Id     string
+	Alg    string
+	Secret []byte
+	Public *rsa.PublicKey
+}
+
+// KeySet holds the keys tokens can be verified against
+type KeySet struct {
+	keys []Key
+}
+
+// jwk is a single key of a JWKS document
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Alg string `json:"alg"`
+	K   string `json:"k"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+}
+
+// LoadKeySet reads a JWKS file
+func LoadKeySet(path string) (*KeySet, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return ParseKeySet(data)
+}
+
+// ParseKeySet parses a JWKS document holding oct keys for HS256 and RSA keys for RS256
+func ParseKeySet(data []byte) (*KeySet, error) {
+	var doc struct {
+		Keys []jwk `json:"keys"`
+	}
+	err := json.Unmarshal(data, &doc)
+	if err != nil {
+		return nil, err
+	}
+
+	set := &KeySet{}
+	for _, k := range doc.Keys {
+		switch k.Kty {
+		case "oct":
+			secret, err := base64.RawURLEncoding.DecodeString(k.K)
+			if err != nil || len(secret) == 0 {
+				return nil, fmt.Errorf("invalid oct key %s", k.Kid)
+			}
+			set.keys = append(set.keys, Key{Id: k.Kid, Alg: "HS256", Secret: secret})
+		case "RSA":
+			n, err := base64.RawURLEncoding.DecodeString(k.N)
+			if err != nil {
+				return nil, fmt.Errorf("invalid RSA modulus of key %s", k.Kid)
+			}
+			e, err := base64.RawURLEncoding.DecodeString(k.E)
+			if err != nil || len(e) == 0 || len(e) > 4 {
+				return nil, fmt.Errorf("invalid RSA exponent of key %s", k.Kid)
+			}
+			pub := &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+			set.keys = append(set.keys, Key{Id: k.Kid, Alg: "RS256", Public: pub})
+		}
+	}
+	if len(set.keys) == 0 {
+		return nil, fmt.Errorf("no usable keys in key set")
+	}
+
+	return set, nil
+}
+
+// candidates gets the keys a token signed with alg and kid could have been signed with
+func (set *KeySet) candidates(alg, kid string) []Key {
+	keys := make([]Key, 0)
+	for _, k := range set.keys {
+		if k.Alg == alg && (kid == "" || k.Id == kid) {
+			keys = append(keys, k)
+		}
+	}
+	return keys
+}
+
+// MarshalPublicJWKS encodes RSA public keys as a JWKS document
+func MarshalPublicJWKS(keys map[string]*rsa.PublicKey) ([]byte, error) {
+	doc := struct {
+		Keys []jwk `json:"keys"`
+	}{Keys: make([]jwk, 0, len(keys))}
+	for kid, pub := range keys {
+		doc.Keys = append(doc.Keys, jwk{
+			Kty: "RSA",
+			Kid: kid,
+			Alg: "RS256",
+			N:   base64.RawURLEncoding.EncodeToString(pub.N.Bytes()),
+			E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes()),
+		})
+	}
+
+	return json.Marshal(doc)
+}
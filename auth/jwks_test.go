@@ -0,0 +1,71 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"testing"
+)
+
+func TestParseKeySet(t *testing.T) {
+	tests := []struct {
+		name string
+		doc  string
+		// algs are the algs of the parsed keys, in order
+		algs []string
+		ok   bool
+	}{
+		{"oct", `{"keys": [{"kty": "oct", "kid": "a", "k": "c2VjcmV0"}]}`, []string{"HS256"}, true},
+		{"rsa", `{"keys": [{"kty": "RSA", "kid": "a", "n": "sXch", "e": "AQAB"}]}`, []string{"RS256"}, true},
+		{"mixed", `{"keys": [{"kty": "oct", "kid": "a", "k": "c2VjcmV0"}, {"kty": "RSA", "kid": "b", "n": "sXch", "e": "AQAB"}]}`, []string{"HS256", "RS256"}, true},
+		{"unsupported kty skipped", `{"keys": [{"kty": "EC", "kid": "a"}, {"kty": "oct", "kid": "b", "k": "c2VjcmV0"}]}`, []string{"HS256"}, true},
+		{"only unsupported", `{"keys": [{"kty": "EC", "kid": "a"}]}`, nil, false},
+		{"empty", `{"keys": []}`, nil, false},
+		{"not json", `keys`, nil, false},
+		{"empty oct", `{"keys": [{"kty": "oct", "kid": "a", "k": ""}]}`, nil, false},
+		{"bad oct encoding", `{"keys": [{"kty": "oct", "kid": "a", "k": "c2Vj=cmV0"}]}`, nil, false},
+		{"bad modulus", `{"keys": [{"kty": "RSA", "kid": "a", "n": "***", "e": "AQAB"}]}`, nil, false},
+		{"empty exponent", `{"keys": [{"kty": "RSA", "kid": "a", "n": "sXch", "e": ""}]}`, nil, false},
+		{"exponent too large", `{"keys": [{"kty": "RSA", "kid": "a", "n": "sXch", "e": "AQABAQAB"}]}`, nil, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			set, err := ParseKeySet([]byte(tt.doc))
+			if (err == nil) != tt.ok {
+				t.Fatalf("ParseKeySet() err = %v, want ok %v", err, tt.ok)
+			}
+			if err != nil {
+				return
+			}
+			if len(set.keys) != len(tt.algs) {
+				t.Fatalf("ParseKeySet() got %d keys, want %d", len(set.keys), len(tt.algs))
+			}
+			for i, k := range set.keys {
+				if k.Alg != tt.algs[i] {
+					t.Errorf("key %d alg = %s, want %s", i, k.Alg, tt.algs[i])
+				}
+			}
+		})
+	}
+}
+
+func TestMarshalPublicJWKSRoundTrip(t *testing.T) {
+	priv, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	doc, err := MarshalPublicJWKS(map[string]*rsa.PublicKey{"k1": &priv.PublicKey})
+	if err != nil {
+		t.Fatal(err)
+	}
+	set, err := ParseKeySet(doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keys := set.candidates("RS256", "k1")
+	if len(keys) != 1 || !keys[0].Public.Equal(&priv.PublicKey) {
+		t.Fatalf("round trip got keys %v, want the public key k1", keys)
+	}
+}
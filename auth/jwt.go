@@ -0,0 +1,188 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+)
+
+var (
+	ErrMalformed = errors.New("malformed token")
+	ErrSignature = errors.New("invalid token signature")
+	ErrExpired   = errors.New("token is expired or not yet valid")
+	ErrClaims    = errors.New("token issuer or audience does not match")
+)
+
+// leeway is the clock skew allowed when checking the validity period of a token
+const leeway = time.Minute
+
+// Claims are the claims of a verified token
+type Claims map[string]any
+
+// String gets a string claim, being empty if it is missing or not a string
+func (c Claims) String(name string) string {
+	v, _ := c[name].(string)
+	return v
+}
+
+// Strings gets a claim that is a string or a list of strings
+func (c Claims) Strings(name string) []string {
+	switch v := c[name].(type) {
+	case string:
+		return []string{v}
+	case []any:
+		values := make([]string, 0, len(v))
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				values = append(values, s)
+			}
+		}
+		return values
+	}
+	return nil
+}
+
+// Time gets a numeric date claim
+func (c Claims) Time(name string) (time.Time, bool) {
+	v, ok := c[name].(float64)
+	if !ok {
+		return time.Time{}, false
+	}
+	return time.Unix(int64(v), 0), true
+}
+
+type header struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid,omitempty"`
+}
+
+// LooksLikeJWT checks if a token has the three dot separated parts of a JWT
+func LooksLikeJWT(token string) bool {
+	return strings.Count(token, ".") == 2
+}
+
+// Verify checks the signature of a JWT against the key set and its validity period,
+// and its issuer and audience when they are not empty, returning its claims
+func Verify(token string, keys *KeySet, issuer, audience string, now time.Time) (Claims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, ErrMalformed
+	}
+
+	var h header
+	err := decodeSegment(parts[0], &h)
+	if err != nil {
+		return nil, ErrMalformed
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, ErrMalformed
+	}
+
+	signed := []byte(parts[0] + "." + parts[1])
+	verified := false
+	for _, k := range keys.candidates(h.Alg, h.Kid) {
+		if verifySignature(k, signed, sig) {
+			verified = true
+			break
+		}
+	}
+	if !verified {
+		return nil, ErrSignature
+	}
+
+	var claims Claims
+	err = decodeSegment(parts[1], &claims)
+	if err != nil {
+		return nil, ErrMalformed
+	}
+
+	exp, ok := claims.Time("exp")
+	if !ok || !now.Before(exp.Add(leeway)) {
+		return nil, ErrExpired
+	}
+	if nbf, ok := claims.Time("nbf"); ok && now.Add(leeway).Before(nbf) {
+		return nil, ErrExpired
+	}
+	if issuer != "" && claims.String("iss") != issuer {
+		return nil, ErrClaims
+	}
+	if audience != "" && !slices.Contains(claims.Strings("aud"), audience) {
+		return nil, ErrClaims
+	}
+
+	return claims, nil
+}
+
+func verifySignature(k Key, signed, sig []byte) bool {
+	switch k.Alg {
+	case "HS256":
+		mac := hmac.New(sha256.New, k.Secret)
+		mac.Write(signed)
+		return hmac.Equal(mac.Sum(nil), sig)
+	case "RS256":
+		digest := sha256.Sum256(signed)
+		return rsa.VerifyPKCS1v15(k.Public, crypto.SHA256, digest[:], sig) == nil
+	}
+	return false
+}
+
+// SignHS256 creates a JWT of the claims signed with an HMAC secret
+func SignHS256(claims Claims, kid string, secret []byte) (string, error) {
+	signed, err := signingInput("HS256", kid, claims)
+	if err != nil {
+		return "", err
+	}
+
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(signed))
+	return signed + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil)), nil
+}
+
+// SignRS256 creates a JWT of the claims signed with an RSA private key
+func SignRS256(claims Claims, kid string, key *rsa.PrivateKey) (string, error) {
+	signed, err := signingInput("RS256", kid, claims)
+	if err != nil {
+		return "", err
+	}
+
+	digest := sha256.Sum256([]byte(signed))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	if err != nil {
+		return "", err
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sig), nil
+}
+
+func signingInput(alg, kid string, claims Claims) (string, error) {
+	h, err := json.Marshal(header{Alg: alg, Kid: kid})
+	if err != nil {
+		return "", err
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(h) + "." + base64.RawURLEncoding.EncodeToString(payload), nil
+}
+
+func decodeSegment(seg string, v any) error {
+	data, err := base64.RawURLEncoding.DecodeString(seg)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("invalid segment: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,120 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+)
+
+var testSecret = []byte("0123456789abcdef0123456789abcdef")
+
+// testKeySet builds a key set with an oct key hs and an RSA key rs
+func testKeySet(t *testing.T) (*KeySet, *rsa.PrivateKey) {
+	t.Helper()
+
+	priv, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	doc := fmt.Sprintf(`{"keys": [
+		{"kty": "oct", "kid": "hs", "k": %q},
+		{"kty": "RSA", "kid": "rs", "n": %q, "e": %q}
+	]}`, base64.RawURLEncoding.EncodeToString(testSecret),
+		base64.RawURLEncoding.EncodeToString(priv.N.Bytes()),
+		base64.RawURLEncoding.EncodeToString(big.NewInt(int64(priv.E)).Bytes()))
+
+	keys, err := ParseKeySet([]byte(doc))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return keys, priv
+}
+
+// unsigned builds a token with the alg none and no signature
+func unsigned(claims Claims) string {
+	h, _ := json.Marshal(header{Alg: "none"})
+	payload, _ := json.Marshal(claims)
+	return base64.RawURLEncoding.EncodeToString(h) + "." + base64.RawURLEncoding.EncodeToString(payload) + "."
+}
+
+func TestVerify(t *testing.T) {
+	keys, priv := testKeySet(t)
+	now := time.Unix(1_700_000_000, 0)
+
+	claims := func(edit func(Claims)) Claims {
+		c := Claims{"iss": "https://idp", "aud": "noob_store", "sub": "alice", "exp": float64(now.Add(time.Hour).Unix())}
+		if edit != nil {
+			edit(c)
+		}
+		return c
+	}
+	hs := func(c Claims, kid string) string {
+		token, err := SignHS256(c, kid, testSecret)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return token
+	}
+	rs := func(c Claims, kid string) string {
+		token, err := SignRS256(c, kid, priv)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return token
+	}
+	tampered := func(token string) string {
+		parts := strings.Split(token, ".")
+		payload, _ := json.Marshal(claims(func(c Claims) { c["sub"] = "mallory" }))
+		return parts[0] + "." + base64.RawURLEncoding.EncodeToString(payload) + "." + parts[2]
+	}
+
+	tests := []struct {
+		name     string
+		token    string
+		issuer   string
+		audience string
+		err      error
+	}{
+		{"hs256", hs(claims(nil), "hs"), "https://idp", "noob_store", nil},
+		{"rs256", rs(claims(nil), "rs"), "https://idp", "noob_store", nil},
+		{"no kid", hs(claims(nil), ""), "", "", nil},
+		{"unknown kid", hs(claims(nil), "other"), "", "", ErrSignature},
+		{"kid of another alg", hs(claims(nil), "rs"), "", "", ErrSignature},
+		{"rsa key as hmac secret", func() string {
+			token, _ := SignHS256(claims(nil), "rs", priv.N.Bytes())
+			return token
+		}(), "", "", ErrSignature},
+		{"alg none", unsigned(claims(nil)), "", "", ErrSignature},
+		{"tampered payload", tampered(hs(claims(nil), "hs")), "", "", ErrSignature},
+		{"malformed", "not.a-token", "", "", ErrMalformed},
+		{"two parts", "abc.def", "", "", ErrMalformed},
+		{"no exp", hs(claims(func(c Claims) { delete(c, "exp") }), "hs"), "", "", ErrExpired},
+		{"expired", hs(claims(func(c Claims) { c["exp"] = float64(now.Add(-2 * time.Minute).Unix()) }), "hs"), "", "", ErrExpired},
+		{"expired within leeway", hs(claims(func(c Claims) { c["exp"] = float64(now.Add(-30 * time.Second).Unix()) }), "hs"), "", "", nil},
+		{"not yet valid", hs(claims(func(c Claims) { c["nbf"] = float64(now.Add(2 * time.Minute).Unix()) }), "hs"), "", "", ErrExpired},
+		{"nbf within leeway", hs(claims(func(c Claims) { c["nbf"] = float64(now.Add(30 * time.Second).Unix()) }), "hs"), "", "", nil},
+		{"wrong issuer", hs(claims(nil), "hs"), "https://other", "", ErrClaims},
+		{"wrong audience", hs(claims(nil), "hs"), "", "other", ErrClaims},
+		{"audience in list", hs(claims(func(c Claims) { c["aud"] = []any{"other", "noob_store"} }), "hs"), "", "noob_store", nil},
+		{"audience not in list", hs(claims(func(c Claims) { c["aud"] = []any{"other"} }), "hs"), "", "noob_store", ErrClaims},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Verify(tt.token, keys, tt.issuer, tt.audience, now)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("Verify() err = %v, want %v", err, tt.err)
+			}
+			if err == nil && got.String("sub") != "alice" {
+				t.Errorf("Verify() sub = %q, want alice", got.String("sub"))
+			}
+		})
+	}
+}
@@ -0,0 +1,134 @@
+package auth
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// OIDCClient logs users in through the authorization code flow of an OpenID Connect issuer
+type OIDCClient struct {
+	Issuer       string
+	ClientId     string
+	ClientSecret string
+	RedirectURL  string
+
+	authEndpoint  string
+	tokenEndpoint string
+	keys          *KeySet
+	http          *http.Client
+}
+
+// Discover reads the endpoints and keys of an issuer from its discovery document
+func Discover(issuer, clientId, clientSecret, redirectURL string) (*OIDCClient, error) {
+	client := &OIDCClient{
+		Issuer:       strings.TrimSuffix(issuer, "/"),
+		ClientId:     clientId,
+		ClientSecret: clientSecret,
+		RedirectURL:  redirectURL,
+		http:         &http.Client{Timeout: 10 * time.Second},
+	}
+
+	var doc struct {
+		Issuer        string `json:"issuer"`
+		AuthEndpoint  string `json:"authorization_endpoint"`
+		TokenEndpoint string `json:"token_endpoint"`
+		JwksURI       string `json:"jwks_uri"`
+	}
+	err := client.getJSON(client.Issuer+"/.well-known/openid-configuration", &doc)
+	if err != nil {
+		return nil, err
+	}
+	if strings.TrimSuffix(doc.Issuer, "/") != client.Issuer {
+		return nil, fmt.Errorf("discovery document is for issuer %s", doc.Issuer)
+	}
+	client.authEndpoint = doc.AuthEndpoint
+	client.tokenEndpoint = doc.TokenEndpoint
+
+	res, err := client.http.Get(doc.JwksURI)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	data, err := io.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
+	client.keys, err = ParseKeySet(data)
+	if err != nil {
+		return nil, err
+	}
+
+	return client, nil
+}
+
+// AuthURL gets the url of the issuer to send the user to for logging in
+func (o *OIDCClient) AuthURL(state, nonce string) string {
+	q := url.Values{}
+	q.Set("response_type", "code")
+	q.Set("client_id", o.ClientId)
+	q.Set("redirect_uri", o.RedirectURL)
+	q.Set("scope", "openid email profile")
+	q.Set("state", state)
+	q.Set("nonce", nonce)
+
+	sep := "?"
+	if strings.Contains(o.authEndpoint, "?") {
+		sep = "&"
+	}
+	return o.authEndpoint + sep + q.Encode()
+}
+
+// Exchange trades the code the issuer redirected back with for the claims of a verified id token
+func (o *OIDCClient) Exchange(code, nonce string) (Claims, error) {
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", o.RedirectURL)
+	form.Set("client_id", o.ClientId)
+	form.Set("client_secret", o.ClientSecret)
+
+	res, err := o.http.PostForm(o.tokenEndpoint, form)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("token endpoint returned %s", res.Status)
+	}
+
+	var tokens struct {
+		IdToken string `json:"id_token"`
+	}
+	err = json.NewDecoder(res.Body).Decode(&tokens)
+	if err != nil {
+		return nil, err
+	}
+
+	claims, err := Verify(tokens.IdToken, o.keys, o.Issuer, o.ClientId, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	if claims.String("nonce") != nonce {
+		return nil, fmt.Errorf("id token nonce does not match")
+	}
+
+	return claims, nil
+}
+
+func (o *OIDCClient) getJSON(u string, v any) error {
+	res, err := o.http.Get(u)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s returned %s", u, res.Status)
+	}
+
+	return json.NewDecoder(res.Body).Decode(v)
+}
@@ -0,0 +1,81 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	// totpPeriod is the number of seconds each code is valid for
+	totpPeriod = 30
+	totpDigits = 6
+	// totpSkew is the number of periods before and after the current one a code is accepted from
+	totpSkew = 1
+)
+
+var totpEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// GenerateTOTPSecret creates a random base32 encoded secret for an authenticator app
+func GenerateTOTPSecret() string {
+	b := make([]byte, 20)
+	_, _ = rand.Read(b)
+	return totpEncoding.EncodeToString(b)
+}
+
+// TOTPURI gets the otpauth uri authenticator apps enrol a secret from, usually shown as a QR code
+func TOTPURI(issuer, account, secret string) string {
+	q := url.Values{}
+	q.Set("secret", secret)
+	q.Set("issuer", issuer)
+	q.Set("algorithm", "SHA1")
+	q.Set("digits", fmt.Sprint(totpDigits))
+	q.Set("period", fmt.Sprint(totpPeriod))
+
+	label := url.PathEscape(issuer + ":" + account)
+	return "otpauth://totp/" + label + "?" + q.Encode()
+}
+
+// TOTPCode computes the code of a secret for a time step counter as in RFC 6238
+func TOTPCode(secret string, counter int64) (string, error) {
+	key, err := totpEncoding.DecodeString(strings.ToUpper(secret))
+	if err != nil {
+		return "", err
+	}
+
+	msg := make([]byte, 8)
+	binary.BigEndian.PutUint64(msg, uint64(counter))
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg)
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	n := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	return fmt.Sprintf("%0*d", totpDigits, n%1000000), nil
+}
+
+// ValidateTOTP checks a code against a secret around the current time, returning the counter it matched.
+// Codes for counters up to last are rejected so each code can only be used once.
+func ValidateTOTP(secret, code string, now time.Time, last int64) (int64, bool) {
+	current := now.Unix() / totpPeriod
+	for counter := current - totpSkew; counter <= current+totpSkew; counter++ {
+		if counter <= last {
+			continue
+		}
+		expected, err := TOTPCode(secret, counter)
+		if err != nil {
+			return 0, false
+		}
+		if hmac.Equal([]byte(expected), []byte(code)) {
+			return counter, true
+		}
+	}
+
+	return 0, false
+}
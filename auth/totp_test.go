@@ -0,0 +1,96 @@
+package auth
+
+import (
+	"testing"
+	"time"
+)
+
+// rfcSecret is the SHA1 seed of the RFC 6238 test vectors, "12345678901234567890" in base32
+const rfcSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestTOTPCode(t *testing.T) {
+	// The RFC vectors have 8 digits, of which the 6 digit codes are the last 6
+	tests := []struct {
+		unix int64
+		code string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+		{20000000000, "353130"},
+	}
+
+	for _, tt := range tests {
+		got, err := TOTPCode(rfcSecret, tt.unix/totpPeriod)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != tt.code {
+			t.Errorf("TOTPCode() at %d = %s, want %s", tt.unix, got, tt.code)
+		}
+	}
+
+	lower, err := TOTPCode("gezdgnbvgy3tqojqgezdgnbvgy3tqojq", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	upper, _ := TOTPCode(rfcSecret, 1)
+	if lower != upper {
+		t.Errorf("TOTPCode() of a lower case secret = %s, want %s", lower, upper)
+	}
+
+	if _, err := TOTPCode("not base32!", 1); err == nil {
+		t.Error("TOTPCode() of an invalid secret did not fail")
+	}
+}
+
+func TestValidateTOTP(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+	current := now.Unix() / totpPeriod
+	code := func(counter int64) string {
+		c, err := TOTPCode(rfcSecret, counter)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return c
+	}
+
+	tests := []struct {
+		name    string
+		code    string
+		last    int64
+		counter int64
+		ok      bool
+	}{
+		{"current", code(current), 0, current, true},
+		{"previous period", code(current - 1), 0, current - 1, true},
+		{"next period", code(current + 1), 0, current + 1, true},
+		{"too old", code(current - 2), 0, 0, false},
+		{"too new", code(current + 2), 0, 0, false},
+		{"replayed", code(current), current, 0, false},
+		{"older than last used", code(current - 1), current, 0, false},
+		{"newer than last used", code(current + 1), current, current + 1, true},
+		{"wrong code", "000000", 0, 0, false},
+		{"empty code", "", 0, 0, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			counter, ok := ValidateTOTP(rfcSecret, tt.code, now, tt.last)
+			if ok != tt.ok || counter != tt.counter {
+				t.Errorf("ValidateTOTP() = %d, %v, want %d, %v", counter, ok, tt.counter, tt.ok)
+			}
+		})
+	}
+
+	// Using the counter a code matched as the last one rejects the same code from then on
+	counter, ok := ValidateTOTP(rfcSecret, code(current), now, 0)
+	if !ok {
+		t.Fatal("ValidateTOTP() rejected the current code")
+	}
+	if _, ok := ValidateTOTP(rfcSecret, code(current), now.Add(10*time.Second), counter); ok {
+		t.Error("ValidateTOTP() accepted a code twice")
+	}
+}
@@ -24,7 +24,7 @@ func (c *Cache) InsertBlob(blob types.Blob) error {
 func (c *Cache) GetBlob(blobId string) (types.Blob, error) {
 	var blob types.Blob
 
-	blob_encoded, err := c.r.Get(c.ctx, blobId).Result()
+	blob_encoded, err := c.get(blobId)
 	if err != nil {
 		return types.Blob{}, err
 	}
@@ -2,7 +2,9 @@ package cache
 
 import (
 	"context"
+	"errors"
 
+	"github.com/newtoallofthis123/noob_store/types"
 	"github.com/redis/go-redis/v9"
 )
 
@@ -26,3 +28,12 @@ func NewCache(connString string) (Cache, error) {
 		ctx: context.Background(),
 	}, nil
 }
+
+// get gets the value at key, a miss being a types.ErrNotFound
+func (c *Cache) get(key string) (string, error) {
+	val, err := c.r.Get(c.ctx, key).Result()
+	if errors.Is(err, redis.Nil) {
+		return "", types.Errorf(types.ErrNotFound, "cache miss for %s", key)
+	}
+	return val, err
+}
@@ -24,7 +24,7 @@ func (c *Cache) InsertMetadata(meta types.Metadata) error {
 func (c *Cache) GetMetadata(metaId string) (types.Metadata, error) {
 	var meta types.Metadata
 
-	meta_encoded, err := c.r.Get(c.ctx, metaId).Result()
+	meta_encoded, err := c.get(metaId)
 	if err != nil {
 		return types.Metadata{}, err
 	}
@@ -36,3 +36,7 @@ func (c *Cache) GetMetadata(metaId string) (types.Metadata, error) {
 
 	return meta, nil
 }
+
+func (c *Cache) DeleteMetadata(metaId string) error {
+	return c.r.Del(c.ctx, metaId).Err()
+}
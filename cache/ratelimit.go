@@ -0,0 +1,98 @@
+package cache
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// takeScript refills the token bucket at KEYS[1] for the time passed and takes tokens from it,
+// returning the seconds to wait until they are available. Without reserve nothing is taken
+// while there is a wait, with it the bucket goes into debt that later callers wait out.
+var takeScript = redis.NewScript(`
+local rate = tonumber(ARGV[1])
+local burst = tonumber(ARGV[2])
+local n = tonumber(ARGV[3])
+local now = tonumber(ARGV[4])
+local reserve = ARGV[5] == "1"
+
+local state = redis.call("HMGET", KEYS[1], "tokens", "ts")
+local tokens = tonumber(state[1]) or burst
+local ts = tonumber(state[2]) or now
+tokens = math.min(burst, tokens + math.max(0, now - ts) * rate)
+
+local wait = 0
+if tokens < n then
+	wait = (n - tokens) / rate
+end
+if wait == 0 or reserve then
+	tokens = tokens - n
+end
+
+redis.call("HSET", KEYS[1], "tokens", tostring(tokens), "ts", tostring(now))
+redis.call("EXPIRE", KEYS[1], math.ceil((burst - tokens) / rate) + 1)
+return tostring(wait)
+`)
+
+func (c *Cache) take(key string, n, rate, burst float64, reserve bool) (time.Duration, error) {
+	now := float64(time.Now().UnixMicro()) / 1e6
+	flag := "0"
+	if reserve {
+		flag = "1"
+	}
+
+	res, err := takeScript.Run(c.ctx, c.r, []string{"rate:" + key}, rate, burst, n, now, flag).Text()
+	if err != nil {
+		return 0, err
+	}
+	wait, err := strconv.ParseFloat(res, 64)
+	if err != nil {
+		return 0, err
+	}
+
+	return time.Duration(wait * float64(time.Second)), nil
+}
+
+// TakeTokens takes n tokens from the bucket at key, refilled at rate tokens a second up to burst.
+// When there are not enough it takes none and returns how long until there will be.
+func (c *Cache) TakeTokens(key string, n, rate, burst float64) (time.Duration, error) {
+	return c.take(key, n, rate, burst, false)
+}
+
+// ReserveTokens takes n tokens from the bucket at key even when it runs dry,
+// returning how long the caller has to wait to stay within the rate.
+func (c *Cache) ReserveTokens(key string, n, rate, burst float64) (time.Duration, error) {
+	return c.take(key, n, rate, burst, true)
+}
+
+// AddFailure counts a failed attempt at key, returning the failures within window of the first one
+func (c *Cache) AddFailure(key string, window time.Duration) (int64, error) {
+	n, err := c.r.Incr(c.ctx, "failures:"+key).Result()
+	if err != nil {
+		return 0, err
+	}
+	if n == 1 {
+		err = c.r.Expire(c.ctx, "failures:"+key, window).Err()
+	}
+	return n, err
+}
+
+// ClearFailures forgets the failed attempts and any lock at key
+func (c *Cache) ClearFailures(key string) error {
+	return c.r.Del(c.ctx, "failures:"+key, "lock:"+key).Err()
+}
+
+// Lock locks key for d
+func (c *Cache) Lock(key string, d time.Duration) error {
+	return c.r.Set(c.ctx, "lock:"+key, "1", d).Err()
+}
+
+// LockedFor returns how much longer key is locked, or 0 if it is not
+func (c *Cache) LockedFor(key string) (time.Duration, error) {
+	ttl, err := c.r.PTTL(c.ctx, "lock:"+key).Result()
+	if err != nil || ttl < 0 {
+		return 0, err
+	}
+	return ttl, nil
+}
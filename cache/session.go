@@ -7,20 +7,21 @@ import (
 	"github.com/newtoallofthis123/noob_store/types"
 )
 
-func (c *Cache) InsertSession(session types.Session) error {
+// InsertSession caches a session for ttl, which should not outlive the session itself
+func (c *Cache) InsertSession(session types.Session, ttl time.Duration) error {
 	session_encoded, err := json.Marshal(session)
 	if err != nil {
 		return err
 	}
 
-	err = c.r.Set(c.ctx, session.Id, string(session_encoded), time.Hour*24*7).Err()
+	err = c.r.Set(c.ctx, session.Id, string(session_encoded), ttl).Err()
 	return err
 }
 
 func (c *Cache) GetSession(sessionId string) (types.Session, error) {
 	var session types.Session
 
-	session_encoded, err := c.r.Get(c.ctx, sessionId).Result()
+	session_encoded, err := c.get(sessionId)
 	if err != nil {
 		return types.Session{}, err
 	}
@@ -32,3 +33,26 @@ func (c *Cache) GetSession(sessionId string) (types.Session, error) {
 
 	return session, nil
 }
+
+func (c *Cache) DeleteSession(sessionId string) error {
+	return c.r.Del(c.ctx, sessionId).Err()
+}
+
+// TrackSession remembers a cached session in the set of a user, so that DeleteTrackedSessions
+// can drop them all when their ids can not be known in advance
+func (c *Cache) TrackSession(set, sessionId string, ttl time.Duration) error {
+	err := c.r.SAdd(c.ctx, set, sessionId).Err()
+	if err != nil {
+		return err
+	}
+	return c.r.Expire(c.ctx, set, ttl).Err()
+}
+
+// DeleteTrackedSessions deletes the cached sessions in a set along with the set
+func (c *Cache) DeleteTrackedSessions(set string) error {
+	ids, err := c.r.SMembers(c.ctx, set).Result()
+	if err != nil {
+		return err
+	}
+	return c.r.Del(c.ctx, append(ids, set)...).Err()
+}
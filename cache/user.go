@@ -22,7 +22,7 @@ func (c *Cache) InsertUser(user types.User) error {
 
 func (c *Cache) GetUser(userId string) (types.User, error) {
 	var user types.User
-	user_encoded, err := c.r.Get(c.ctx, userId).Result()
+	user_encoded, err := c.get(userId)
 	if err != nil {
 		return types.User{}, err
 	}
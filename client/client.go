@@ -0,0 +1,303 @@
+// Package client is a Go client of the noob_store http api, described in full by /openapi.json
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/newtoallofthis123/noob_store/types"
+)
+
+// Client calls a noob_store server
+type Client struct {
+	BaseURL string
+	// Token is the session id, api key or JWT sent with every request. Logging in sets it.
+	Token string
+	HTTP  *http.Client
+}
+
+// New returns a client of the server at baseURL, like http://localhost:6969
+func New(baseURL string) *Client {
+	return &Client{BaseURL: strings.TrimSuffix(baseURL, "/"), HTTP: http.DefaultClient}
+}
+
+// Error is an error answered by the server
+type Error struct {
+	Status int
+	types.ErrorRes
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("noob_store: %d %s: %s (request %s)", e.Status, e.Code, e.Err, e.RequestId)
+}
+
+// TwoFactorRequired is returned by Login for accounts with two factor login on,
+// its challenge being passed on to LoginTwoFactor along with a code
+type TwoFactorRequired struct {
+	Challenge string
+}
+
+func (e *TwoFactorRequired) Error() string {
+	return "noob_store: two factor login required"
+}
+
+// do sends a request, turning error responses into an *Error
+func (c *Client) do(ctx context.Context, method, path string, query url.Values, body io.Reader, contentType string) (*http.Response, error) {
+	u := c.BaseURL + path
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u, body)
+	if err != nil {
+		return nil, err
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	if c.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.Token)
+	}
+
+	res, err := c.HTTP.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode >= 400 {
+		defer res.Body.Close()
+		apiErr := &Error{Status: res.StatusCode}
+		if json.NewDecoder(res.Body).Decode(&apiErr.ErrorRes) != nil {
+			apiErr.Err = res.Status
+		}
+		return nil, apiErr
+	}
+
+	return res, nil
+}
+
+// call sends a request with an optional form and decodes the json response into out
+func (c *Client) call(ctx context.Context, method, path string, query, form url.Values, out any) error {
+	var body io.Reader
+	contentType := ""
+	if form != nil {
+		body = strings.NewReader(form.Encode())
+		contentType = "application/x-www-form-urlencoded"
+	}
+
+	res, err := c.do(ctx, method, path, query, body, contentType)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if out == nil {
+		_, err = io.Copy(io.Discard, res.Body)
+		return err
+	}
+	return json.NewDecoder(res.Body).Decode(out)
+}
+
+// CreateUser signs up and logs in as the new user
+func (c *Client) CreateUser(ctx context.Context, email, password string) (types.Session, error) {
+	var session types.Session
+	err := c.call(ctx, "POST", "/user/create", nil, url.Values{"email": {email}, "password": {password}}, &session)
+	if err != nil {
+		return types.Session{}, err
+	}
+
+	c.Token = session.Id
+	return session, nil
+}
+
+// Login logs in with an email and password, returning a *TwoFactorRequired for accounts with two factor login on
+func (c *Client) Login(ctx context.Context, email, password string) (types.Session, error) {
+	var res struct {
+		types.Session
+		TwoFactor bool   `json:"two_factor"`
+		Challenge string `json:"challenge"`
+	}
+	err := c.call(ctx, "POST", "/user/login", nil, url.Values{"email": {email}, "password": {password}}, &res)
+	if err != nil {
+		return types.Session{}, err
+	}
+	if res.TwoFactor {
+		return types.Session{}, &TwoFactorRequired{Challenge: res.Challenge}
+	}
+
+	c.Token = res.Id
+	return res.Session, nil
+}
+
+// LoginTwoFactor finishes logging in with the challenge from Login and a totp code
+func (c *Client) LoginTwoFactor(ctx context.Context, challenge, code string) (types.Session, error) {
+	var session types.Session
+	err := c.call(ctx, "POST", "/user/login/2fa", nil, url.Values{"challenge": {challenge}, "code": {code}}, &session)
+	if err != nil {
+		return types.Session{}, err
+	}
+
+	c.Token = session.Id
+	return session, nil
+}
+
+// Logout revokes the session of the client
+func (c *Client) Logout(ctx context.Context) error {
+	err := c.call(ctx, "POST", "/user/logout", nil, nil, nil)
+	if err == nil {
+		c.Token = ""
+	}
+	return err
+}
+
+// UploadOptions are the optional settings of an upload
+type UploadOptions struct {
+	// Group stores the file for a group the user is a member of
+	Group    string
+	UserMeta map[string]string
+	Tags     map[string]string
+}
+
+// Upload streams content to a new file at path
+func (c *Client) Upload(ctx context.Context, path string, content io.Reader, opts *UploadOptions) (types.Metadata, error) {
+	if opts == nil {
+		opts = &UploadOptions{}
+	}
+
+	pr, pw := io.Pipe()
+	mw := multipart.NewWriter(pw)
+	go func() {
+		fields := map[string]string{"path": path}
+		if opts.Group != "" {
+			fields["group"] = opts.Group
+		}
+		for k, v := range opts.UserMeta {
+			fields["meta-"+k] = v
+		}
+		for k, v := range opts.Tags {
+			fields["tag-"+k] = v
+		}
+		for k, v := range fields {
+			if err := mw.WriteField(k, v); err != nil {
+				pw.CloseWithError(err)
+				return
+			}
+		}
+
+		part, err := mw.CreateFormFile("content", path)
+		if err == nil {
+			_, err = io.Copy(part, content)
+		}
+		if err == nil {
+			err = mw.Close()
+		}
+		pw.CloseWithError(err)
+	}()
+
+	res, err := c.do(ctx, "POST", "/add", nil, pr, mw.FormDataContentType())
+	// Stops the writer when the request ended before reading all of it
+	pr.Close()
+	if err != nil {
+		return types.Metadata{}, err
+	}
+	defer res.Body.Close()
+
+	var meta types.Metadata
+	err = json.NewDecoder(res.Body).Decode(&meta)
+	return meta, err
+}
+
+// Download returns the content of a file, which the caller has to close
+func (c *Client) Download(ctx context.Context, id string) (io.ReadCloser, error) {
+	res, err := c.do(ctx, "GET", "/file/"+url.PathEscape(id), nil, nil, "")
+	if err != nil {
+		return nil, err
+	}
+	return res.Body, nil
+}
+
+// Info returns the metadata of a file
+func (c *Client) Info(ctx context.Context, id string) (types.Metadata, error) {
+	var meta types.Metadata
+	err := c.call(ctx, "GET", "/info/"+url.PathEscape(id), nil, nil, &meta)
+	return meta, err
+}
+
+// ListOptions are the pagination, sorting and filters of List, the zero value listing everything by name
+type ListOptions struct {
+	Limit int
+	// Cursor is the NextCursor of the previous page
+	Cursor    string
+	Sort      string
+	Desc      bool
+	Dir       string
+	Prefix    string
+	Delimiter string
+	Mime      string
+	Tags      map[string]string
+	Shared    bool
+}
+
+func (o ListOptions) values() url.Values {
+	q := url.Values{}
+	set := func(k, v string) {
+		if v != "" {
+			q.Set(k, v)
+		}
+	}
+	if o.Limit > 0 {
+		q.Set("limit", strconv.Itoa(o.Limit))
+	}
+	set("cursor", o.Cursor)
+	set("sort", o.Sort)
+	if o.Desc {
+		q.Set("order", "desc")
+	}
+	set("dir", o.Dir)
+	set("prefix", o.Prefix)
+	set("delimiter", o.Delimiter)
+	set("mime", o.Mime)
+	for k, v := range o.Tags {
+		q.Add("tag", k+"="+v)
+	}
+	if o.Shared {
+		q.Set("shared", "true")
+	}
+	return q
+}
+
+// List returns a page of the files of the user
+func (c *Client) List(ctx context.Context, opts ListOptions) (types.ListRes, error) {
+	var res types.ListRes
+	err := c.call(ctx, "GET", "/user/ls", opts.values(), nil, &res)
+	return res, err
+}
+
+// Delete moves a file to the trash
+func (c *Client) Delete(ctx context.Context, id string) error {
+	return c.call(ctx, "DELETE", "/delete/"+url.PathEscape(id), nil, nil, nil)
+}
+
+// Mkdir creates a dir along with its parents
+func (c *Client) Mkdir(ctx context.Context, path string) (types.Dir, error) {
+	var dir types.Dir
+	err := c.call(ctx, "POST", "/user/mkdir", nil, url.Values{"path": {path}}, &dir)
+	return dir, err
+}
+
+// Rmdir removes a dir, which has to be empty unless recursive is set, moving its files to the trash
+func (c *Client) Rmdir(ctx context.Context, path string, recursive bool) error {
+	return c.call(ctx, "DELETE", "/user/rmdir", url.Values{"path": {path}, "recursive": {strconv.FormatBool(recursive)}}, nil, nil)
+}
+
+// Move moves or renames a file or dir, trashing files in the way if overwrite is set
+func (c *Client) Move(ctx context.Context, src, dst string, overwrite bool) ([]types.Metadata, error) {
+	var moved []types.Metadata
+	err := c.call(ctx, "POST", "/move", nil, url.Values{"src": {src}, "dst": {dst}, "overwrite": {strconv.FormatBool(overwrite)}}, &moved)
+	return moved, err
+}
@@ -7,6 +7,7 @@ import (
 	"os"
 
 	"github.com/newtoallofthis123/noob_store/api"
+	"github.com/newtoallofthis123/noob_store/rpc"
 	"github.com/newtoallofthis123/noob_store/utils"
 )
 
@@ -20,5 +21,13 @@ func main() {
 	env.ListenAddr = fmt.Sprintf(":%d", port)
 
 	server := api.NewServer(&env, logger)
+	if env.GrpcAddr != "" {
+		go func() {
+			err := rpc.NewServer(server, logger).Serve(env.GrpcAddr)
+			if err != nil {
+				logger.Error("Closing gRPC server with err: " + err.Error())
+			}
+		}()
+	}
 	server.Start()
 }
@@ -0,0 +1,207 @@
+// mockoidc is a local OpenID Connect issuer for trying out and testing the JWT and OIDC logins of noob_store.
+// It approves every login without asking, as the user given by the login_hint or the -email flag.
+package main
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/newtoallofthis123/noob_store/auth"
+)
+
+const kid = "mock"
+
+type login struct {
+	clientId string
+	nonce    string
+	email    string
+}
+
+type issuer struct {
+	url      string
+	audience string
+	roles    []string
+	groups   []string
+	key      *rsa.PrivateKey
+	mu       sync.Mutex
+	codes    map[string]login
+}
+
+func main() {
+	var port int
+	var email, roles, groups, audience, jwksOut string
+	flag.IntVar(&port, "port", 9000, "Port to serve")
+	flag.StringVar(&email, "email", "dev@example.com", "Email of the user logging in without a login_hint")
+	flag.StringVar(&roles, "roles", "", "Comma separated roles claim of the tokens")
+	flag.StringVar(&groups, "groups", "", "Comma separated groups claim of the tokens")
+	flag.StringVar(&audience, "audience", "noob_store", "Audience of the tokens minted at /mint")
+	flag.StringVar(&jwksOut, "jwks-out", "", "File to write the public JWKS to, for JWT_JWKS_FILE")
+	flag.Parse()
+	logger := slog.New(slog.NewTextHandler(os.Stderr, nil))
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		panic(err)
+	}
+
+	iss := &issuer{
+		url:      fmt.Sprintf("http://localhost:%d", port),
+		audience: audience,
+		roles:    splitList(roles),
+		groups:   splitList(groups),
+		key:      key,
+		codes:    make(map[string]login),
+	}
+
+	if jwksOut != "" {
+		jwks, err := auth.MarshalPublicJWKS(map[string]*rsa.PublicKey{kid: &key.PublicKey})
+		if err != nil {
+			panic(err)
+		}
+		err = os.WriteFile(jwksOut, jwks, 0o644)
+		if err != nil {
+			panic(err)
+		}
+	}
+
+	http.HandleFunc("/.well-known/openid-configuration", iss.handleDiscovery)
+	http.HandleFunc("/jwks", iss.handleJwks)
+	http.HandleFunc("/authorize", func(w http.ResponseWriter, r *http.Request) { iss.handleAuthorize(w, r, email) })
+	http.HandleFunc("/token", iss.handleToken)
+	http.HandleFunc("/mint", func(w http.ResponseWriter, r *http.Request) { iss.handleMint(w, r, email) })
+
+	logger.Info("Serving mock issuer at " + iss.url)
+	err = http.ListenAndServe(fmt.Sprintf(":%d", port), nil)
+	if err != nil {
+		logger.Error("Closing mock issuer with err: " + err.Error())
+	}
+}
+
+func (iss *issuer) handleDiscovery(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, map[string]string{
+		"issuer":                 iss.url,
+		"authorization_endpoint": iss.url + "/authorize",
+		"token_endpoint":         iss.url + "/token",
+		"jwks_uri":               iss.url + "/jwks",
+	})
+}
+
+func (iss *issuer) handleJwks(w http.ResponseWriter, r *http.Request) {
+	jwks, err := auth.MarshalPublicJWKS(map[string]*rsa.PublicKey{kid: &iss.key.PublicKey})
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(jwks)
+}
+
+// handleAuthorize approves the login right away and redirects back with a code
+func (iss *issuer) handleAuthorize(w http.ResponseWriter, r *http.Request, email string) {
+	q := r.URL.Query()
+	redirect, err := url.Parse(q.Get("redirect_uri"))
+	if err != nil || redirect.Scheme == "" {
+		http.Error(w, "redirect_uri is needed", 400)
+		return
+	}
+	if hint := q.Get("login_hint"); hint != "" {
+		email = hint
+	}
+
+	code := randomHex(16)
+	iss.mu.Lock()
+	iss.codes[code] = login{clientId: q.Get("client_id"), nonce: q.Get("nonce"), email: email}
+	iss.mu.Unlock()
+
+	back := redirect.Query()
+	back.Set("code", code)
+	back.Set("state", q.Get("state"))
+	redirect.RawQuery = back.Encode()
+	http.Redirect(w, r, redirect.String(), http.StatusFound)
+}
+
+// handleToken exchanges a code for an id token, once
+func (iss *issuer) handleToken(w http.ResponseWriter, r *http.Request) {
+	code := r.PostFormValue("code")
+	iss.mu.Lock()
+	l, ok := iss.codes[code]
+	delete(iss.codes, code)
+	iss.mu.Unlock()
+	if !ok || l.clientId != r.PostFormValue("client_id") {
+		http.Error(w, "invalid code", 400)
+		return
+	}
+
+	token, err := iss.sign(l.email, l.clientId, l.nonce)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	writeJSON(w, map[string]string{"id_token": token, "token_type": "Bearer"})
+}
+
+// handleMint returns a bearer token for the audience, to call noob_store with directly
+func (iss *issuer) handleMint(w http.ResponseWriter, r *http.Request, email string) {
+	if e := r.URL.Query().Get("email"); e != "" {
+		email = e
+	}
+
+	token, err := iss.sign(email, iss.audience, "")
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	writeJSON(w, map[string]string{"token": token})
+}
+
+func (iss *issuer) sign(email, audience, nonce string) (string, error) {
+	now := time.Now()
+	claims := auth.Claims{
+		"iss":   iss.url,
+		"sub":   "mock|" + email,
+		"aud":   audience,
+		"email": email,
+		"iat":   now.Unix(),
+		"exp":   now.Add(time.Hour).Unix(),
+	}
+	if nonce != "" {
+		claims["nonce"] = nonce
+	}
+	if len(iss.roles) > 0 {
+		claims["roles"] = iss.roles
+	}
+	if len(iss.groups) > 0 {
+		claims["groups"] = iss.groups
+	}
+
+	return auth.SignRS256(claims, kid, iss.key)
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+func splitList(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return strings.Split(s, ",")
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
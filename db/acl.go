@@ -0,0 +1,102 @@
+package db
+
+import (
+	"database/sql"
+
+	"github.com/Masterminds/squirrel"
+	"github.com/newtoallofthis123/noob_store/types"
+)
+
+var grantColumns = []string{"id", "owner_id", "path", "grantee_id", "permission", "created_at"}
+
+func scanGrant(row squirrel.RowScanner) (types.Grant, error) {
+	var grant types.Grant
+
+	err := row.Scan(&grant.Id, &grant.OwnerId, &grant.Path, &grant.GranteeId, &grant.Permission, &grant.CreatedAt)
+	if err != nil {
+		return types.Grant{}, err
+	}
+
+	return grant, nil
+}
+
+func scanGrants(rows *sql.Rows) ([]types.Grant, error) {
+	defer rows.Close()
+	grants := make([]types.Grant, 0)
+
+	for rows.Next() {
+		grant, err := scanGrant(rows)
+		if err != nil {
+			return nil, err
+		}
+		grants = append(grants, grant)
+	}
+
+	return grants, nil
+}
+
+// grantCovers is the condition of a grant in acls a covering the metadata m, being on its path,
+// on a dir above it or on the root of the owner's tree
+const grantCovers = "a.owner_id = m.user_id AND (a.path IN ('.', '/') OR m.path = a.path OR m.path LIKE replace(replace(replace(a.path, '\\', '\\\\'), '%', '\\%'), '_', '\\_') || '/%')"
+
+// granteeOf is the condition of a grant in acls a being given to the user or to a group they are a member of
+const granteeOf = "(a.grantee_id = ? OR a.grantee_id IN (SELECT group_id FROM group_members WHERE user_id = ?))"
+
+// sharedWith is the condition of the metadata m being shared with the grantee
+func sharedWith(granteeId string) squirrel.Sqlizer {
+	return squirrel.Expr("EXISTS (SELECT 1 FROM acls a WHERE "+granteeOf+" AND "+grantCovers+")", granteeId, granteeId)
+}
+
+// CreateGrant inserts a grant, replacing the permission of an existing grant for the same path and grantee
+func (db *Store) CreateGrant(grant types.Grant) error {
+	_, err := db.pq.Insert("acls").Columns("id", "owner_id", "path", "grantee_id", "permission").
+		Values(grant.Id, grant.OwnerId, grant.Path, grant.GranteeId, grant.Permission).
+		Suffix("ON CONFLICT (owner_id, path, grantee_id) DO UPDATE SET permission = EXCLUDED.permission").RunWith(runner{db.db}).Exec()
+	return err
+}
+
+// GetGrantById gets a grant by its id
+func (db *Store) GetGrantById(id string) (types.Grant, error) {
+	row := db.pq.Select(grantColumns...).From("acls").Where(squirrel.Eq{"id": id}).RunWith(runner{db.db}).QueryRow()
+
+	return scanGrant(row)
+}
+
+// GetGrantsByOwner gets all the grants given by an owner
+func (db *Store) GetGrantsByOwner(ownerId string) ([]types.Grant, error) {
+	rows, err := db.pq.Select(grantColumns...).From("acls").Where(squirrel.Eq{"owner_id": ownerId}).OrderBy("path").RunWith(runner{db.db}).Query()
+	if err != nil {
+		return nil, err
+	}
+
+	return scanGrants(rows)
+}
+
+// GetGrantsForGrantee gets all the grants given to a grantee or to the groups they are a member of
+func (db *Store) GetGrantsForGrantee(granteeId string) ([]types.Grant, error) {
+	rows, err := db.pq.Select(grantColumns...).From("acls a").Where(granteeOf, granteeId, granteeId).OrderBy("owner_id", "path").RunWith(runner{db.db}).Query()
+	if err != nil {
+		return nil, err
+	}
+
+	return scanGrants(rows)
+}
+
+// GetGrantsCovering gets the grants to a grantee, or to a group they are a member of,
+// that cover a path of an owner through the path itself or a dir above it
+func (db *Store) GetGrantsCovering(ownerId, path, granteeId string) ([]types.Grant, error) {
+	rows, err := db.pq.Select(grantColumns...).From("acls a").Where(squirrel.Eq{"owner_id": ownerId}).Where(granteeOf, granteeId, granteeId).
+		Where("(path IN ('.', '/') OR path = ? OR ? LIKE replace(replace(replace(path, '\\', '\\\\'), '%', '\\%'), '_', '\\_') || '/%')", path, path).
+		RunWith(runner{db.db}).Query()
+	if err != nil {
+		return nil, err
+	}
+
+	return scanGrants(rows)
+}
+
+// DeleteGrantById deletes a grant
+func (db *Store) DeleteGrantById(id string) error {
+	_, err := db.pq.Delete("acls").Where(squirrel.Eq{"id": id}).RunWith(runner{db.db}).Exec()
+	return err
+}
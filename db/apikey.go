@@ -0,0 +1,94 @@
+package db
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/Masterminds/squirrel"
+	"github.com/newtoallofthis123/noob_store/types"
+)
+
+var apiKeyColumns = []string{"id", "user_id", "name", "hash", "scope", "path_prefix", "expires_at", "last_used_at", "created_at"}
+
+func scanApiKey(row squirrel.RowScanner) (types.ApiKey, error) {
+	var key types.ApiKey
+	var expiresAt, lastUsedAt sql.NullString
+
+	err := row.Scan(&key.Id, &key.UserId, &key.Name, &key.Hash, &key.Scope, &key.PathPrefix, &expiresAt, &lastUsedAt, &key.CreatedAt)
+	if err != nil {
+		return types.ApiKey{}, err
+	}
+	key.ExpiresAt = expiresAt.String
+	key.LastUsedAt = lastUsedAt.String
+
+	return key, nil
+}
+
+// CreateApiKey inserts an api key
+func (db *Store) CreateApiKey(key types.ApiKey) error {
+	var expiresAt any
+	if key.ExpiresAt != "" {
+		expiresAt = key.ExpiresAt
+	}
+	_, err := db.pq.Insert("api_keys").Columns("id", "user_id", "name", "hash", "scope", "path_prefix", "expires_at").
+		Values(key.Id, key.UserId, key.Name, key.Hash, key.Scope, key.PathPrefix, expiresAt).RunWith(runner{db.db}).Exec()
+	return err
+}
+
+// GetApiKeyById gets an api key by its id
+func (db *Store) GetApiKeyById(id string) (types.ApiKey, error) {
+	row := db.pq.Select(apiKeyColumns...).From("api_keys").Where(squirrel.Eq{"id": id}).RunWith(runner{db.db}).QueryRow()
+
+	return scanApiKey(row)
+}
+
+// GetApiKeyByHash gets the api key with the hash of a token
+func (db *Store) GetApiKeyByHash(hash string) (types.ApiKey, error) {
+	row := db.pq.Select(apiKeyColumns...).From("api_keys").Where(squirrel.Eq{"hash": hash}).RunWith(runner{db.db}).QueryRow()
+
+	return scanApiKey(row)
+}
+
+// GetApiKeysByUser gets all the api keys of a user
+func (db *Store) GetApiKeysByUser(userId string) ([]types.ApiKey, error) {
+	rows, err := db.pq.Select(apiKeyColumns...).From("api_keys").Where(squirrel.Eq{"user_id": userId}).
+		OrderBy("created_at").RunWith(runner{db.db}).Query()
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := make([]types.ApiKey, 0)
+	for rows.Next() {
+		key, err := scanApiKey(rows)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+
+	return keys, nil
+}
+
+// TouchApiKey records that an api key was just used, writing at most once per interval
+func (db *Store) TouchApiKey(id string, at time.Time, interval time.Duration) error {
+	_, err := db.pq.Update("api_keys").Set("last_used_at", at).Where(squirrel.Eq{"id": id}).
+		Where(squirrel.Or{squirrel.Eq{"last_used_at": nil}, squirrel.Lt{"last_used_at": at.Add(-interval)}}).RunWith(runner{db.db}).Exec()
+	return err
+}
+
+// DeleteApiKeyById deletes an api key
+func (db *Store) DeleteApiKeyById(id string) error {
+	_, err := db.pq.Delete("api_keys").Where(squirrel.Eq{"id": id}).RunWith(runner{db.db}).Exec()
+	return err
+}
+
+// DeleteApiKeysByUser deletes all the api keys of a user, returning how many there were
+func (db *Store) DeleteApiKeysByUser(userId string) (int64, error) {
+	res, err := db.pq.Delete("api_keys").Where(squirrel.Eq{"user_id": userId}).RunWith(runner{db.db}).Exec()
+	if err != nil {
+		return 0, err
+	}
+
+	return res.RowsAffected()
+}
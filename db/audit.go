@@ -0,0 +1,44 @@
+package db
+
+import (
+	"github.com/Masterminds/squirrel"
+	"github.com/newtoallofthis123/noob_store/types"
+)
+
+// InsertAuditEntry records an administrative action in the audit log
+func (db *Store) InsertAuditEntry(entry types.AuditEntry) error {
+	_, err := db.pq.Insert("audit_log").Columns("actor_id", "action", "target", "detail", "ip").
+		Values(entry.ActorId, entry.Action, entry.Target, entry.Detail, entry.Ip).RunWith(runner{db.db}).Exec()
+	return err
+}
+
+// GetAuditLog gets the latest entries of the audit log, optionally of a single actor,
+// with ids below before when it is not zero
+func (db *Store) GetAuditLog(actorId string, before int64, limit uint64) ([]types.AuditEntry, error) {
+	query := db.pq.Select("id", "actor_id", "action", "target", "detail", "ip", "created_at").From("audit_log")
+	if actorId != "" {
+		query = query.Where(squirrel.Eq{"actor_id": actorId})
+	}
+	if before > 0 {
+		query = query.Where(squirrel.Lt{"id": before})
+	}
+
+	rows, err := query.OrderBy("id DESC").Limit(limit).RunWith(runner{db.db}).Query()
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := make([]types.AuditEntry, 0)
+	for rows.Next() {
+		var entry types.AuditEntry
+
+		err := rows.Scan(&entry.Id, &entry.ActorId, &entry.Action, &entry.Target, &entry.Detail, &entry.Ip, &entry.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+
+	return entries, nil
+}
@@ -8,13 +8,13 @@ import (
 // InsertBlob inserts a blob into the table
 func (db *Store) InsertBlob(blob types.Blob) error {
 	_, err := db.pq.Insert("blobs").Columns("id", "name", "bucket", "size", "checksum", "start").Values(
-		blob.Id, blob.Name, blob.Bucket, blob.Size, blob.Checksum, blob.Start).RunWith(db.db).Exec()
+		blob.Id, blob.Name, blob.Bucket, blob.Size, blob.Checksum, blob.Start).RunWith(runner{db.db}).Exec()
 	return err
 }
 
 // GetBlob gets a blob by name
 func (db *Store) GetBlob(name string) (types.Blob, error) {
-	row := db.pq.Select("*").From("blobs").Where("name LIKE ?", name).RunWith(db.db).QueryRow()
+	row := db.pq.Select("*").From("blobs").Where("name LIKE ?", name).RunWith(runner{db.db}).QueryRow()
 	var blob types.Blob
 
 	err := row.Scan(&blob.Id, &blob.Name, &blob.Bucket, &blob.Start, &blob.Size, &blob.Checksum, &blob.Deleted, &blob.CreatedAt)
@@ -27,7 +27,7 @@ func (db *Store) GetBlob(name string) (types.Blob, error) {
 
 // GetBlobById gets a blob by the blobId
 func (db *Store) GetBlobById(id string) (types.Blob, error) {
-	row := db.pq.Select("*").From("blobs").Where(squirrel.Eq{"id": id}).RunWith(db.db).QueryRow()
+	row := db.pq.Select("*").From("blobs").Where(squirrel.Eq{"id": id}).RunWith(runner{db.db}).QueryRow()
 	var blob types.Blob
 
 	err := row.Scan(&blob.Id, &blob.Name, &blob.Bucket, &blob.Start, &blob.Size, &blob.Checksum, &blob.Deleted, &blob.CreatedAt)
@@ -40,7 +40,7 @@ func (db *Store) GetBlobById(id string) (types.Blob, error) {
 
 // GetBlobsInBucket retrieves all blobs associated with the given id and bucketId.
 func (db *Store) GetBlobsInBucket(bucketId string) ([]types.Blob, error) {
-	rows, err := db.pq.Select("*").From("blobs").Where(squirrel.Eq{"bucket": bucketId}).RunWith(db.db).Query()
+	rows, err := db.pq.Select("*").From("blobs").Where(squirrel.Eq{"bucket": bucketId}).RunWith(runner{db.db}).Query()
 	if err != nil {
 		return nil, err
 	}
@@ -61,16 +61,39 @@ func (db *Store) GetBlobsInBucket(bucketId string) ([]types.Blob, error) {
 
 // DeleteBlobById deletes a blob with a given id
 func (db *Store) DeleteBlobById(id string) error {
-	_, err := db.pq.Delete("blobs").Where(squirrel.Eq{"id": id}).RunWith(db.db).Exec()
+	_, err := db.pq.Delete("blobs").Where(squirrel.Eq{"id": id}).RunWith(runner{db.db}).Exec()
 	return err
 }
 
 func (db *Store) MarkBlobDelete(id string) error {
-	_, err := db.pq.Update("blobs").Set("deleted", true).Where(squirrel.Eq{"id": id}).RunWith(db.db).Exec()
+	_, err := db.pq.Update("blobs").Set("deleted", true).Where(squirrel.Eq{"id": id}).RunWith(runner{db.db}).Exec()
 	return err
 }
 
 func (db *Store) ChangeBlobStart(id string, start uint64) error {
-	_, err := db.pq.Update("blobs").Set("start", start).Where(squirrel.Eq{"id": id}).RunWith(db.db).Exec()
+	_, err := db.pq.Update("blobs").Set("start", start).Where(squirrel.Eq{"id": id}).RunWith(runner{db.db}).Exec()
 	return err
 }
+
+// GetBucketStats gets the number of blobs and the live and deleted bytes stored in each bucket
+func (db *Store) GetBucketStats() (map[string]types.BucketStat, error) {
+	rows, err := db.pq.Select("bucket", "count(*)", "coalesce(sum(size) FILTER (WHERE NOT deleted), 0)", "coalesce(sum(size) FILTER (WHERE deleted), 0)").
+		From("blobs").GroupBy("bucket").RunWith(runner{db.db}).Query()
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	stats := make(map[string]types.BucketStat)
+	for rows.Next() {
+		var stat types.BucketStat
+
+		err := rows.Scan(&stat.Id, &stat.Blobs, &stat.LiveBytes, &stat.DeletedBytes)
+		if err != nil {
+			return nil, err
+		}
+		stats[stat.Id] = stat
+	}
+
+	return stats, nil
+}
@@ -0,0 +1,46 @@
+package db
+
+import (
+	"github.com/Masterminds/squirrel"
+	"github.com/newtoallofthis123/noob_store/types"
+)
+
+// IndexContent adds or replaces the extracted text of a metadata in the full text index
+func (db *Store) IndexContent(metaId, text string) error {
+	_, err := db.pq.Insert("content_index").Columns("metadata_id", "body").Values(metaId, text).
+		Suffix("ON CONFLICT (metadata_id) DO UPDATE SET body = EXCLUDED.body").RunWith(runner{db.db}).Exec()
+	return err
+}
+
+// SearchContent finds the files of a user, and the files shared with them, whose text matches a web search style query,
+// best matches first, with highlighted snippets of the matches
+func (db *Store) SearchContent(userId, q string, limit int) ([]types.ContentMatch, error) {
+	if limit <= 0 || limit > MaxListLimit {
+		limit = MaxListLimit
+	}
+
+	rows, err := db.pq.Select(qualify("m", metadataColumns)...).
+		Column("ts_headline('simple', c.body, websearch_to_tsquery('simple', ?), 'StartSel=<<, StopSel=>>, MaxFragments=3, FragmentDelimiter=\" ... \"')", q).
+		Column("ts_rank(c.tsv, websearch_to_tsquery('simple', ?)) AS rank", q).
+		From("content_index c").Join("metadata m ON m.id = c.metadata_id").
+		Where(userScope(userId, true)).Where(squirrel.Eq{"m.trashed_at": nil}).
+		Where("c.tsv @@ websearch_to_tsquery('simple', ?)", q).
+		OrderBy("rank DESC").Limit(uint64(limit)).RunWith(runner{db.db}).Query()
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	matches := make([]types.ContentMatch, 0)
+	for rows.Next() {
+		var match types.ContentMatch
+
+		match.File, err = scanMetadata(rows, &match.Snippet, &match.Rank)
+		if err != nil {
+			return nil, err
+		}
+		matches = append(matches, match)
+	}
+
+	return matches, nil
+}
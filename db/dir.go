@@ -0,0 +1,130 @@
+package db
+
+import (
+	"database/sql"
+	"path/filepath"
+	"strings"
+
+	"github.com/Masterminds/squirrel"
+	"github.com/newtoallofthis123/noob_store/types"
+	"github.com/newtoallofthis123/ranhash"
+)
+
+var dirColumns = []string{"id", "user_id", "name", "parent", "path", "created_at"}
+
+func scanDir(row squirrel.RowScanner) (types.Dir, error) {
+	var dir types.Dir
+
+	err := row.Scan(&dir.Id, &dir.UserId, &dir.Name, &dir.Parent, &dir.Path, &dir.CreatedAt)
+	if err != nil {
+		return types.Dir{}, err
+	}
+
+	return dir, nil
+}
+
+func scanDirs(rows *sql.Rows) ([]types.Dir, error) {
+	defer rows.Close()
+	dirs := make([]types.Dir, 0)
+
+	for rows.Next() {
+		dir, err := scanDir(rows)
+		if err != nil {
+			return nil, err
+		}
+		dirs = append(dirs, dir)
+	}
+
+	return dirs, nil
+}
+
+// IsRootDir checks if the path is the root of a user's tree, which always exists implicitly
+func IsRootDir(path string) bool {
+	return path == "." || path == "/" || path == ""
+}
+
+// EnsureDirs creates the dir at the given path along with all of its missing ancestors
+func (db *Store) EnsureDirs(userId, path string) error {
+	return ensureDirs(db.pq, runner{db.db}, userId, path)
+}
+
+func ensureDirs(pq squirrel.StatementBuilderType, run squirrel.BaseRunner, userId, path string) error {
+	path = filepath.Clean(path)
+	for !IsRootDir(path) {
+		_, err := pq.Insert("dirs").Columns("id", "user_id", "name", "parent", "path").
+			Values(ranhash.GenerateRandomString(8), userId, filepath.Base(path), filepath.Dir(path), path).
+			Suffix("ON CONFLICT (user_id, path) DO NOTHING").RunWith(run).Exec()
+		if err != nil {
+			return err
+		}
+		path = filepath.Dir(path)
+	}
+
+	return nil
+}
+
+// GetDirByUserPath gets the dir of a user at the given path
+func (db *Store) GetDirByUserPath(userId, path string) (types.Dir, error) {
+	row := db.pq.Select(dirColumns...).From("dirs").Where(squirrel.Eq{"user_id": userId, "path": path}).RunWith(runner{db.db}).QueryRow()
+
+	return scanDir(row)
+}
+
+// GetChildDirs gets the immediate sub dirs of a user's dir
+func (db *Store) GetChildDirs(userId, parent string) ([]types.Dir, error) {
+	rows, err := db.pq.Select(dirColumns...).From("dirs").Where(squirrel.Eq{"user_id": userId, "parent": parent}).
+		OrderBy("name").RunWith(runner{db.db}).Query()
+	if err != nil {
+		return nil, err
+	}
+
+	return scanDirs(rows)
+}
+
+// GetDirSubtree gets the dir at the path and all the dirs nested under it
+func (db *Store) GetDirSubtree(userId, path string) ([]types.Dir, error) {
+	rows, err := db.pq.Select(dirColumns...).From("dirs").Where(squirrel.Eq{"user_id": userId}).
+		Where("(path = ? OR path LIKE ?)", path, escapeLike(path)+"/%").RunWith(runner{db.db}).Query()
+	if err != nil {
+		return nil, err
+	}
+
+	return scanDirs(rows)
+}
+
+// FillDirStats fills the count of immediate sub dirs and the count and total size of all files nested under the dir
+func (db *Store) FillDirStats(dir *types.Dir) error {
+	files := db.pq.Select("count(*)", "coalesce(sum(b.size), 0)").From("metadata m").Join("blobs b ON m.blob = b.id").
+		Where(squirrel.Eq{"m.user_id": dir.UserId, "m.trashed_at": nil})
+	if !IsRootDir(dir.Path) {
+		files = files.Where("m.path LIKE ?", escapeLike(dir.Path)+"/%")
+	}
+
+	err := files.RunWith(runner{db.db}).QueryRow().Scan(&dir.Files, &dir.Size)
+	if err != nil {
+		return err
+	}
+
+	return db.pq.Select("count(*)").From("dirs").Where(squirrel.Eq{"user_id": dir.UserId, "parent": dir.Path}).
+		RunWith(runner{db.db}).QueryRow().Scan(&dir.Dirs)
+}
+
+// DeleteDirSubtree deletes the dir at the path and all the dirs nested under it
+func (db *Store) DeleteDirSubtree(userId, path string) error {
+	_, err := db.pq.Delete("dirs").Where(squirrel.Eq{"user_id": userId}).
+		Where("(path = ? OR path LIKE ?)", path, escapeLike(path)+"/%").RunWith(runner{db.db}).Exec()
+	return err
+}
+
+// rebaseDirs returns the dirs with their src path prefix replaced by dst
+func rebaseDirs(dirs []types.Dir, src, dst string) []types.Dir {
+	moved := make([]types.Dir, 0, len(dirs))
+	for _, dir := range dirs {
+		dir.Path = dst + strings.TrimPrefix(dir.Path, src)
+		dir.Parent = filepath.Dir(dir.Path)
+		dir.Name = filepath.Base(dir.Path)
+		moved = append(moved, dir)
+	}
+
+	return moved
+}
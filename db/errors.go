@@ -0,0 +1,62 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+
+	"github.com/Masterminds/squirrel"
+	"github.com/lib/pq"
+	"github.com/newtoallofthis123/noob_store/types"
+)
+
+// mapErr turns errors from postgres into the error kinds of types
+func mapErr(err error) error {
+	if err == nil {
+		return nil
+	}
+	if errors.Is(err, sql.ErrNoRows) {
+		return types.Errorf(types.ErrNotFound, "%w", err)
+	}
+
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		switch pqErr.Code {
+		case "23505", "23503":
+			// unique and foreign key violations
+			return types.Errorf(types.ErrConflict, "%w", err)
+		case "22P02", "23502", "23514":
+			// malformed values, missing values and failed checks
+			return types.Errorf(types.ErrInvalid, "%w", err)
+		}
+	}
+
+	return err
+}
+
+// runner runs the queries built with squirrel on a db or tx, mapping their errors with mapErr
+type runner struct {
+	std squirrel.StdSql
+}
+
+func (r runner) Exec(query string, args ...any) (sql.Result, error) {
+	res, err := r.std.Exec(query, args...)
+	return res, mapErr(err)
+}
+
+func (r runner) Query(query string, args ...any) (*sql.Rows, error) {
+	rows, err := r.std.Query(query, args...)
+	return rows, mapErr(err)
+}
+
+func (r runner) QueryRow(query string, args ...any) squirrel.RowScanner {
+	return rowScanner{r.std.QueryRow(query, args...)}
+}
+
+// rowScanner maps the error of scanning a single row
+type rowScanner struct {
+	row *sql.Row
+}
+
+func (r rowScanner) Scan(dest ...any) error {
+	return mapErr(r.row.Scan(dest...))
+}
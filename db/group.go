@@ -0,0 +1,175 @@
+package db
+
+import (
+	"path/filepath"
+
+	"github.com/Masterminds/squirrel"
+	"github.com/newtoallofthis123/noob_store/types"
+)
+
+// CreateGroup inserts a group along with its first owner
+func (db *Store) CreateGroup(group types.Group, ownerId string) error {
+	tx, err := db.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var orgId any
+	if group.OrgId != "" {
+		orgId = group.OrgId
+	}
+	_, err = db.pq.Insert("groups").Columns("id", "name", "kind", "org_id").Values(group.Id, group.Name, group.Kind, orgId).RunWith(runner{tx}).Exec()
+	if err != nil {
+		return err
+	}
+
+	_, err = db.pq.Insert("group_members").Columns("group_id", "user_id", "role").Values(group.Id, ownerId, types.RoleOwner).RunWith(runner{tx}).Exec()
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// GetGroup gets a group by its id
+func (db *Store) GetGroup(id string) (types.Group, error) {
+	row := db.pq.Select("id", "name", "kind", "coalesce(org_id, '')", "created_at").From("groups").Where(squirrel.Eq{"id": id}).RunWith(runner{db.db}).QueryRow()
+
+	var group types.Group
+	err := row.Scan(&group.Id, &group.Name, &group.Kind, &group.OrgId, &group.CreatedAt)
+	if err != nil {
+		return types.Group{}, err
+	}
+
+	return group, nil
+}
+
+// GetGroupByName gets the oldest group with a name
+func (db *Store) GetGroupByName(name string) (types.Group, error) {
+	row := db.pq.Select("id", "name", "kind", "coalesce(org_id, '')", "created_at").From("groups").Where(squirrel.Eq{"name": name}).
+		OrderBy("created_at").Limit(1).RunWith(runner{db.db}).QueryRow()
+
+	var group types.Group
+	err := row.Scan(&group.Id, &group.Name, &group.Kind, &group.OrgId, &group.CreatedAt)
+	if err != nil {
+		return types.Group{}, err
+	}
+
+	return group, nil
+}
+
+// GetGroupsByUser gets the groups a user is a member of along with their role
+func (db *Store) GetGroupsByUser(userId string) ([]types.Group, error) {
+	rows, err := db.pq.Select("g.id", "g.name", "g.kind", "coalesce(g.org_id, '')", "gm.role", "g.created_at").
+		From("groups g").Join("group_members gm ON gm.group_id = g.id").Where(squirrel.Eq{"gm.user_id": userId}).
+		OrderBy("g.name").RunWith(runner{db.db}).Query()
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	groups := make([]types.Group, 0)
+	for rows.Next() {
+		var group types.Group
+
+		err := rows.Scan(&group.Id, &group.Name, &group.Kind, &group.OrgId, &group.Role, &group.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		groups = append(groups, group)
+	}
+
+	return groups, nil
+}
+
+// GetMemberRole gets the role of a user in a group
+func (db *Store) GetMemberRole(groupId, userId string) (string, error) {
+	var role string
+	err := db.pq.Select("role").From("group_members").Where(squirrel.Eq{"group_id": groupId, "user_id": userId}).
+		RunWith(runner{db.db}).QueryRow().Scan(&role)
+	return role, err
+}
+
+// GetMembers gets the members of a group
+func (db *Store) GetMembers(groupId string) ([]types.Member, error) {
+	rows, err := db.pq.Select("gm.group_id", "gm.user_id", "u.email", "gm.role", "gm.created_at").
+		From("group_members gm").Join("users u ON u.id = gm.user_id").Where(squirrel.Eq{"gm.group_id": groupId}).
+		OrderBy("u.email").RunWith(runner{db.db}).Query()
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	members := make([]types.Member, 0)
+	for rows.Next() {
+		var member types.Member
+
+		err := rows.Scan(&member.GroupId, &member.UserId, &member.Email, &member.Role, &member.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		members = append(members, member)
+	}
+
+	return members, nil
+}
+
+// SetMember adds a user to a group or changes their role
+func (db *Store) SetMember(groupId, userId, role string) error {
+	_, err := db.pq.Insert("group_members").Columns("group_id", "user_id", "role").Values(groupId, userId, role).
+		Suffix("ON CONFLICT (group_id, user_id) DO UPDATE SET role = EXCLUDED.role").RunWith(runner{db.db}).Exec()
+	return err
+}
+
+// DeleteMember removes a user from a group
+func (db *Store) DeleteMember(groupId, userId string) error {
+	_, err := db.pq.Delete("group_members").Where(squirrel.Eq{"group_id": groupId, "user_id": userId}).RunWith(runner{db.db}).Exec()
+	return err
+}
+
+// TransferOwnership atomically gives the moved metadatas of one owner to another at the same paths.
+// Any dirs under path are moved along with the files.
+func (db *Store) TransferOwnership(fromId, toId, path string, moved []types.Metadata) error {
+	tx, err := db.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, meta := range moved {
+		_, err = db.pq.Update("metadata").Set("user_id", toId).Where(squirrel.Eq{"id": meta.Id}).RunWith(runner{tx}).Exec()
+		if err != nil {
+			return err
+		}
+	}
+
+	rows, err := db.pq.Select(dirColumns...).From("dirs").Where(squirrel.Eq{"user_id": fromId}).
+		Where("(path = ? OR path LIKE ?)", path, escapeLike(path)+"/%").RunWith(runner{tx}).Query()
+	if err != nil {
+		return err
+	}
+	dirs, err := scanDirs(rows)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.pq.Delete("dirs").Where(squirrel.Eq{"user_id": fromId}).
+		Where("(path = ? OR path LIKE ?)", path, escapeLike(path)+"/%").RunWith(runner{tx}).Exec()
+	if err != nil {
+		return err
+	}
+
+	err = ensureDirs(db.pq, runner{tx}, toId, filepath.Dir(path))
+	if err != nil {
+		return err
+	}
+	for _, dir := range dirs {
+		err = ensureDirs(db.pq, runner{tx}, toId, dir.Path)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
@@ -2,6 +2,8 @@ package db
 
 import (
 	"database/sql"
+	"fmt"
+	"strings"
 
 	"github.com/Masterminds/squirrel"
 	_ "github.com/lib/pq"
@@ -44,12 +46,65 @@ func (s *Store) InitTables() error {
 		created_at timestamp default now()
 	);
 
+	ALTER TABLE users ADD COLUMN IF NOT EXISTS role text not null default 'user';
+	ALTER TABLE users ADD COLUMN IF NOT EXISTS issuer text not null default '';
+	ALTER TABLE users ADD COLUMN IF NOT EXISTS subject text not null default '';
+
+	CREATE UNIQUE INDEX IF NOT EXISTS users_subject_idx ON users(issuer, subject) WHERE subject <> '';
+	ALTER TABLE users ADD COLUMN IF NOT EXISTS email_verified boolean not null default false;
+
+	CREATE TABLE IF NOT EXISTS user_tokens(
+		hash text primary key,
+		user_id text references users(id) on delete cascade,
+		kind text not null,
+		expires_at timestamptz not null,
+		used_at timestamptz
+	);
+
 	CREATE TABLE IF NOT EXISTS sessions(
 		id text primary key,
 		user_id text references users(id),
 		created_at timestamp default now()
 	);
 
+	ALTER TABLE sessions ADD COLUMN IF NOT EXISTS ip text not null default '';
+	ALTER TABLE sessions ADD COLUMN IF NOT EXISTS user_agent text not null default '';
+	ALTER TABLE sessions ADD COLUMN IF NOT EXISTS expires_at timestamptz;
+	ALTER TABLE sessions ADD COLUMN IF NOT EXISTS last_seen_at timestamptz default now();
+
+	CREATE INDEX IF NOT EXISTS sessions_user_idx ON sessions(user_id);
+
+	CREATE TABLE IF NOT EXISTS api_keys(
+		id text primary key,
+		user_id text references users(id),
+		name text not null,
+		hash text unique not null,
+		scope text not null,
+		path_prefix text not null default '',
+		expires_at timestamptz,
+		last_used_at timestamptz,
+		created_at timestamp default now()
+	);
+
+	CREATE INDEX IF NOT EXISTS api_keys_user_idx ON api_keys(user_id);
+
+	CREATE TABLE IF NOT EXISTS totp(
+		user_id text primary key references users(id) on delete cascade,
+		secret text not null,
+		enabled boolean not null default false,
+		last_counter bigint not null default 0,
+		created_at timestamptz default now()
+	);
+
+	CREATE TABLE IF NOT EXISTS recovery_codes(
+		id bigserial primary key,
+		user_id text references users(id) on delete cascade,
+		hash text not null,
+		used_at timestamptz
+	);
+
+	CREATE INDEX IF NOT EXISTS recovery_codes_user_idx ON recovery_codes(user_id);
+
 	CREATE TABLE IF NOT EXISTS metadata(
 		id text primary key,
 		name text not null,
@@ -60,6 +115,153 @@ func (s *Store) InitTables() error {
 		user_id text references users(id),
 		created_at timestamp default now()
 	);
+
+	ALTER TABLE metadata ADD COLUMN IF NOT EXISTS trashed_at timestamptz;
+	ALTER TABLE metadata ADD COLUMN IF NOT EXISTS retention_mode text not null default '';
+	ALTER TABLE metadata ADD COLUMN IF NOT EXISTS retain_until timestamptz;
+	ALTER TABLE metadata ADD COLUMN IF NOT EXISTS legal_hold boolean not null default false;
+	ALTER TABLE metadata ADD COLUMN IF NOT EXISTS user_meta jsonb not null default '{}';
+	ALTER TABLE metadata ADD COLUMN IF NOT EXISTS tags jsonb not null default '{}';
+
+	CREATE INDEX IF NOT EXISTS metadata_user_name_idx ON metadata(user_id, name, id);
+	CREATE INDEX IF NOT EXISTS metadata_user_name_pattern_idx ON metadata(user_id, name text_pattern_ops);
+	CREATE INDEX IF NOT EXISTS metadata_user_created_idx ON metadata(user_id, created_at, id);
+	CREATE INDEX IF NOT EXISTS metadata_user_mime_idx ON metadata(user_id, mime, id);
+	CREATE INDEX IF NOT EXISTS metadata_user_parent_idx ON metadata(user_id, parent);
+	CREATE INDEX IF NOT EXISTS metadata_user_path_idx ON metadata(user_id, path text_pattern_ops);
+	CREATE INDEX IF NOT EXISTS metadata_tags_idx ON metadata USING gin(tags);
+	CREATE INDEX IF NOT EXISTS blobs_bucket_idx ON blobs(bucket);
+
+	CREATE TABLE IF NOT EXISTS content_index(
+		metadata_id text primary key references metadata(id) on delete cascade,
+		body text not null,
+		tsv tsvector generated always as (to_tsvector('simple', body)) stored
+	);
+
+	CREATE INDEX IF NOT EXISTS content_index_tsv_idx ON content_index USING gin(tsv);
+
+	CREATE TABLE IF NOT EXISTS acls(
+		id text primary key,
+		owner_id text references users(id),
+		path text not null,
+		grantee_id text references users(id),
+		permission text not null,
+		created_at timestamp default now(),
+		unique(owner_id, path, grantee_id)
+	);
+
+	CREATE INDEX IF NOT EXISTS acls_grantee_idx ON acls(grantee_id, owner_id);
+
+	CREATE TABLE IF NOT EXISTS share_links(
+		id text primary key,
+		metadata_id text references metadata(id) on delete cascade,
+		user_id text references users(id),
+		password text not null default '',
+		expires_at timestamptz,
+		max_downloads int not null default 0,
+		downloads int not null default 0,
+		revoked boolean not null default false,
+		created_at timestamp default now()
+	);
+
+	CREATE TABLE IF NOT EXISTS link_events(
+		id bigserial primary key,
+		link_id text references share_links(id) on delete cascade,
+		ip text not null,
+		user_agent text not null,
+		ok boolean not null,
+		reason text not null default '',
+		created_at timestamptz default now()
+	);
+
+	CREATE TABLE IF NOT EXISTS groups(
+		id text primary key,
+		name text not null,
+		kind text not null,
+		org_id text references groups(id) on delete cascade,
+		created_at timestamp default now()
+	);
+
+	CREATE TABLE IF NOT EXISTS group_members(
+		group_id text references groups(id) on delete cascade,
+		user_id text references users(id),
+		role text not null,
+		created_at timestamp default now(),
+		primary key(group_id, user_id)
+	);
+
+	CREATE INDEX IF NOT EXISTS group_members_user_idx ON group_members(user_id);
+
+	CREATE TABLE IF NOT EXISTS audit_log(
+		id bigserial primary key,
+		actor_id text not null,
+		action text not null,
+		target text not null default '',
+		detail text not null default '',
+		ip text not null default '',
+		created_at timestamptz default now()
+	);
+
+	CREATE INDEX IF NOT EXISTS audit_log_actor_idx ON audit_log(actor_id, id);
+
+	CREATE TABLE IF NOT EXISTS quotas(
+		owner_id text primary key,
+		max_bytes bigint not null default 0,
+		max_objects bigint not null default 0
+	);
+
+	CREATE TABLE IF NOT EXISTS usage(
+		owner_id text primary key,
+		bytes bigint not null default 0,
+		objects bigint not null default 0,
+		updated_at timestamp default now()
+	);
+
+	CREATE TABLE IF NOT EXISTS dirs(
+		id text primary key,
+		user_id text references users(id),
+		name text not null,
+		parent text not null,
+		path text not null,
+		created_at timestamp default now(),
+		unique(user_id, path)
+	);
+
+	CREATE INDEX IF NOT EXISTS dirs_user_parent_idx ON dirs(user_id, parent);
+
+	CREATE TABLE IF NOT EXISTS lifecycle_rules(
+		id text primary key,
+		user_id text references users(id),
+		prefix text not null default '',
+		bucket text not null default '',
+		tags jsonb not null default '{}',
+		expire_days int not null,
+		created_at timestamp default now()
+	);
+
+	ALTER TABLE lifecycle_rules ADD COLUMN IF NOT EXISTS tags jsonb not null default '{}';
+
+	-- Storage and grants can be owned by groups as well as users
+	ALTER TABLE metadata DROP CONSTRAINT IF EXISTS metadata_user_id_fkey;
+	ALTER TABLE dirs DROP CONSTRAINT IF EXISTS dirs_user_id_fkey;
+	ALTER TABLE acls DROP CONSTRAINT IF EXISTS acls_owner_id_fkey;
+	ALTER TABLE acls DROP CONSTRAINT IF EXISTS acls_grantee_id_fkey;
+
+	-- Backfill the dirs of files stored before dirs were tracked, along with all of their ancestors
+	WITH RECURSIVE ancestors(user_id, path) AS (
+		SELECT DISTINCT user_id, parent FROM metadata WHERE trashed_at IS NULL
+		UNION
+		SELECT user_id, regexp_replace(path, '/[^/]*$', '') FROM ancestors WHERE position('/' in path) > 0
+	)
+	INSERT INTO dirs(id, user_id, name, parent, path)
+	SELECT substr(md5(random()::text || path), 1, 16), user_id, regexp_replace(path, '^.*/', ''),
+		CASE
+			WHEN position('/' in path) = 0 THEN '.'
+			WHEN regexp_replace(path, '/[^/]*$', '') = '' THEN '/'
+			ELSE regexp_replace(path, '/[^/]*$', '')
+		END, path
+	FROM ancestors WHERE path NOT IN ('', '.', '/')
+	ON CONFLICT (user_id, path) DO NOTHING;
 	`
 
 	_, err := s.db.Exec(query)
@@ -67,5 +269,90 @@ func (s *Store) InitTables() error {
 		return err
 	}
 
+	// Emails are unique regardless of case, which databases from before may not hold to
+	err = s.checkDuplicateEmails()
+	if err != nil {
+		return err
+	}
+	_, err = s.db.Exec(`CREATE UNIQUE INDEX IF NOT EXISTS users_email_idx ON users(lower(email))`)
+	if err != nil {
+		return err
+	}
+
+	return s.convertToTimestamptz(zonedColumns)
+}
+
+// timeColumn is a time column of a table
+type timeColumn struct {
+	table  string
+	column string
+}
+
+// zonedColumns are the time columns compared against the time of the server, which have to keep
+// their time zone but were created without one in databases from before
+var zonedColumns = []timeColumn{
+	{"sessions", "expires_at"},
+	{"sessions", "last_seen_at"},
+	{"metadata", "trashed_at"},
+	{"metadata", "retain_until"},
+	{"user_tokens", "expires_at"},
+	{"user_tokens", "used_at"},
+	{"api_keys", "expires_at"},
+	{"api_keys", "last_used_at"},
+	{"totp", "created_at"},
+	{"recovery_codes", "used_at"},
+	{"share_links", "expires_at"},
+	{"link_events", "created_at"},
+	{"audit_log", "created_at"},
+}
+
+// convertToTimestamptz converts the columns still stored without a time zone to timestamptz.
+// Columns already converted are left alone, as the conversion rewrites and locks the whole table.
+func (s *Store) convertToTimestamptz(columns []timeColumn) error {
+	for _, col := range columns {
+		var count int
+		err := s.pq.Select("count(*)").From("information_schema.columns").
+			Where("table_schema = current_schema()").
+			Where(squirrel.Eq{"table_name": col.table, "column_name": col.column, "data_type": "timestamp without time zone"}).
+			RunWith(runner{s.db}).QueryRow().Scan(&count)
+		if err != nil {
+			return err
+		}
+		if count == 0 {
+			continue
+		}
+
+		_, err = s.db.Exec(fmt.Sprintf("ALTER TABLE %s ALTER COLUMN %s TYPE timestamptz", col.table, col.column))
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// checkDuplicateEmails fails with the users sharing an email up to case, which have to be
+// merged or renamed by hand before the unique index on emails can be built
+func (s *Store) checkDuplicateEmails() error {
+	rows, err := s.pq.Select("lower(email)", "string_agg(id || ' (' || email || ')', ', ' ORDER BY created_at)").
+		From("users").GroupBy("lower(email)").Having("count(*) > 1").OrderBy("1").RunWith(runner{s.db}).Query()
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	var conflicts []string
+	for rows.Next() {
+		var email, users string
+		err := rows.Scan(&email, &users)
+		if err != nil {
+			return err
+		}
+		conflicts = append(conflicts, email+": "+users)
+	}
+	if len(conflicts) > 0 {
+		return fmt.Errorf("users share the same email, merge or rename them before starting again:\n%s", strings.Join(conflicts, "\n"))
+	}
+
 	return nil
 }
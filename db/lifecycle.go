@@ -0,0 +1,101 @@
+package db
+
+import (
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/Masterminds/squirrel"
+	"github.com/newtoallofthis123/noob_store/types"
+)
+
+// escapeLike escapes the wildcards of a LIKE pattern
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(s)
+}
+
+var lifecycleColumns = []string{"id", "user_id", "prefix", "bucket", "tags", "expire_days", "created_at"}
+
+func scanLifecycleRule(row squirrel.RowScanner) (types.LifecycleRule, error) {
+	var rule types.LifecycleRule
+	var tags []byte
+
+	err := row.Scan(&rule.Id, &rule.UserId, &rule.Prefix, &rule.Bucket, &tags, &rule.ExpireDays, &rule.CreatedAt)
+	if err != nil {
+		return types.LifecycleRule{}, err
+	}
+	_ = json.Unmarshal(tags, &rule.Tags)
+
+	return rule, nil
+}
+
+// CreateLifecycleRule inserts a lifecycle rule
+func (db *Store) CreateLifecycleRule(rule types.LifecycleRule) error {
+	_, err := db.pq.Insert("lifecycle_rules").Columns("id", "user_id", "prefix", "bucket", "tags", "expire_days").
+		Values(rule.Id, rule.UserId, rule.Prefix, rule.Bucket, jsonMap(rule.Tags), rule.ExpireDays).RunWith(runner{db.db}).Exec()
+	return err
+}
+
+// GetLifecycleRuleById gets a lifecycle rule by its id
+func (db *Store) GetLifecycleRuleById(id string) (types.LifecycleRule, error) {
+	row := db.pq.Select(lifecycleColumns...).From("lifecycle_rules").
+		Where(squirrel.Eq{"id": id}).RunWith(runner{db.db}).QueryRow()
+
+	return scanLifecycleRule(row)
+}
+
+// GetLifecycleRules gets all the lifecycle rules, or only the ones of a user if userId is not empty
+func (db *Store) GetLifecycleRules(userId string) ([]types.LifecycleRule, error) {
+	query := db.pq.Select(lifecycleColumns...).From("lifecycle_rules")
+	if userId != "" {
+		query = query.Where(squirrel.Eq{"user_id": userId})
+	}
+
+	rows, err := query.RunWith(runner{db.db}).Query()
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	rules := make([]types.LifecycleRule, 0)
+	for rows.Next() {
+		rule, err := scanLifecycleRule(rows)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, rule)
+	}
+
+	return rules, nil
+}
+
+// DeleteLifecycleRuleById deletes a lifecycle rule
+func (db *Store) DeleteLifecycleRuleById(id string) error {
+	_, err := db.pq.Delete("lifecycle_rules").Where(squirrel.Eq{"id": id}).RunWith(runner{db.db}).Exec()
+	return err
+}
+
+// GetExpiredByRule gets all the metadatas that a lifecycle rule expires at the given time
+func (db *Store) GetExpiredByRule(rule types.LifecycleRule, now time.Time) ([]types.Metadata, error) {
+	query := db.pq.Select(metadataColumns...).From("metadata").
+		Where(squirrel.Eq{"user_id": rule.UserId}).
+		Where(squirrel.Lt{"created_at": now.AddDate(0, 0, -rule.ExpireDays)}).
+		Where(notTrashed)
+
+	if rule.Prefix != "" {
+		query = query.Where("path LIKE ?", escapeLike(rule.Prefix)+"%")
+	}
+	if len(rule.Tags) > 0 {
+		query = query.Where("tags @> ?::jsonb", jsonMap(rule.Tags))
+	}
+	if rule.Bucket != "" {
+		query = query.Where("blob IN (SELECT id FROM blobs WHERE bucket = ?)", rule.Bucket)
+	}
+
+	rows, err := query.RunWith(runner{db.db}).Query()
+	if err != nil {
+		return nil, err
+	}
+
+	return scanMetadatas(rows), nil
+}
@@ -0,0 +1,112 @@
+package db
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/Masterminds/squirrel"
+	"github.com/newtoallofthis123/noob_store/types"
+)
+
+var linkColumns = []string{"id", "metadata_id", "user_id", "password", "expires_at", "max_downloads", "downloads", "revoked", "created_at"}
+
+func scanLink(row squirrel.RowScanner) (types.ShareLink, error) {
+	var link types.ShareLink
+	var expiresAt sql.NullString
+
+	err := row.Scan(&link.Id, &link.MetadataId, &link.UserId, &link.Password, &expiresAt, &link.MaxDownloads, &link.Downloads, &link.Revoked, &link.CreatedAt)
+	if err != nil {
+		return types.ShareLink{}, err
+	}
+	link.ExpiresAt = expiresAt.String
+	link.HasPassword = link.Password != ""
+
+	return link, nil
+}
+
+// CreateShareLink inserts a share link, a nil expiry never expires
+func (db *Store) CreateShareLink(link types.ShareLink, expiresAt *time.Time) error {
+	_, err := db.pq.Insert("share_links").Columns("id", "metadata_id", "user_id", "password", "expires_at", "max_downloads").
+		Values(link.Id, link.MetadataId, link.UserId, link.Password, expiresAt, link.MaxDownloads).RunWith(runner{db.db}).Exec()
+	return err
+}
+
+// GetShareLinkById gets a share link by its id
+func (db *Store) GetShareLinkById(id string) (types.ShareLink, error) {
+	row := db.pq.Select(linkColumns...).From("share_links").Where(squirrel.Eq{"id": id}).RunWith(runner{db.db}).QueryRow()
+
+	return scanLink(row)
+}
+
+// GetShareLinksByUser gets all the share links created by a user
+func (db *Store) GetShareLinksByUser(userId string) ([]types.ShareLink, error) {
+	rows, err := db.pq.Select(linkColumns...).From("share_links").Where(squirrel.Eq{"user_id": userId}).
+		OrderBy("created_at DESC").RunWith(runner{db.db}).Query()
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	links := make([]types.ShareLink, 0)
+	for rows.Next() {
+		link, err := scanLink(rows)
+		if err != nil {
+			return nil, err
+		}
+		links = append(links, link)
+	}
+
+	return links, nil
+}
+
+// RevokeShareLink revokes a share link, keeping it around for its audit log
+func (db *Store) RevokeShareLink(id string) error {
+	_, err := db.pq.Update("share_links").Set("revoked", true).Where(squirrel.Eq{"id": id}).RunWith(runner{db.db}).Exec()
+	return err
+}
+
+// UseShareLink atomically counts a download of a share link if it is still usable,
+// returning false if it is revoked, expired or out of downloads
+func (db *Store) UseShareLink(id string, now time.Time) (bool, error) {
+	res, err := db.pq.Update("share_links").Set("downloads", squirrel.Expr("downloads + 1")).
+		Where(squirrel.Eq{"id": id, "revoked": false}).
+		Where("(max_downloads = 0 OR downloads < max_downloads)").
+		Where(squirrel.Or{squirrel.Eq{"expires_at": nil}, squirrel.Gt{"expires_at": now}}).
+		RunWith(runner{db.db}).Exec()
+	if err != nil {
+		return false, err
+	}
+
+	n, err := res.RowsAffected()
+	return n == 1, err
+}
+
+// InsertLinkEvent records an attempt to use a share link
+func (db *Store) InsertLinkEvent(event types.LinkEvent) error {
+	_, err := db.pq.Insert("link_events").Columns("link_id", "ip", "user_agent", "ok", "reason").
+		Values(event.LinkId, event.Ip, event.UserAgent, event.Ok, event.Reason).RunWith(runner{db.db}).Exec()
+	return err
+}
+
+// GetLinkEvents gets the audit log of a share link, latest first
+func (db *Store) GetLinkEvents(linkId string) ([]types.LinkEvent, error) {
+	rows, err := db.pq.Select("id", "link_id", "ip", "user_agent", "ok", "reason", "created_at").From("link_events").
+		Where(squirrel.Eq{"link_id": linkId}).OrderBy("id DESC").RunWith(runner{db.db}).Query()
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	events := make([]types.LinkEvent, 0)
+	for rows.Next() {
+		var event types.LinkEvent
+
+		err := rows.Scan(&event.Id, &event.LinkId, &event.Ip, &event.UserAgent, &event.Ok, &event.Reason, &event.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, event)
+	}
+
+	return events, nil
+}
@@ -0,0 +1,197 @@
+package db
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/Masterminds/squirrel"
+	"github.com/newtoallofthis123/noob_store/types"
+)
+
+// MaxListLimit is the largest page a listing returns
+const MaxListLimit = 1000
+
+// sortColumns maps the sortable fields of a listing to their column and type
+var sortColumns = map[string][2]string{
+	"name":       {"m.name", "text"},
+	"size":       {"b.size", "bigint"},
+	"created_at": {"m.created_at", "timestamp"},
+	"mime":       {"m.mime", "text"},
+}
+
+// listCursor is the position after the last item of a page
+type listCursor struct {
+	Value string `json:"v"`
+	Id    string `json:"id"`
+}
+
+func encodeCursor(cur listCursor) string {
+	b, _ := json.Marshal(cur)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func decodeCursor(s string) (listCursor, error) {
+	var cur listCursor
+
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return cur, types.Errorf(types.ErrInvalid, "invalid cursor")
+	}
+	err = json.Unmarshal(b, &cur)
+	if err != nil {
+		return cur, types.Errorf(types.ErrInvalid, "invalid cursor")
+	}
+
+	return cur, nil
+}
+
+// listFilters applies the filters of the list options to a query over metadata m joined with blobs b
+func listFilters(query squirrel.SelectBuilder, opts types.ListOptions) squirrel.SelectBuilder {
+	if opts.Dir != "" {
+		query = query.Where(squirrel.Eq{"m.parent": opts.Dir})
+	}
+	if opts.Prefix != "" {
+		query = query.Where("m.path LIKE ?", escapeLike(opts.Prefix)+"%")
+	}
+	if opts.Mime != "" {
+		if strings.HasSuffix(opts.Mime, "/*") {
+			query = query.Where("m.mime LIKE ?", escapeLike(strings.TrimSuffix(opts.Mime, "*"))+"%")
+		} else {
+			query = query.Where(squirrel.Eq{"m.mime": opts.Mime})
+		}
+	}
+	if len(opts.Tags) > 0 {
+		query = query.Where("m.tags @> ?::jsonb", jsonMap(opts.Tags))
+	}
+	if opts.MinSize != nil {
+		query = query.Where(squirrel.GtOrEq{"b.size": *opts.MinSize})
+	}
+	if opts.MaxSize != nil {
+		query = query.Where(squirrel.LtOrEq{"b.size": *opts.MaxSize})
+	}
+	if opts.After != nil {
+		query = query.Where(squirrel.GtOrEq{"m.created_at": *opts.After})
+	}
+	if opts.Before != nil {
+		query = query.Where(squirrel.Lt{"m.created_at": *opts.Before})
+	}
+
+	return query
+}
+
+// ListMetadata lists a page of the metadatas of a user.
+// With a delimiter, files nested deeper than the prefix are rolled up into common prefixes,
+// which are returned in full on the first page.
+func (db *Store) ListMetadata(userId string, opts types.ListOptions) (types.ListRes, error) {
+	return db.listMetadata(userScope(userId, opts.Shared), opts, nil)
+}
+
+// ListSharedMetadata lists a page of the metadatas shared with a user by others
+func (db *Store) ListSharedMetadata(granteeId string, opts types.ListOptions) (types.ListRes, error) {
+	return db.listMetadata(sharedWith(granteeId), opts, nil)
+}
+
+// SearchMetadata lists a page of the metadatas of a user matching a search query
+func (db *Store) SearchMetadata(userId, q string, opts types.ListOptions) (types.ListRes, error) {
+	cond, err := ParseSearchQuery(q)
+	if err != nil {
+		return types.ListRes{}, err
+	}
+
+	return db.listMetadata(userScope(userId, opts.Shared), opts, cond)
+}
+
+// userScope is the condition of the metadata m being owned by the user or, with shared, shared with them
+func userScope(userId string, shared bool) squirrel.Sqlizer {
+	if shared {
+		return squirrel.Or{squirrel.Eq{"m.user_id": userId}, sharedWith(userId)}
+	}
+	return squirrel.Eq{"m.user_id": userId}
+}
+
+func (db *Store) listMetadata(scope squirrel.Sqlizer, opts types.ListOptions, cond squirrel.Sqlizer) (types.ListRes, error) {
+	sort, ok := sortColumns[opts.Sort]
+	if !ok {
+		return types.ListRes{}, types.Errorf(types.ErrInvalid, "can not sort by %s", opts.Sort)
+	}
+	if opts.Limit <= 0 || opts.Limit > MaxListLimit {
+		opts.Limit = MaxListLimit
+	}
+
+	base := db.pq.Select().From("metadata m").Join("blobs b ON m.blob = b.id").
+		Where(scope).Where(squirrel.Eq{"m.trashed_at": nil})
+	base = listFilters(base, opts)
+	if cond != nil {
+		base = base.Where(cond)
+	}
+
+	// substr counts characters rather than bytes, so the prefix has to be too
+	rest := fmt.Sprintf("substr(m.path, %d)", utf8.RuneCountInString(opts.Prefix)+1)
+	query := base.Columns(append(qualify("m", metadataColumns), "b.size", sort[0]+"::text")...)
+	if opts.Delimiter != "" {
+		query = query.Where("strpos("+rest+", ?) = 0", opts.Delimiter)
+	}
+
+	order := "ASC"
+	cmp := ">"
+	if opts.Desc {
+		order = "DESC"
+		cmp = "<"
+	}
+	if opts.Cursor != "" {
+		cur, err := decodeCursor(opts.Cursor)
+		if err != nil {
+			return types.ListRes{}, err
+		}
+		query = query.Where(fmt.Sprintf("(%s, m.id) %s (CAST(? AS %s), ?)", sort[0], cmp, sort[1]), cur.Value, cur.Id)
+	}
+	query = query.OrderBy(sort[0]+" "+order, "m.id "+order).Limit(uint64(opts.Limit) + 1)
+
+	rows, err := query.RunWith(runner{db.db}).Query()
+	if err != nil {
+		return types.ListRes{}, err
+	}
+	defer rows.Close()
+
+	res := types.ListRes{Items: make([]types.Metadata, 0)}
+	var last listCursor
+	for rows.Next() {
+		var size uint64
+		var sortValue string
+		meta, err := scanMetadata(rows, &size, &sortValue)
+		if err != nil {
+			return types.ListRes{}, err
+		}
+		meta.Size = size
+		if len(res.Items) == opts.Limit {
+			res.NextCursor = encodeCursor(last)
+			break
+		}
+		res.Items = append(res.Items, meta)
+		last = listCursor{Value: sortValue, Id: meta.Id}
+	}
+
+	if opts.Delimiter != "" && opts.Cursor == "" {
+		prefixes := base.Column(fmt.Sprintf("DISTINCT split_part(%s, ?, 1)", rest), opts.Delimiter).
+			Where("strpos("+rest+", ?) > 0", opts.Delimiter).OrderBy("1")
+		rows, err := prefixes.RunWith(runner{db.db}).Query()
+		if err != nil {
+			return types.ListRes{}, err
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			var part string
+			err := rows.Scan(&part)
+			if err != nil {
+				return types.ListRes{}, err
+			}
+			res.CommonPrefixes = append(res.CommonPrefixes, opts.Prefix+part+opts.Delimiter)
+		}
+	}
+
+	return res, nil
+}
@@ -0,0 +1,38 @@
+package db
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/newtoallofthis123/noob_store/types"
+)
+
+func TestCursorRoundTrip(t *testing.T) {
+	tests := []listCursor{
+		{Value: "report.pdf", Id: "a1b2c3d4"},
+		{Value: "", Id: "a1b2c3d4"},
+		{Value: "12345", Id: "x"},
+		{Value: "2024-01-02 03:04:05.678+00", Id: "x"},
+		{Value: "résumé 履歴書 📄", Id: "x"},
+		{Value: `quotes " and \ slashes`, Id: "x"},
+	}
+
+	for _, cur := range tests {
+		got, err := decodeCursor(encodeCursor(cur))
+		if err != nil {
+			t.Fatalf("decodeCursor() of %v failed with %v", cur, err)
+		}
+		if got != cur {
+			t.Errorf("cursor round trip = %v, want %v", got, cur)
+		}
+	}
+}
+
+func TestDecodeCursorInvalid(t *testing.T) {
+	for _, s := range []string{"!!!", "bm90IGpzb24", "eyJ2Ijox"} {
+		_, err := decodeCursor(s)
+		if !errors.Is(err, types.ErrInvalid) {
+			t.Errorf("decodeCursor(%q) err = %v, want ErrInvalid", s, err)
+		}
+	}
+}
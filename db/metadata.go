@@ -1,46 +1,58 @@
 package db
 
 import (
+	"database/sql"
+	"encoding/json"
+	"path/filepath"
+	"time"
+
 	"github.com/Masterminds/squirrel"
 	"github.com/newtoallofthis123/noob_store/types"
 )
 
-// InsertMetaData inserts a metadata struct into the metadata table
-func (db *Store) InsertMetaData(meta types.Metadata) error {
-	_, err := db.pq.Insert("metadata").Columns("id", "name", "parent", "mime", "path", "user_id", "blob").
-		Values(meta.Id, meta.Name, meta.Parent, meta.Mime, meta.Path, meta.UserId, meta.Blob).RunWith(db.db).Exec()
+// metadataColumns are the columns selected for a metadata row, in scan order
+var metadataColumns = []string{"id", "name", "parent", "mime", "path", "blob", "user_id", "created_at", "trashed_at", "retention_mode", "retain_until", "legal_hold", "user_meta", "tags"}
 
-	return err
-}
+// notTrashed filters out metadata rows that have been moved to the trash
+var notTrashed = squirrel.Eq{"trashed_at": nil}
 
-// GetMetaData gets the metadata by the name and path
-func (db *Store) GetMetaDataByPath(path string) (types.Metadata, error) {
-	row := db.pq.Select("*").From("metadata").Where("path LIKE ?", path).RunWith(db.db).QueryRow()
+// qualify prefixes the columns with a table name or alias
+func qualify(table string, columns []string) []string {
+	qualified := make([]string, 0, len(columns))
+	for _, col := range columns {
+		qualified = append(qualified, table+"."+col)
+	}
+	return qualified
+}
 
+// scanMetadata scans a single metadata row selected with metadataColumns,
+// followed by any extra selected columns into extra
+func scanMetadata(row squirrel.RowScanner, extra ...any) (types.Metadata, error) {
 	var meta types.Metadata
+	var trashedAt, retainUntil sql.NullString
+	var userMeta, tags []byte
 
-	err := row.Scan(&meta.Id, &meta.Name, &meta.Parent, &meta.Mime, &meta.Path, &meta.Blob, &meta.UserId, &meta.CreatedAt)
+	dest := []any{&meta.Id, &meta.Name, &meta.Parent, &meta.Mime, &meta.Path, &meta.Blob, &meta.UserId, &meta.CreatedAt, &trashedAt,
+		&meta.RetentionMode, &retainUntil, &meta.LegalHold, &userMeta, &tags}
+	err := row.Scan(append(dest, extra...)...)
 	if err != nil {
 		return types.Metadata{}, err
 	}
+	_ = json.Unmarshal(userMeta, &meta.UserMeta)
+	_ = json.Unmarshal(tags, &meta.Tags)
+	meta.TrashedAt = trashedAt.String
+	meta.RetainUntil = retainUntil.String
 
 	return meta, nil
 }
 
-// GetMetaDataByDir gets the files and metadatas by the dir path
-func (db *Store) GetMetaDataByDir(path string) ([]types.Metadata, error) {
-	rows, err := db.pq.Select("*").From("metadata").Where("parent LIKE ?", path).RunWith(db.db).Query()
-	if err != nil {
-		return nil, err
-	}
-
+// scanMetadatas scans all the rows into metadatas, skipping rows that fail to scan
+func scanMetadatas(rows *sql.Rows) []types.Metadata {
+	defer rows.Close()
 	metas := make([]types.Metadata, 0)
 
 	for rows.Next() {
-
-		var meta types.Metadata
-
-		err := rows.Scan(&meta.Id, &meta.Name, &meta.Parent, &meta.Mime, &meta.Path, &meta.Blob, &meta.UserId, &meta.CreatedAt)
+		meta, err := scanMetadata(rows)
 		if err != nil {
 			continue
 		}
@@ -48,94 +60,258 @@ func (db *Store) GetMetaDataByDir(path string) ([]types.Metadata, error) {
 		metas = append(metas, meta)
 	}
 
-	return metas, nil
+	return metas
 }
 
-// GetAllFiles gets all of the files in metadata
-func (db *Store) GetAllFiles() ([]types.Metadata, error) {
-	rows, err := db.pq.Select("*").From("metadata").RunWith(db.db).Query()
-	if err != nil {
-		return nil, err
+// InsertMetaData inserts a metadata struct into the metadata table
+func (db *Store) InsertMetaData(meta types.Metadata) error {
+	_, err := db.pq.Insert("metadata").Columns("id", "name", "parent", "mime", "path", "user_id", "blob", "user_meta", "tags").
+		Values(meta.Id, meta.Name, meta.Parent, meta.Mime, meta.Path, meta.UserId, meta.Blob, jsonMap(meta.UserMeta), jsonMap(meta.Tags)).
+		RunWith(runner{db.db}).Exec()
+
+	return err
+}
+
+// jsonMap encodes a string map for a jsonb column
+func jsonMap(m map[string]string) string {
+	if m == nil {
+		return "{}"
 	}
+	b, _ := json.Marshal(m)
+	return string(b)
+}
 
-	metas := make([]types.Metadata, 0)
+// SetTags replaces the tags of a metadata
+func (db *Store) SetTags(id string, tags map[string]string) error {
+	_, err := db.pq.Update("metadata").Set("tags", jsonMap(tags)).Where(squirrel.Eq{"id": id}).RunWith(runner{db.db}).Exec()
+	return err
+}
 
-	for rows.Next() {
+// GetMetaData gets the metadata by the name and path
+func (db *Store) GetMetaDataByPath(path string) (types.Metadata, error) {
+	row := db.pq.Select(metadataColumns...).From("metadata").Where("path LIKE ?", path).Where(notTrashed).RunWith(runner{db.db}).QueryRow()
+
+	return scanMetadata(row)
+}
 
-		var meta types.Metadata
+// GetMetaDataByDir gets the files and metadatas by the dir path
+func (db *Store) GetMetaDataByDir(path string) ([]types.Metadata, error) {
+	rows, err := db.pq.Select(metadataColumns...).From("metadata").Where("parent LIKE ?", path).Where(notTrashed).RunWith(runner{db.db}).Query()
+	if err != nil {
+		return nil, err
+	}
 
-		err := rows.Scan(&meta.Id, &meta.Name, &meta.Parent, &meta.Mime, &meta.Path, &meta.Blob, &meta.UserId, &meta.CreatedAt)
-		if err != nil {
-			continue
-		}
+	return scanMetadatas(rows), nil
+}
 
-		metas = append(metas, meta)
+// GetAllFiles gets all of the files in metadata
+func (db *Store) GetAllFiles() ([]types.Metadata, error) {
+	rows, err := db.pq.Select(metadataColumns...).From("metadata").Where(notTrashed).RunWith(runner{db.db}).Query()
+	if err != nil {
+		return nil, err
 	}
 
-	return metas, nil
+	return scanMetadatas(rows), nil
 }
 
 // GetMetaDataById gets the metadata by metadataId
 func (db *Store) GetMetaDataById(id string) (types.Metadata, error) {
-	row := db.pq.Select("*").From("metadata").Where(squirrel.Eq{"id": id}).RunWith(db.db).QueryRow()
+	row := db.pq.Select(metadataColumns...).From("metadata").Where(squirrel.Eq{"id": id}).Where(notTrashed).RunWith(runner{db.db}).QueryRow()
 
-	var meta types.Metadata
+	return scanMetadata(row)
+}
 
-	err := row.Scan(&meta.Id, &meta.Name, &meta.Parent, &meta.Mime, &meta.Path, &meta.Blob, &meta.UserId, &meta.CreatedAt)
+// GetMetadatasByUser gets all metadatas associated with a user
+func (db *Store) GetMetadatasByUser(userId string) ([]types.Metadata, error) {
+	rows, err := db.pq.Select(metadataColumns...).From("metadata").Where(squirrel.Eq{"user_id": userId}).Where(notTrashed).RunWith(runner{db.db}).Query()
 	if err != nil {
-		return types.Metadata{}, err
+		return nil, err
 	}
 
-	return meta, nil
+	return scanMetadatas(rows), nil
 }
 
-// GetMetadatasByUser gets all metadatas associated with a user
-func (db *Store) GetMetadatasByUser(userId string) ([]types.Metadata, error) {
-	rows, err := db.pq.Select("*").From("metadata").Where(squirrel.Eq{"user_id": userId}).RunWith(db.db).Query()
+// GetMetadataDirByUser gets all metadatas associated with a user under a dir
+func (db *Store) GetMetadataDirByUser(userId, dir string) ([]types.Metadata, error) {
+	rows, err := db.pq.Select(metadataColumns...).From("metadata").Where("user_id LIKE ? AND parent LIKE ?", userId, dir).Where(notTrashed).RunWith(runner{db.db}).Query()
 	if err != nil {
 		return nil, err
 	}
-	metas := make([]types.Metadata, 0)
 
-	for rows.Next() {
+	return scanMetadatas(rows), nil
+}
+
+func (db *Store) DeleteMetadataById(id string) error {
+	_, err := db.pq.Delete("metadata").Where(squirrel.Eq{"id": id}).RunWith(runner{db.db}).Exec()
+	return err
+}
 
-		var meta types.Metadata
+// TrashMetadataById moves a metadata into the trash, keeping its original path
+func (db *Store) TrashMetadataById(id string, at time.Time) error {
+	_, err := db.pq.Update("metadata").Set("trashed_at", at).Where(squirrel.Eq{"id": id}).Where(notTrashed).RunWith(runner{db.db}).Exec()
+	return err
+}
 
-		err := rows.Scan(&meta.Id, &meta.Name, &meta.Parent, &meta.Mime, &meta.Path, &meta.Blob, &meta.UserId, &meta.CreatedAt)
+// RestoreMetadataById moves a metadata out of the trash
+func (db *Store) RestoreMetadataById(id string) error {
+	_, err := db.pq.Update("metadata").Set("trashed_at", nil).Where(squirrel.Eq{"id": id}).RunWith(runner{db.db}).Exec()
+	return err
+}
+
+// RestoreMetadatas atomically moves the metadatas out of the trash, restoring none of them if any fails.
+// The dirs they were in are created again, as they may have been removed after the files were trashed.
+func (db *Store) RestoreMetadatas(metas []types.Metadata) error {
+	tx, err := db.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, meta := range metas {
+		_, err = db.pq.Update("metadata").Set("trashed_at", nil).Where(squirrel.Eq{"id": meta.Id}).RunWith(runner{tx}).Exec()
 		if err != nil {
-			continue
+			return err
+		}
+		err = ensureDirs(db.pq, runner{tx}, meta.UserId, meta.Parent)
+		if err != nil {
+			return err
 		}
+	}
 
-		metas = append(metas, meta)
+	return tx.Commit()
+}
+
+// GetTrashedMetadataById gets a metadata that is in the trash by its id
+func (db *Store) GetTrashedMetadataById(id string) (types.Metadata, error) {
+	row := db.pq.Select(metadataColumns...).From("metadata").Where(squirrel.Eq{"id": id}).Where(squirrel.NotEq{"trashed_at": nil}).RunWith(runner{db.db}).QueryRow()
+
+	return scanMetadata(row)
+}
+
+// GetTrashByUser gets all metadatas in the trash of a user
+func (db *Store) GetTrashByUser(userId string) ([]types.Metadata, error) {
+	rows, err := db.pq.Select(metadataColumns...).From("metadata").Where(squirrel.Eq{"user_id": userId}).
+		Where(squirrel.NotEq{"trashed_at": nil}).OrderBy("trashed_at DESC").RunWith(runner{db.db}).Query()
+	if err != nil {
+		return nil, err
 	}
 
-	return metas, nil
+	return scanMetadatas(rows), nil
 }
 
-// GetMetadataDirByUser gets all metadatas associated with a user under a dir
-func (db *Store) GetMetadataDirByUser(userId, dir string) ([]types.Metadata, error) {
-	rows, err := db.pq.Select("*").From("metadata").Where("user_id LIKE ? AND parent LIKE ?", userId, dir).RunWith(db.db).Query()
+// GetTrashDirByUser gets all metadatas in the trash of a user that were under a dir
+func (db *Store) GetTrashDirByUser(userId, dir string) ([]types.Metadata, error) {
+	rows, err := db.pq.Select(metadataColumns...).From("metadata").Where(squirrel.Eq{"user_id": userId}).
+		Where("(parent = ? OR parent LIKE ?)", dir, escapeLike(dir)+"/%").Where(squirrel.NotEq{"trashed_at": nil}).RunWith(runner{db.db}).Query()
 	if err != nil {
 		return nil, err
 	}
-	metas := make([]types.Metadata, 0)
 
-	for rows.Next() {
+	return scanMetadatas(rows), nil
+}
 
-		var meta types.Metadata
+// GetExpiredTrash gets all metadatas that were trashed before the given time
+func (db *Store) GetExpiredTrash(before time.Time) ([]types.Metadata, error) {
+	rows, err := db.pq.Select(metadataColumns...).From("metadata").Where(squirrel.Lt{"trashed_at": before}).RunWith(runner{db.db}).Query()
+	if err != nil {
+		return nil, err
+	}
 
-		err := rows.Scan(&meta.Id, &meta.Name, &meta.Parent, &meta.Mime, &meta.Path, &meta.Blob, &meta.UserId, &meta.CreatedAt)
+	return scanMetadatas(rows), nil
+}
+
+// SetRetention sets the retention mode and retain until date of a metadata, a nil until clears it
+func (db *Store) SetRetention(id string, mode string, until *time.Time) error {
+	_, err := db.pq.Update("metadata").Set("retention_mode", mode).Set("retain_until", until).
+		Where(squirrel.Eq{"id": id}).RunWith(runner{db.db}).Exec()
+	return err
+}
+
+// SetLegalHold sets or clears the legal hold of a metadata
+func (db *Store) SetLegalHold(id string, hold bool) error {
+	_, err := db.pq.Update("metadata").Set("legal_hold", hold).Where(squirrel.Eq{"id": id}).RunWith(runner{db.db}).Exec()
+	return err
+}
+
+// IsBlobLocked checks if any metadata pointing to the blob is under retention or a legal hold
+func (db *Store) IsBlobLocked(blobId string, now time.Time) (bool, error) {
+	var locked bool
+	err := db.pq.Select("count(*) > 0").From("metadata").Where(squirrel.Eq{"blob": blobId}).
+		Where(squirrel.Or{squirrel.Eq{"legal_hold": true}, squirrel.Gt{"retain_until": now}}).
+		RunWith(runner{db.db}).QueryRow().Scan(&locked)
+	return locked, err
+}
+
+// GetMetaDataByUserPath gets the metadata of a user at the given path
+func (db *Store) GetMetaDataByUserPath(userId, path string) (types.Metadata, error) {
+	row := db.pq.Select(metadataColumns...).From("metadata").Where(squirrel.Eq{"user_id": userId, "path": path}).
+		Where(notTrashed).RunWith(runner{db.db}).QueryRow()
+
+	return scanMetadata(row)
+}
+
+// GetMetadataSubtreeByUser gets all metadatas of a user nested anywhere under a dir
+func (db *Store) GetMetadataSubtreeByUser(userId, dir string) ([]types.Metadata, error) {
+	rows, err := db.pq.Select(metadataColumns...).From("metadata").Where(squirrel.Eq{"user_id": userId}).
+		Where("path LIKE ?", escapeLike(dir)+"/%").Where(notTrashed).RunWith(runner{db.db}).Query()
+	if err != nil {
+		return nil, err
+	}
+
+	return scanMetadatas(rows), nil
+}
+
+// MoveMetadatas atomically updates the name, parent and path of the moved metadatas,
+// moving the metadatas they replace at the destination to the trash.
+// Any dirs under src are merged into dst along with the moved files.
+func (db *Store) MoveMetadatas(userId, src, dst string, moved []types.Metadata, replaced []string, at time.Time) error {
+	tx, err := db.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, id := range replaced {
+		_, err = db.pq.Update("metadata").Set("trashed_at", at).Where(squirrel.Eq{"id": id}).RunWith(runner{tx}).Exec()
 		if err != nil {
-			continue
+			return err
 		}
+	}
 
-		metas = append(metas, meta)
+	for _, meta := range moved {
+		_, err = db.pq.Update("metadata").Set("name", meta.Name).Set("parent", meta.Parent).Set("path", meta.Path).
+			Where(squirrel.Eq{"id": meta.Id}).RunWith(runner{tx}).Exec()
+		if err != nil {
+			return err
+		}
 	}
 
-	return metas, nil
-}
+	rows, err := db.pq.Select(dirColumns...).From("dirs").Where(squirrel.Eq{"user_id": userId}).
+		Where("(path = ? OR path LIKE ?)", src, escapeLike(src)+"/%").RunWith(runner{tx}).Query()
+	if err != nil {
+		return err
+	}
+	dirs, err := scanDirs(rows)
+	if err != nil {
+		return err
+	}
 
-func (db *Store) DeleteMetadataById(id string) error {
-	_, err := db.pq.Delete("metadata").Where(squirrel.Eq{"id": id}).RunWith(db.db).Exec()
-	return err
+	_, err = db.pq.Delete("dirs").Where(squirrel.Eq{"user_id": userId}).
+		Where("(path = ? OR path LIKE ?)", src, escapeLike(src)+"/%").RunWith(runner{tx}).Exec()
+	if err != nil {
+		return err
+	}
+
+	err = ensureDirs(db.pq, runner{tx}, userId, filepath.Dir(dst))
+	if err != nil {
+		return err
+	}
+	for _, dir := range rebaseDirs(dirs, src, dst) {
+		err = ensureDirs(db.pq, runner{tx}, userId, dir.Path)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
 }
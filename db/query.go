@@ -0,0 +1,217 @@
+package db
+
+import (
+	"regexp"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/Masterminds/squirrel"
+	"github.com/newtoallofthis123/noob_store/types"
+	"github.com/newtoallofthis123/noob_store/utils"
+)
+
+// comparisons are the operators of size and created terms, longest first
+var comparisons = []string{">=", "<=", ">", "<", "="}
+
+// tokenize splits a query on whitespace, keeping double quoted runs together
+func tokenize(q string) ([]string, error) {
+	tokens := make([]string, 0)
+	var cur strings.Builder
+	quoted := false
+
+	for _, r := range q {
+		switch {
+		case r == '"':
+			quoted = !quoted
+		case unicode.IsSpace(r) && !quoted:
+			if cur.Len() > 0 {
+				tokens = append(tokens, cur.String())
+				cur.Reset()
+			}
+		default:
+			cur.WriteRune(r)
+		}
+	}
+	if quoted {
+		return nil, types.Errorf(types.ErrInvalid, "unterminated quote in query")
+	}
+	if cur.Len() > 0 {
+		tokens = append(tokens, cur.String())
+	}
+
+	return tokens, nil
+}
+
+// globToRegex converts a glob into an anchored regex where * and ? do not cross a / and ** does
+func globToRegex(glob string) string {
+	var re strings.Builder
+	re.WriteString("^")
+	for i := 0; i < len(glob); i++ {
+		switch {
+		case strings.HasPrefix(glob[i:], "**"):
+			re.WriteString(".*")
+			i++
+		case glob[i] == '*':
+			re.WriteString("[^/]*")
+		case glob[i] == '?':
+			re.WriteString("[^/]")
+		default:
+			re.WriteString(regexp.QuoteMeta(glob[i : i+1]))
+		}
+	}
+	re.WriteString("$")
+	return re.String()
+}
+
+// globToLike converts a glob into a LIKE pattern, which unlike the regex can use a text_pattern_ops index
+// for its literal prefix. As LIKE has no way to keep * and ? from crossing a /, exact is false
+// when the glob has them and the pattern only narrows down the matches of globToRegex.
+func globToLike(glob string) (pattern string, exact bool) {
+	var like strings.Builder
+	exact = true
+	for i := 0; i < len(glob); i++ {
+		switch {
+		case strings.HasPrefix(glob[i:], "**"):
+			like.WriteString("%")
+			i++
+		case glob[i] == '*':
+			like.WriteString("%")
+			exact = false
+		case glob[i] == '?':
+			like.WriteString("_")
+			exact = false
+		default:
+			like.WriteString(escapeLike(glob[i : i+1]))
+		}
+	}
+	return like.String(), exact
+}
+
+// compare builds a comparison of a column against a value with one of the comparisons
+func compare(column, op string, value any) squirrel.Sqlizer {
+	switch op {
+	case ">=":
+		return squirrel.GtOrEq{column: value}
+	case "<=":
+		return squirrel.LtOrEq{column: value}
+	case ">":
+		return squirrel.Gt{column: value}
+	case "<":
+		return squirrel.Lt{column: value}
+	default:
+		return squirrel.Eq{column: value}
+	}
+}
+
+// splitComparison splits a term like size>10MB into its field, operator and value
+func splitComparison(term string) (string, string, string, bool) {
+	i := strings.IndexAny(term, "<>=")
+	if i <= 0 {
+		return "", "", "", false
+	}
+	for _, op := range comparisons {
+		if strings.HasPrefix(term[i:], op) {
+			return term[:i], op, term[i+len(op):], true
+		}
+	}
+	return "", "", "", false
+}
+
+// parseTerm compiles a single search term into a condition over metadata m joined with blobs b
+func parseTerm(term string) (squirrel.Sqlizer, error) {
+	if field, op, value, ok := splitComparison(term); ok && !strings.Contains(field, ":") {
+		switch field {
+		case "size":
+			n, err := utils.ParseSize(value)
+			if err != nil {
+				return nil, types.Errorf(types.ErrInvalid, "invalid size: %s", value)
+			}
+			return compare("b.size", op, n), nil
+		case "created":
+			t, err := time.Parse(time.RFC3339, value)
+			if err != nil {
+				t, err = time.Parse(time.DateOnly, value)
+			}
+			if err != nil {
+				return nil, types.Errorf(types.ErrInvalid, "invalid date: %s", value)
+			}
+			return compare("m.created_at", op, t), nil
+		default:
+			return nil, types.Errorf(types.ErrInvalid, "unknown comparison field: %s", field)
+		}
+	}
+
+	field, value, ok := strings.Cut(term, ":")
+	if !ok {
+		return squirrel.ILike{"m.name": "%" + escapeLike(term) + "%"}, nil
+	}
+	if value == "" {
+		return nil, types.Errorf(types.ErrInvalid, "missing value for %s", field)
+	}
+
+	switch field {
+	case "name":
+		// Names never hold a /, so LIKE expresses every name glob
+		pattern, _ := globToLike(value)
+		return squirrel.Like{"m.name": pattern}, nil
+	case "mime":
+		if strings.Contains(value, "*") {
+			return squirrel.Like{"m.mime": strings.ReplaceAll(escapeLike(value), "*", "%")}, nil
+		}
+		return squirrel.Eq{"m.mime": value}, nil
+	case "path":
+		// Paths are matched with a leading / whether or not they were stored with one
+		glob := "/" + strings.TrimPrefix(value, "/")
+		pattern, exact := globToLike(strings.TrimPrefix(glob, "/"))
+		like := squirrel.Or{squirrel.Like{"m.path": pattern}, squirrel.Like{"m.path": "/" + pattern}}
+		if exact {
+			return like, nil
+		}
+		return squirrel.And{like, squirrel.Expr("'/' || ltrim(m.path, '/') ~ ?", globToRegex(glob))}, nil
+	case "tag":
+		k, v, hasValue := strings.Cut(value, "=")
+		if !hasValue {
+			return squirrel.Expr("jsonb_exists(m.tags, ?)", strings.ToLower(k)), nil
+		}
+		return squirrel.Expr("m.tags @> ?::jsonb", jsonMap(map[string]string{strings.ToLower(k): v})), nil
+	default:
+		return nil, types.Errorf(types.ErrInvalid, "unknown search field: %s", field)
+	}
+}
+
+// ParseSearchQuery compiles a search query into a condition over metadata m joined with blobs b.
+// Terms are ANDed together and a term prefixed with - is negated. The supported terms are
+// name:<glob>, mime:<type>, path:<glob>, tag:<key>[=<value>], size<op><size>, created<op><date>
+// and bare words matching anywhere in the name.
+func ParseSearchQuery(q string) (squirrel.Sqlizer, error) {
+	tokens, err := tokenize(q)
+	if err != nil {
+		return nil, err
+	}
+	if len(tokens) == 0 {
+		return nil, types.Errorf(types.ErrInvalid, "empty query")
+	}
+
+	cond := squirrel.And{}
+	for _, token := range tokens {
+		negate := strings.HasPrefix(token, "-") && len(token) > 1
+		if negate {
+			token = token[1:]
+		}
+		term, err := parseTerm(token)
+		if err != nil {
+			return nil, err
+		}
+		if negate {
+			sql, args, err := term.ToSql()
+			if err != nil {
+				return nil, err
+			}
+			term = squirrel.Expr("NOT ("+sql+")", args...)
+		}
+		cond = append(cond, term)
+	}
+
+	return cond, nil
+}
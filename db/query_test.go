@@ -0,0 +1,87 @@
+package db
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/newtoallofthis123/noob_store/types"
+)
+
+func TestParseSearchQuery(t *testing.T) {
+	tests := []struct {
+		q    string
+		sql  string
+		args []any
+	}{
+		{"report", "(m.name ILIKE ?)", []any{"%report%"}},
+		{`"two words"`, "(m.name ILIKE ?)", []any{"%two words%"}},
+		{"50%_off", "(m.name ILIKE ?)", []any{`%50\%\_off%`}},
+		{"name:*.pdf", "(m.name LIKE ?)", []any{"%.pdf"}},
+		{"name:a_b%c?.txt", "(m.name LIKE ?)", []any{`a\_b\%c_.txt`}},
+		{"name:report.pdf", "(m.name LIKE ?)", []any{"report.pdf"}},
+		{"path:docs/**", "((m.path LIKE ? OR m.path LIKE ?))", []any{"docs/%", "/docs/%"}},
+		{"path:/docs/*.md", "(((m.path LIKE ? OR m.path LIKE ?) AND '/' || ltrim(m.path, '/') ~ ?))",
+			[]any{"docs/%.md", "/docs/%.md", `^/docs/[^/]*\.md$`}},
+		{"mime:image/*", "(m.mime LIKE ?)", []any{"image/%"}},
+		{"mime:text/plain", "(m.mime = ?)", []any{"text/plain"}},
+		{"tag:Env", "(jsonb_exists(m.tags, ?))", []any{"env"}},
+		{"tag:env=prod", "(m.tags @> ?::jsonb)", []any{`{"env":"prod"}`}},
+		{"size>10MB", "(b.size > ?)", []any{uint64(10 << 20)}},
+		{"size<=1KB", "(b.size <= ?)", []any{uint64(1 << 10)}},
+		{"created>=2024-01-02", "(m.created_at >= ?)", []any{time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC)}},
+		{"created<2024-01-02T03:04:05Z", "(m.created_at < ?)", []any{time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)}},
+		{"-name:*.tmp report", "(NOT (m.name LIKE ?) AND m.name ILIKE ?)", []any{"%.tmp", "%report%"}},
+		{"-", "(m.name ILIKE ?)", []any{"%-%"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.q, func(t *testing.T) {
+			cond, err := ParseSearchQuery(tt.q)
+			if err != nil {
+				t.Fatalf("ParseSearchQuery() failed with %v", err)
+			}
+			sql, args, err := cond.ToSql()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if sql != tt.sql {
+				t.Errorf("sql = %q, want %q", sql, tt.sql)
+			}
+			if !reflect.DeepEqual(args, tt.args) {
+				t.Errorf("args = %#v, want %#v", args, tt.args)
+			}
+		})
+	}
+}
+
+func TestParseSearchQueryInvalid(t *testing.T) {
+	for _, q := range []string{"", "   ", `"unterminated`, "name:", "color:red", "weight>10", "size>lots", "created>yesterday"} {
+		_, err := ParseSearchQuery(q)
+		if !errors.Is(err, types.ErrInvalid) {
+			t.Errorf("ParseSearchQuery(%q) err = %v, want ErrInvalid", q, err)
+		}
+	}
+}
+
+func TestGlobToLike(t *testing.T) {
+	tests := []struct {
+		glob    string
+		pattern string
+		exact   bool
+	}{
+		{"docs/report.pdf", "docs/report.pdf", true},
+		{"docs/**", "docs/%", true},
+		{"docs/*.md", "docs/%.md", false},
+		{"a?c", "a_c", false},
+		{`100%_done\`, `100\%\_done\\`, true},
+	}
+
+	for _, tt := range tests {
+		pattern, exact := globToLike(tt.glob)
+		if pattern != tt.pattern || exact != tt.exact {
+			t.Errorf("globToLike(%q) = %q, %v, want %q, %v", tt.glob, pattern, exact, tt.pattern, tt.exact)
+		}
+	}
+}
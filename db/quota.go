@@ -0,0 +1,73 @@
+package db
+
+import (
+	"database/sql"
+
+	"github.com/Masterminds/squirrel"
+	"github.com/newtoallofthis123/noob_store/types"
+)
+
+// GetQuota gets the quota set for an owner of storage
+func (db *Store) GetQuota(ownerId string) (types.Quota, error) {
+	row := db.pq.Select("owner_id", "max_bytes", "max_objects").From("quotas").Where(squirrel.Eq{"owner_id": ownerId}).RunWith(runner{db.db}).QueryRow()
+
+	var quota types.Quota
+	err := row.Scan(&quota.OwnerId, &quota.MaxBytes, &quota.MaxObjects)
+	if err != nil {
+		return types.Quota{}, err
+	}
+
+	return quota, nil
+}
+
+// SetQuota sets the quota of an owner of storage
+func (db *Store) SetQuota(quota types.Quota) error {
+	_, err := db.pq.Insert("quotas").Columns("owner_id", "max_bytes", "max_objects").Values(quota.OwnerId, quota.MaxBytes, quota.MaxObjects).
+		Suffix("ON CONFLICT (owner_id) DO UPDATE SET max_bytes = EXCLUDED.max_bytes, max_objects = EXCLUDED.max_objects").RunWith(runner{db.db}).Exec()
+	return err
+}
+
+// GetUsage gets the storage used by an owner, which is zero if nothing was ever stored
+func (db *Store) GetUsage(ownerId string) (types.Usage, error) {
+	row := db.pq.Select("owner_id", "bytes", "objects").From("usage").Where(squirrel.Eq{"owner_id": ownerId}).RunWith(runner{db.db}).QueryRow()
+
+	usage := types.Usage{OwnerId: ownerId}
+	err := row.Scan(&usage.OwnerId, &usage.Bytes, &usage.Objects)
+	if err != nil && err != sql.ErrNoRows {
+		return types.Usage{}, err
+	}
+
+	return usage, nil
+}
+
+// AddUsage adds the given bytes and objects, which may be negative, to the usage of an owner
+func (db *Store) AddUsage(ownerId string, bytes, objects int64) error {
+	_, err := db.pq.Insert("usage").Columns("owner_id", "bytes", "objects").Values(ownerId, bytes, objects).
+		Suffix("ON CONFLICT (owner_id) DO UPDATE SET bytes = usage.bytes + EXCLUDED.bytes, objects = usage.objects + EXCLUDED.objects, updated_at = now()").
+		RunWith(runner{db.db}).Exec()
+	return err
+}
+
+// ReconcileUsage recomputes the usage of every owner from the stored files, trashed files included
+func (db *Store) ReconcileUsage() error {
+	tx, err := db.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`
+	INSERT INTO usage(owner_id, bytes, objects, updated_at)
+	SELECT m.user_id, coalesce(sum(b.size), 0), count(*), now() FROM metadata m JOIN blobs b ON m.blob = b.id GROUP BY m.user_id
+	ON CONFLICT (owner_id) DO UPDATE SET bytes = EXCLUDED.bytes, objects = EXCLUDED.objects, updated_at = now()`)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`UPDATE usage SET bytes = 0, objects = 0, updated_at = now() WHERE owner_id NOT IN (SELECT DISTINCT user_id FROM metadata WHERE user_id IS NOT NULL)`)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
@@ -0,0 +1,89 @@
+package db
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/Masterminds/squirrel"
+	"github.com/newtoallofthis123/noob_store/types"
+)
+
+var sessionColumns = []string{"id", "user_id", "ip", "user_agent", "expires_at", "last_seen_at", "created_at"}
+
+func scanSession(row squirrel.RowScanner) (types.Session, error) {
+	var session types.Session
+	var expiresAt, lastSeenAt sql.NullString
+
+	err := row.Scan(&session.Id, &session.UserId, &session.Ip, &session.UserAgent, &expiresAt, &lastSeenAt, &session.CreatedAt)
+	if err != nil {
+		return types.Session{}, err
+	}
+	session.ExpiresAt = expiresAt.String
+	session.LastSeenAt = lastSeenAt.String
+
+	return session, nil
+}
+
+// CreateSession inserts a session
+func (db *Store) CreateSession(session types.Session) error {
+	var expiresAt any
+	if session.ExpiresAt != "" {
+		expiresAt = session.ExpiresAt
+	}
+	_, err := db.pq.Insert("sessions").Columns("id", "user_id", "ip", "user_agent", "expires_at").
+		Values(session.Id, session.UserId, session.Ip, session.UserAgent, expiresAt).RunWith(runner{db.db}).Exec()
+
+	return err
+}
+
+// GetSession gets a session from the sessionId
+func (db *Store) GetSession(id string) (types.Session, error) {
+	row := db.pq.Select(sessionColumns...).From("sessions").Where(squirrel.Eq{"id": id}).RunWith(runner{db.db}).QueryRow()
+
+	return scanSession(row)
+}
+
+// GetSessionsByUser gets all the sessions of a user
+func (db *Store) GetSessionsByUser(userId string) ([]types.Session, error) {
+	rows, err := db.pq.Select(sessionColumns...).From("sessions").Where(squirrel.Eq{"user_id": userId}).
+		OrderBy("last_seen_at DESC").RunWith(runner{db.db}).Query()
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sessions := make([]types.Session, 0)
+	for rows.Next() {
+		session, err := scanSession(rows)
+		if err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, session)
+	}
+
+	return sessions, nil
+}
+
+// TouchSession records that a session was just used
+func (db *Store) TouchSession(id string, at time.Time) error {
+	_, err := db.pq.Update("sessions").Set("last_seen_at", at).Where(squirrel.Eq{"id": id}).RunWith(runner{db.db}).Exec()
+	return err
+}
+
+func (db *Store) DeleteSessionById(id string) error {
+	_, err := db.pq.Delete("sessions").Where(squirrel.Eq{"id": id}).RunWith(runner{db.db}).Exec()
+	return err
+}
+
+// DeleteExpiredSessions deletes the sessions past their expiry or idle for longer than idle
+func (db *Store) DeleteExpiredSessions(now time.Time, idle time.Duration) (int64, error) {
+	res, err := db.pq.Delete("sessions").Where(squirrel.Or{
+		squirrel.Lt{"expires_at": now},
+		squirrel.Lt{"last_seen_at": now.Add(-idle)},
+	}).RunWith(runner{db.db}).Exec()
+	if err != nil {
+		return 0, err
+	}
+
+	return res.RowsAffected()
+}
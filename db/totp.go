@@ -0,0 +1,107 @@
+package db
+
+import (
+	"github.com/Masterminds/squirrel"
+	"github.com/newtoallofthis123/noob_store/types"
+)
+
+// SetTOTP stores a new, not yet enabled, totp secret for a user, replacing any pending one
+func (db *Store) SetTOTP(userId, secret string) error {
+	_, err := db.pq.Insert("totp").Columns("user_id", "secret").Values(userId, secret).
+		Suffix("ON CONFLICT (user_id) DO UPDATE SET secret = EXCLUDED.secret, enabled = false, last_counter = 0, created_at = now()").
+		RunWith(runner{db.db}).Exec()
+	return err
+}
+
+// GetTOTP gets the totp secret of a user
+func (db *Store) GetTOTP(userId string) (types.TOTP, error) {
+	row := db.pq.Select("user_id", "secret", "enabled", "last_counter", "created_at").From("totp").
+		Where(squirrel.Eq{"user_id": userId}).RunWith(runner{db.db}).QueryRow()
+
+	var totp types.TOTP
+	err := row.Scan(&totp.UserId, &totp.Secret, &totp.Enabled, &totp.LastCounter, &totp.CreatedAt)
+	if err != nil {
+		return types.TOTP{}, err
+	}
+
+	return totp, nil
+}
+
+// EnableTOTP turns on the totp secret of a user
+func (db *Store) EnableTOTP(userId string) error {
+	_, err := db.pq.Update("totp").Set("enabled", true).Where(squirrel.Eq{"user_id": userId}).RunWith(runner{db.db}).Exec()
+	return err
+}
+
+// UseTOTPCounter records the counter of a code that was used, failing if a later one was already used
+// so that concurrent logins can not both use the same code
+func (db *Store) UseTOTPCounter(userId string, counter int64) (bool, error) {
+	res, err := db.pq.Update("totp").Set("last_counter", counter).
+		Where(squirrel.Eq{"user_id": userId}).Where(squirrel.Lt{"last_counter": counter}).RunWith(runner{db.db}).Exec()
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n == 1, err
+}
+
+// DeleteTOTP turns off two factor logins of a user, removing their secret and recovery codes
+func (db *Store) DeleteTOTP(userId string) error {
+	tx, err := db.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = db.pq.Delete("recovery_codes").Where(squirrel.Eq{"user_id": userId}).RunWith(runner{tx}).Exec()
+	if err != nil {
+		return err
+	}
+	_, err = db.pq.Delete("totp").Where(squirrel.Eq{"user_id": userId}).RunWith(runner{tx}).Exec()
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// SetRecoveryCodes replaces the recovery codes of a user with the given hashes
+func (db *Store) SetRecoveryCodes(userId string, hashes []string) error {
+	tx, err := db.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = db.pq.Delete("recovery_codes").Where(squirrel.Eq{"user_id": userId}).RunWith(runner{tx}).Exec()
+	if err != nil {
+		return err
+	}
+	for _, hash := range hashes {
+		_, err = db.pq.Insert("recovery_codes").Columns("user_id", "hash").Values(userId, hash).RunWith(runner{tx}).Exec()
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// UseRecoveryCode marks the unused recovery code of a user with the hash as used, reporting if there was one
+func (db *Store) UseRecoveryCode(userId, hash string) (bool, error) {
+	res, err := db.pq.Update("recovery_codes").Set("used_at", squirrel.Expr("now()")).
+		Where(squirrel.Eq{"user_id": userId, "hash": hash, "used_at": nil}).RunWith(runner{db.db}).Exec()
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// CountRecoveryCodes counts the unused recovery codes of a user
+func (db *Store) CountRecoveryCodes(userId string) (int, error) {
+	var n int
+	err := db.pq.Select("count(*)").From("recovery_codes").Where(squirrel.Eq{"user_id": userId, "used_at": nil}).
+		RunWith(runner{db.db}).QueryRow().Scan(&n)
+	return n, err
+}
@@ -1,72 +1,151 @@
 package db
 
 import (
+	"time"
+
 	"github.com/Masterminds/squirrel"
 	"github.com/newtoallofthis123/noob_store/types"
 )
 
+var userColumns = []string{"id", "email", "password", "role", "issuer", "subject", "email_verified", "created_at"}
+
+func scanUser(row squirrel.RowScanner) (types.User, error) {
+	var user types.User
+
+	err := row.Scan(&user.Id, &user.Email, &user.Password, &user.Role, &user.Issuer, &user.Subject, &user.Verified, &user.CreatedAt)
+	if err != nil {
+		return types.User{}, err
+	}
+
+	return user, nil
+}
+
 // CreateUser inserts a user
 func (db *Store) CreateUser(user types.User) error {
-	_, err := db.pq.Insert("users").Columns("id", "email", "password").Values(user.Id, user.Email, user.Password).RunWith(db.db).Exec()
+	if user.Role == "" {
+		user.Role = types.UserRegular
+	}
+	_, err := db.pq.Insert("users").Columns("id", "email", "password", "role", "issuer", "subject", "email_verified").
+		Values(user.Id, user.Email, user.Password, user.Role, user.Issuer, user.Subject, user.Verified).RunWith(runner{db.db}).Exec()
 
 	return err
 }
 
 // GetUser gets a user from the table using the user id
 func (db *Store) GetUser(id string) (types.User, error) {
-	row := db.pq.Select("*").From("users").Where(squirrel.Eq{"id": id}).RunWith(db.db).QueryRow()
+	row := db.pq.Select(userColumns...).From("users").Where(squirrel.Eq{"id": id}).RunWith(runner{db.db}).QueryRow()
 
-	var user types.User
-
-	err := row.Scan(&user.Id, &user.Email, &user.Password, &user.CreatedAt)
-	if err != nil {
-		return types.User{}, err
-	}
-
-	return user, nil
+	return scanUser(row)
 }
 
-// GetUserByEmail gets a user from an email
+// GetUserByEmail gets a user from an email, ignoring its case
 func (db *Store) GetUserByEmail(email string) (types.User, error) {
-	row := db.pq.Select("*").From("users").Where(squirrel.Eq{"email": email}).RunWith(db.db).QueryRow()
+	row := db.pq.Select(userColumns...).From("users").Where("lower(email) = lower(?)", email).RunWith(runner{db.db}).QueryRow()
 
-	var user types.User
+	return scanUser(row)
+}
 
-	err := row.Scan(&user.Id, &user.Email, &user.Password, &user.CreatedAt)
-	if err != nil {
-		return types.User{}, err
-	}
+// GetUserBySubject gets the user provisioned for a subject of an identity provider
+func (db *Store) GetUserBySubject(issuer, subject string) (types.User, error) {
+	row := db.pq.Select(userColumns...).From("users").Where(squirrel.Eq{"issuer": issuer, "subject": subject}).RunWith(runner{db.db}).QueryRow()
 
-	return user, nil
+	return scanUser(row)
+}
+
+// LinkUserSubject ties an existing user to a subject of an identity provider
+func (db *Store) LinkUserSubject(id, issuer, subject string) error {
+	_, err := db.pq.Update("users").Set("issuer", issuer).Set("subject", subject).Where(squirrel.Eq{"id": id}).RunWith(runner{db.db}).Exec()
+	return err
 }
 
-// CreateSession inserts a session
-func (db *Store) CreateSession(session types.Session) error {
-	_, err := db.pq.Insert("sessions").Columns("id", "user_id").Values(session.Id, session.UserId).RunWith(db.db).Exec()
+// SetUserPassword changes the password hash of a user
+func (db *Store) SetUserPassword(id, password string) error {
+	_, err := db.pq.Update("users").Set("password", password).Where(squirrel.Eq{"id": id}).RunWith(runner{db.db}).Exec()
+	return err
+}
 
+// SetEmailVerified marks the email of a user as verified
+func (db *Store) SetEmailVerified(id string) error {
+	_, err := db.pq.Update("users").Set("email_verified", true).Where(squirrel.Eq{"id": id}).RunWith(runner{db.db}).Exec()
 	return err
 }
 
-// GetSession gets a session from the sessionId
-func (db *Store) GetSession(id string) (types.Session, error) {
-	row := db.pq.Select("*").From("sessions").Where(squirrel.Eq{"id": id}).RunWith(db.db).QueryRow()
+// CreateUserToken stores the hash of a single use token of a kind for a user
+func (db *Store) CreateUserToken(hash, userId, kind string, expiresAt time.Time) error {
+	_, err := db.pq.Insert("user_tokens").Columns("hash", "user_id", "kind", "expires_at").
+		Values(hash, userId, kind, expiresAt).RunWith(runner{db.db}).Exec()
+	return err
+}
 
-	var session types.Session
+// UseUserToken uses up the unexpired token of a kind with the hash, getting the user it is for
+func (db *Store) UseUserToken(hash, kind string, now time.Time) (string, error) {
+	var userId string
+	err := db.pq.Update("user_tokens").Set("used_at", now).
+		Where(squirrel.Eq{"hash": hash, "kind": kind, "used_at": nil}).Where(squirrel.Gt{"expires_at": now}).
+		Suffix("RETURNING user_id").RunWith(runner{db.db}).QueryRow().Scan(&userId)
+	return userId, err
+}
 
-	err := row.Scan(&session.Id, &session.UserId, &session.CreatedAt)
+// GetUsers gets all the users
+func (db *Store) GetUsers() ([]types.User, error) {
+	rows, err := db.pq.Select(userColumns...).From("users").OrderBy("email").RunWith(runner{db.db}).Query()
 	if err != nil {
-		return types.Session{}, err
+		return nil, err
+	}
+	defer rows.Close()
+
+	users := make([]types.User, 0)
+	for rows.Next() {
+		user, err := scanUser(rows)
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, user)
 	}
 
-	return session, nil
+	return users, nil
 }
 
-func (db *Store) DeleteUserById(id string) error {
-	_, err := db.pq.Delete("users").Where(squirrel.Eq{"id": id}).RunWith(db.db).Exec()
+// SetUserRole changes the role of a user
+func (db *Store) SetUserRole(id, role string) error {
+	_, err := db.pq.Update("users").Set("role", role).Where(squirrel.Eq{"id": id}).RunWith(runner{db.db}).Exec()
 	return err
 }
 
-func (db *Store) DeleteSessionById(id string) error {
-	_, err := db.pq.Delete("sessions").Where(squirrel.Eq{"id": id}).RunWith(db.db).Exec()
-	return err
+// DeleteUserById atomically deletes a user along with their files, trashed or not, and their sessions,
+// keys, grants, links, memberships, dirs and rules. The blobs of the files are marked as deleted.
+func (db *Store) DeleteUserById(id string) error {
+	tx, err := db.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = db.pq.Update("blobs").Set("deleted", true).
+		Where("id IN (SELECT blob FROM metadata WHERE user_id = ?)", id).RunWith(runner{tx}).Exec()
+	if err != nil {
+		return err
+	}
+
+	deletes := []squirrel.DeleteBuilder{
+		db.pq.Delete("metadata").Where(squirrel.Eq{"user_id": id}),
+		db.pq.Delete("sessions").Where(squirrel.Eq{"user_id": id}),
+		db.pq.Delete("api_keys").Where(squirrel.Eq{"user_id": id}),
+		db.pq.Delete("acls").Where(squirrel.Or{squirrel.Eq{"owner_id": id}, squirrel.Eq{"grantee_id": id}}),
+		db.pq.Delete("share_links").Where(squirrel.Eq{"user_id": id}),
+		db.pq.Delete("group_members").Where(squirrel.Eq{"user_id": id}),
+		db.pq.Delete("dirs").Where(squirrel.Eq{"user_id": id}),
+		db.pq.Delete("lifecycle_rules").Where(squirrel.Eq{"user_id": id}),
+		db.pq.Delete("quotas").Where(squirrel.Eq{"owner_id": id}),
+		db.pq.Delete("usage").Where(squirrel.Eq{"owner_id": id}),
+		db.pq.Delete("users").Where(squirrel.Eq{"id": id}),
+	}
+	for _, del := range deletes {
+		_, err = del.RunWith(runner{tx}).Exec()
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
 }
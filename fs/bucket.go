@@ -150,3 +150,13 @@ func GenerateBuckets(basePath string, n uint8) []string {
 func (b *Bucket) Name() string {
 	return b.file.Name()
 }
+
+// FileSize gets the size of the bucket file and if it is past the THRESHOLD
+func (b *Bucket) FileSize() (uint64, bool) {
+	stat, err := b.file.Stat()
+	if err != nil {
+		return 0, false
+	}
+
+	return uint64(stat.Size()), stat.Size() > THRESHOLD
+}
@@ -2,6 +2,7 @@ package fs
 
 import (
 	"fmt"
+	"io"
 	"log/slog"
 	"math/rand"
 	"mime"
@@ -178,17 +179,25 @@ func NewMetaData(fullPath string, userId string) types.Metadata {
 
 // fillBlob fills in the details of a blob
 func (h *Handler) fillBlob(blob *types.Blob) error {
-	b := h.buckets[blob.Bucket]
+	b, ok := h.buckets[blob.Bucket]
+	if !ok {
+		return types.Errorf(types.ErrNotFound, "no bucket %s for blob %s", blob.Bucket, blob.Id)
+	}
 
 	file, err := os.Open(b.path)
 	if err != nil {
 		h.logger.Error("Unable to open file: " + err.Error())
 		return err
 	}
+	defer file.Close()
 
 	buff := make([]byte, blob.Size)
 
 	n, err := file.ReadAt(buff, int64(blob.Start))
+	if err == io.EOF {
+		// The bucket ends before the blob does
+		return types.Errorf(types.ErrCorrupt, "blob %s is cut short in bucket %s", blob.Id, b.id)
+	}
 	if err != nil {
 		h.logger.Error("Error reading bucket: " + b.id + " with err: " + err.Error())
 		return err
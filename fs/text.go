@@ -0,0 +1,51 @@
+package fs
+
+import (
+	"strings"
+	"unicode/utf8"
+)
+
+// MaxIndexedText is the most bytes of text extracted from a blob for full text search
+const MaxIndexedText = 1024 * 1024
+
+// textMimes are the non text/* mime types that hold plain text
+var textMimes = map[string]bool{
+	"application/json":       true,
+	"application/xml":        true,
+	"application/javascript": true,
+	"application/x-yaml":     true,
+	"application/yaml":       true,
+	"application/toml":       true,
+	"application/x-sh":       true,
+	"application/sql":        true,
+	"application/x-ndjson":   true,
+}
+
+// IsTextMime checks if a mime type holds plain text content
+func IsTextMime(mime string) bool {
+	mime, _, _ = strings.Cut(mime, ";")
+	mime = strings.TrimSpace(strings.ToLower(mime))
+
+	return strings.HasPrefix(mime, "text/") || textMimes[mime] ||
+		strings.HasSuffix(mime, "+json") || strings.HasSuffix(mime, "+xml")
+}
+
+// ExtractText extracts up to MaxIndexedText of valid UTF-8 text from text like content
+func ExtractText(mime string, content []byte) (string, bool) {
+	if !IsTextMime(mime) {
+		return "", false
+	}
+
+	if len(content) > MaxIndexedText {
+		content = content[:MaxIndexedText]
+	}
+
+	text := strings.ToValidUTF8(string(content), "")
+	// Postgres text can not hold NUL bytes
+	text = strings.ReplaceAll(text, "\x00", "")
+	if !utf8.ValidString(text) || strings.TrimSpace(text) == "" {
+		return "", false
+	}
+
+	return text, true
+}
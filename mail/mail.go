@@ -0,0 +1,80 @@
+package mail
+
+import (
+	"fmt"
+	"log/slog"
+	"net/smtp"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/newtoallofthis123/noob_store/utils"
+)
+
+// Sender sends plain text emails
+type Sender interface {
+	Send(to, subject, body string) error
+}
+
+// NewSender gets the sender configured in the env, mails being logged when none is
+func NewSender(env *utils.Env, logger *slog.Logger) (Sender, error) {
+	switch env.MailSender {
+	case "", "log":
+		return LogSender{logger: logger}, nil
+	case "file":
+		err := os.MkdirAll(env.MailDir, 0o755)
+		if err != nil {
+			return nil, err
+		}
+		return FileSender{dir: env.MailDir, from: env.MailFrom}, nil
+	case "smtp":
+		return SMTPSender{addr: env.SMTPAddr, user: env.SMTPUser, pass: env.SMTPPass, from: env.MailFrom}, nil
+	}
+
+	return nil, fmt.Errorf("unknown mail sender %s", env.MailSender)
+}
+
+// LogSender writes mails to the log, for local use
+type LogSender struct {
+	logger *slog.Logger
+}
+
+func (l LogSender) Send(to, subject, body string) error {
+	l.logger.Info("Mail to " + to + ": " + subject + "\n" + body)
+	return nil
+}
+
+// FileSender writes each mail to its own .eml file in a dir, for local use and tests
+type FileSender struct {
+	dir  string
+	from string
+}
+
+func (f FileSender) Send(to, subject, body string) error {
+	name := fmt.Sprintf("%d-%s.eml", time.Now().UnixNano(), utils.RandomToken(4))
+	return os.WriteFile(filepath.Join(f.dir, name), message(f.from, to, subject, body), 0o644)
+}
+
+// SMTPSender sends mails through an SMTP server
+type SMTPSender struct {
+	addr string
+	user string
+	pass string
+	from string
+}
+
+func (s SMTPSender) Send(to, subject, body string) error {
+	var auth smtp.Auth
+	if s.user != "" {
+		host := strings.Split(s.addr, ":")[0]
+		auth = smtp.PlainAuth("", s.user, s.pass, host)
+	}
+
+	return smtp.SendMail(s.addr, auth, s.from, []string{to}, message(s.from, to, subject, body))
+}
+
+func message(from, to, subject, body string) []byte {
+	return []byte("From: " + from + "\r\nTo: " + to + "\r\nSubject: " + subject +
+		"\r\nContent-Type: text/plain; charset=utf-8\r\n\r\n" + body + "\r\n")
+}
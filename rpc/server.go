@@ -0,0 +1,350 @@
+// Package rpc serves the store over gRPC, on top of the same operations as the http api
+package rpc
+
+//go:generate protoc --go_out=pb --go_opt=paths=source_relative --go-grpc_out=pb --go-grpc_opt=paths=source_relative noob_store.proto
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"net"
+	"path/filepath"
+	"time"
+
+	"github.com/newtoallofthis123/noob_store/api"
+	"github.com/newtoallofthis123/noob_store/rpc/pb"
+	"github.com/newtoallofthis123/noob_store/types"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// chunkSize is how much content a download message carries
+const chunkSize = 64 << 10
+
+// Server implements the Store service with the operations of an api.Server
+type Server struct {
+	pb.UnimplementedStoreServer
+	store  *api.Server
+	logger *slog.Logger
+}
+
+func NewServer(store *api.Server, logger *slog.Logger) *Server {
+	return &Server{store: store, logger: logger}
+}
+
+// Serve listens on addr and serves the Store service until the listener fails
+func (s *Server) Serve(addr string) error {
+	lis, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+
+	srv := grpc.NewServer()
+	pb.RegisterStoreServer(srv, s)
+
+	s.logger.Info("Serving gRPC on " + addr)
+	return srv.Serve(lis)
+}
+
+// codeKinds maps the error kinds of types to the gRPC codes they are answered with,
+// like errorKinds does for http statuses
+var codeKinds = []struct {
+	kind error
+	code codes.Code
+}{
+	{types.ErrInvalid, codes.InvalidArgument},
+	{types.ErrUnauthorized, codes.Unauthenticated},
+	{types.ErrForbidden, codes.PermissionDenied},
+	{types.ErrNotFound, codes.NotFound},
+	{types.ErrConflict, codes.AlreadyExists},
+	{types.ErrLocked, codes.FailedPrecondition},
+	{types.ErrQuotaExceeded, codes.ResourceExhausted},
+	{types.ErrCorrupt, codes.DataLoss},
+}
+
+// toStatus turns an error of the store into a gRPC status error
+func (s *Server) toStatus(err error) error {
+	var locked *api.LockedOutError
+	if errors.As(err, &locked) {
+		return status.Error(codes.ResourceExhausted, err.Error()+", retry in "+locked.RetryAfter.Round(time.Second).String())
+	}
+	var limited *api.RateLimitedError
+	if errors.As(err, &limited) {
+		return status.Error(codes.ResourceExhausted, err.Error()+", retry in "+limited.RetryAfter.Round(time.Second).String())
+	}
+
+	for _, k := range codeKinds {
+		if errors.Is(err, k.kind) {
+			return status.Error(k.code, err.Error())
+		}
+	}
+
+	s.logger.Error("Internal error in gRPC call with err: " + err.Error())
+	return status.Error(codes.Internal, err.Error())
+}
+
+// session authenticates the authorization metadata of a call
+func (s *Server) session(ctx context.Context) (types.Session, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	values := md.Get("authorization")
+	if len(values) == 0 {
+		return types.Session{}, status.Error(codes.Unauthenticated, "authorization metadata is needed")
+	}
+
+	session, err := s.store.Authenticate(values[0])
+	if err != nil {
+		return types.Session{}, s.toStatus(err)
+	}
+	return session, nil
+}
+
+// clientInfo gets the address and user agent of the caller for the sessions it logs into
+func clientInfo(ctx context.Context) api.ClientInfo {
+	var client api.ClientInfo
+	if p, ok := peer.FromContext(ctx); ok {
+		if host, _, err := net.SplitHostPort(p.Addr.String()); err == nil {
+			client.Ip = host
+		}
+	}
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if ua := md.Get("user-agent"); len(ua) > 0 {
+			client.UserAgent = ua[0]
+		}
+	}
+	return client
+}
+
+func (s *Server) Login(ctx context.Context, req *pb.LoginRequest) (*pb.LoginResponse, error) {
+	session, challenge, err := s.store.Login(clientInfo(ctx), req.Email, req.Password)
+	if err != nil {
+		return nil, s.toStatus(err)
+	}
+	if challenge != "" {
+		return &pb.LoginResponse{Challenge: challenge}, nil
+	}
+	return &pb.LoginResponse{Session: toSession(session)}, nil
+}
+
+func (s *Server) LoginTwoFactor(ctx context.Context, req *pb.LoginTwoFactorRequest) (*pb.Session, error) {
+	session, err := s.store.LoginTwoFactor(clientInfo(ctx), req.Challenge, req.Code, req.RecoveryCode)
+	if err != nil {
+		return nil, s.toStatus(err)
+	}
+	return toSession(session), nil
+}
+
+func (s *Server) Upload(stream pb.Store_UploadServer) error {
+	session, err := s.session(stream.Context())
+	if err != nil {
+		return err
+	}
+
+	first, err := stream.Recv()
+	if err != nil && err != io.EOF {
+		return err
+	}
+	info := first.GetInfo()
+	if info == nil {
+		return status.Error(codes.InvalidArgument, "the first message of an upload must hold its info")
+	}
+	upload := api.UploadInfo{
+		Path:     info.Path,
+		Group:    info.Group,
+		UserMeta: info.UserMeta,
+		Tags:     info.Tags,
+	}
+
+	// The content is checked against the max upload size and the quota as it comes in,
+	// so that an upload too large is turned away without buffering all of it
+	limit, err := s.store.UploadLimit(session, upload)
+	if err != nil {
+		return s.toStatus(err)
+	}
+	var content bytes.Buffer
+	for {
+		req, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if req.GetInfo() != nil {
+			return status.Error(codes.InvalidArgument, "an upload can only hold one info")
+		}
+		if int64(content.Len()+len(req.GetChunk())) > limit {
+			return status.Errorf(codes.ResourceExhausted, "upload is larger than the %d bytes left for it", limit)
+		}
+		content.Write(req.GetChunk())
+	}
+
+	meta, err := s.store.Upload(session, upload, content.Bytes())
+	if err != nil {
+		return s.toStatus(err)
+	}
+
+	return stream.SendAndClose(toMetadata(meta))
+}
+
+func (s *Server) Download(req *pb.DownloadRequest, stream pb.Store_DownloadServer) error {
+	session, err := s.session(stream.Context())
+	if err != nil {
+		return err
+	}
+
+	meta, content, err := s.store.Download(session, req.Id)
+	if err != nil {
+		return s.toStatus(err)
+	}
+
+	err = stream.Send(&pb.DownloadResponse{Data: &pb.DownloadResponse_Metadata{Metadata: toMetadata(meta)}})
+	if err != nil {
+		return err
+	}
+	for len(content) > 0 {
+		n := min(chunkSize, len(content))
+		err = stream.Send(&pb.DownloadResponse{Data: &pb.DownloadResponse_Chunk{Chunk: content[:n]}})
+		if err != nil {
+			return err
+		}
+		content = content[n:]
+	}
+
+	return nil
+}
+
+func (s *Server) Info(ctx context.Context, req *pb.InfoRequest) (*pb.Metadata, error) {
+	session, err := s.session(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	meta, err := s.store.Info(session, req.Id)
+	if err != nil {
+		return nil, s.toStatus(err)
+	}
+	return toMetadata(meta), nil
+}
+
+func (s *Server) Stat(ctx context.Context, req *pb.StatRequest) (*pb.StatResponse, error) {
+	session, err := s.session(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	path := req.Path
+	if path == "" {
+		path = "."
+	}
+	res, err := s.store.Stat(session, path)
+	if err != nil {
+		return nil, s.toStatus(err)
+	}
+
+	if res.File != nil {
+		return &pb.StatResponse{Entry: &pb.StatResponse_File{File: toMetadata(*res.File)}}, nil
+	}
+	return &pb.StatResponse{Entry: &pb.StatResponse_Dir{Dir: toDir(*res.Dir)}}, nil
+}
+
+func (s *Server) List(ctx context.Context, req *pb.ListRequest) (*pb.ListResponse, error) {
+	session, err := s.session(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if req.Limit < 0 {
+		return nil, status.Error(codes.InvalidArgument, "limit must be a positive number")
+	}
+
+	opts := types.ListOptions{
+		Limit:     int(req.Limit),
+		Cursor:    req.Cursor,
+		Sort:      req.Sort,
+		Desc:      req.Desc,
+		Prefix:    req.Prefix,
+		Delimiter: req.Delimiter,
+		Mime:      req.Mime,
+		Tags:      req.Tags,
+		Shared:    req.Shared,
+	}
+	if opts.Sort == "" {
+		opts.Sort = "name"
+	}
+	if req.Dir != "" {
+		opts.Dir = filepath.Clean(req.Dir)
+	}
+
+	res, err := s.store.List(session, opts)
+	if err != nil {
+		return nil, s.toStatus(err)
+	}
+
+	items := make([]*pb.Metadata, 0, len(res.Items))
+	for _, meta := range res.Items {
+		items = append(items, toMetadata(meta))
+	}
+	return &pb.ListResponse{Items: items, CommonPrefixes: res.CommonPrefixes, NextCursor: res.NextCursor}, nil
+}
+
+func (s *Server) Delete(ctx context.Context, req *pb.DeleteRequest) (*pb.DeleteResponse, error) {
+	session, err := s.session(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.store.Delete(session, req.Id, req.BypassGovernance)
+	if err != nil {
+		return nil, s.toStatus(err)
+	}
+	return &pb.DeleteResponse{}, nil
+}
+
+func toSession(session types.Session) *pb.Session {
+	return &pb.Session{
+		Id:         session.Id,
+		UserId:     session.UserId,
+		Ip:         session.Ip,
+		UserAgent:  session.UserAgent,
+		ExpiresAt:  session.ExpiresAt,
+		LastSeenAt: session.LastSeenAt,
+		CreatedAt:  session.CreatedAt,
+	}
+}
+
+func toMetadata(meta types.Metadata) *pb.Metadata {
+	return &pb.Metadata{
+		Id:            meta.Id,
+		Name:          meta.Name,
+		Path:          meta.Path,
+		Parent:        meta.Parent,
+		Mime:          meta.Mime,
+		UserId:        meta.UserId,
+		Blob:          meta.Blob,
+		CreatedAt:     meta.CreatedAt,
+		RetentionMode: meta.RetentionMode,
+		RetainUntil:   meta.RetainUntil,
+		LegalHold:     meta.LegalHold,
+		UserMeta:      meta.UserMeta,
+		Tags:          meta.Tags,
+		Size:          meta.Size,
+	}
+}
+
+func toDir(dir types.Dir) *pb.Dir {
+	return &pb.Dir{
+		Id:        dir.Id,
+		UserId:    dir.UserId,
+		Name:      dir.Name,
+		Parent:    dir.Parent,
+		Path:      dir.Path,
+		CreatedAt: dir.CreatedAt,
+		Files:     int64(dir.Files),
+		Dirs:      int64(dir.Dirs),
+		Size:      dir.Size,
+	}
+}
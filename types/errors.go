@@ -0,0 +1,45 @@
+package types
+
+import (
+	"errors"
+	"fmt"
+)
+
+// The kinds of errors the db, fs and cache layers return, matched with errors.Is
+// to tell a missing file from a broken one
+var (
+	ErrNotFound      = errors.New("not found")
+	ErrConflict      = errors.New("conflict")
+	ErrInvalid       = errors.New("invalid")
+	ErrUnauthorized  = errors.New("unauthorized")
+	ErrForbidden     = errors.New("forbidden")
+	ErrQuotaExceeded = errors.New("quota exceeded")
+	ErrLocked        = errors.New("locked")
+	ErrCorrupt       = errors.New("corrupt")
+)
+
+// ErrorRes is the body of every error response of the api
+type ErrorRes struct {
+	Err       string `json:"err"`
+	Code      string `json:"code"`
+	RequestId string `json:"request_id"`
+}
+
+// kindError is an error of one of the kinds above that keeps its own message
+type kindError struct {
+	kind error
+	err  error
+}
+
+func (e kindError) Error() string {
+	return e.err.Error()
+}
+
+func (e kindError) Unwrap() []error {
+	return []error{e.kind, e.err}
+}
+
+// Errorf formats an error like fmt.Errorf that also matches kind
+func Errorf(kind error, format string, args ...any) error {
+	return kindError{kind: kind, err: fmt.Errorf(format, args...)}
+}
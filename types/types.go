@@ -1,5 +1,10 @@
 package types
 
+import (
+	"strings"
+	"time"
+)
+
 // Blob represents an object in the store
 type Blob struct {
 	Id        string `json:"id,omitempty"`
@@ -43,19 +48,312 @@ type Metadata struct {
 	UserId    string `json:"user_id,omitempty"`
 	Blob      string `json:"blob,omitempty"`
 	CreatedAt string `json:"created_at,omitempty"`
+	TrashedAt string `json:"trashed_at,omitempty"`
+
+	RetentionMode string `json:"retention_mode,omitempty"`
+	RetainUntil   string `json:"retain_until,omitempty"`
+	LegalHold     bool   `json:"legal_hold,omitempty"`
+
+	UserMeta map[string]string `json:"user_meta,omitempty"`
+	Tags     map[string]string `json:"tags,omitempty"`
+
+	// Size is only filled in by listings
+	Size uint64 `json:"size,omitempty"`
+}
+
+const (
+	// RetentionGovernance retention can be shortened or removed by the owner with an explicit bypass
+	RetentionGovernance = "governance"
+	// RetentionCompliance retention can not be shortened or removed by anyone until it expires
+	RetentionCompliance = "compliance"
+)
+
+// Retained checks if the metadata is under an active retention period at the given time
+func (m Metadata) Retained(now time.Time) bool {
+	if m.RetentionMode == "" || m.RetainUntil == "" {
+		return false
+	}
+	until, err := time.Parse(time.RFC3339Nano, m.RetainUntil)
+	if err != nil {
+		// An unreadable retain until date must never make an object deletable
+		return true
+	}
+	return now.Before(until)
+}
+
+// Locked checks if the metadata is protected from deletion at the given time.
+// Governance retention is lifted when bypassGovernance is set, compliance retention and legal holds never are.
+func (m Metadata) Locked(now time.Time, bypassGovernance bool) bool {
+	if m.LegalHold {
+		return true
+	}
+	if !m.Retained(now) {
+		return false
+	}
+	return m.RetentionMode == RetentionCompliance || !bypassGovernance
+}
+
+// ListOptions represents the pagination, sorting and filtering of a listing
+type ListOptions struct {
+	Limit     int
+	Cursor    string
+	Sort      string
+	Desc      bool
+	Dir       string
+	Prefix    string
+	Delimiter string
+	Mime      string
+	Tags      map[string]string
+	// Shared also lists the files shared with the user
+	Shared  bool
+	MinSize *uint64
+	MaxSize *uint64
+	After   *time.Time
+	Before  *time.Time
+}
+
+// ListRes represents a page of a listing
+type ListRes struct {
+	Items          []Metadata `json:"items"`
+	CommonPrefixes []string   `json:"common_prefixes,omitempty"`
+	NextCursor     string     `json:"next_cursor,omitempty"`
+}
+
+// ContentMatch represents a file matching a full text search
+type ContentMatch struct {
+	File    Metadata `json:"file"`
+	Snippet string   `json:"snippet"`
+	Rank    float64  `json:"rank"`
+}
+
+// Dir represents a directory of a user
+type Dir struct {
+	Id        string `json:"id,omitempty"`
+	UserId    string `json:"user_id,omitempty"`
+	Name      string `json:"name,omitempty"`
+	Parent    string `json:"parent,omitempty"`
+	Path      string `json:"path,omitempty"`
+	CreatedAt string `json:"created_at,omitempty"`
+	Files     int    `json:"files"`
+	Dirs      int    `json:"dirs"`
+	Size      uint64 `json:"size"`
+}
+
+const (
+	PermRead  = "read"
+	PermWrite = "write"
+	PermOwner = "owner"
+)
+
+var permLevels = map[string]int{PermRead: 1, PermWrite: 2, PermOwner: 3}
+
+// ValidPermission checks if p is one of the permissions
+func ValidPermission(p string) bool {
+	return permLevels[p] > 0
+}
+
+// PermissionAllows checks if the granted permission includes the needed one
+func PermissionAllows(granted, needed string) bool {
+	return permLevels[granted] > 0 && permLevels[granted] >= permLevels[needed]
+}
+
+// Grant represents a permission on a file or dir, and everything under it, given by its owner to another user or a group
+type Grant struct {
+	Id         string `json:"id,omitempty"`
+	OwnerId    string `json:"owner_id,omitempty"`
+	Path       string `json:"path,omitempty"`
+	GranteeId  string `json:"grantee_id,omitempty"`
+	Permission string `json:"permission,omitempty"`
+	CreatedAt  string `json:"created_at,omitempty"`
+}
+
+// ShareLink represents a public link to download a file
+type ShareLink struct {
+	Id           string `json:"id,omitempty"`
+	MetadataId   string `json:"metadata_id,omitempty"`
+	UserId       string `json:"user_id,omitempty"`
+	Password     string `json:"-"`
+	HasPassword  bool   `json:"has_password"`
+	ExpiresAt    string `json:"expires_at,omitempty"`
+	MaxDownloads int    `json:"max_downloads"`
+	Downloads    int    `json:"downloads"`
+	Revoked      bool   `json:"revoked"`
+	CreatedAt    string `json:"created_at,omitempty"`
+}
+
+// LinkEvent represents an attempt to use a share link
+type LinkEvent struct {
+	Id        int64  `json:"id"`
+	LinkId    string `json:"link_id,omitempty"`
+	Ip        string `json:"ip,omitempty"`
+	UserAgent string `json:"user_agent,omitempty"`
+	Ok        bool   `json:"ok"`
+	Reason    string `json:"reason,omitempty"`
+	CreatedAt string `json:"created_at,omitempty"`
+}
+
+const (
+	GroupOrg  = "org"
+	GroupTeam = "team"
+
+	RoleOwner  = "owner"
+	RoleAdmin  = "admin"
+	RoleMember = "member"
+	RoleViewer = "viewer"
+)
+
+// rolePerms maps the membership roles of a group to their permission on the group's storage
+var rolePerms = map[string]string{RoleOwner: PermOwner, RoleAdmin: PermOwner, RoleMember: PermWrite, RoleViewer: PermRead}
+
+// ValidRole checks if r is one of the membership roles
+func ValidRole(r string) bool {
+	return rolePerms[r] != ""
+}
+
+// RolePermission gets the permission a membership role has on the storage of its group
+func RolePermission(role string) string {
+	return rolePerms[role]
+}
+
+// Group represents an organisation or a team, which can own storage like a user.
+// Group ids are prefixed with g_ so they never collide with user ids.
+type Group struct {
+	Id        string `json:"id,omitempty"`
+	Name      string `json:"name,omitempty"`
+	Kind      string `json:"kind,omitempty"`
+	OrgId     string `json:"org_id,omitempty"`
+	Role      string `json:"role,omitempty"`
+	CreatedAt string `json:"created_at,omitempty"`
 }
 
-// User represents a user
+// Member represents the membership of a user in a group
+type Member struct {
+	GroupId   string `json:"group_id,omitempty"`
+	UserId    string `json:"user_id,omitempty"`
+	Email     string `json:"email,omitempty"`
+	Role      string `json:"role,omitempty"`
+	CreatedAt string `json:"created_at,omitempty"`
+}
+
+// Quota represents the storage limits of an owner, zero meaning unlimited
+type Quota struct {
+	OwnerId    string `json:"owner_id,omitempty"`
+	MaxBytes   int64  `json:"max_bytes"`
+	MaxObjects int64  `json:"max_objects"`
+}
+
+// Usage represents the storage used by an owner
+type Usage struct {
+	OwnerId string `json:"owner_id,omitempty"`
+	Bytes   int64  `json:"bytes"`
+	Objects int64  `json:"objects"`
+}
+
+// User represents a user. Issuer and Subject identify users provisioned from an identity provider.
 type User struct {
 	Id        string `json:"id,omitempty"`
 	Email     string `json:"email,omitempty"`
 	Password  string `json:"password,omitempty"`
+	Role      string `json:"role,omitempty"`
+	Issuer    string `json:"issuer,omitempty"`
+	Subject   string `json:"subject,omitempty"`
+	Verified  bool   `json:"email_verified"`
 	CreatedAt string `json:"created_at,omitempty"`
 }
 
+const (
+	TokenReset  = "reset"
+	TokenVerify = "verify"
+)
+
+const (
+	UserAdmin    = "admin"
+	UserRegular  = "user"
+	UserReadOnly = "read_only"
+)
+
+// ValidUserRole checks if r is one of the roles of a user
+func ValidUserRole(r string) bool {
+	return r == UserAdmin || r == UserRegular || r == UserReadOnly
+}
+
+// AuditEntry records an administrative action
+type AuditEntry struct {
+	Id        int64  `json:"id,omitempty"`
+	ActorId   string `json:"actor_id,omitempty"`
+	Action    string `json:"action,omitempty"`
+	Target    string `json:"target,omitempty"`
+	Detail    string `json:"detail,omitempty"`
+	Ip        string `json:"ip,omitempty"`
+	CreatedAt string `json:"created_at,omitempty"`
+}
+
+// BucketStat represents the usage of a bucket file by its blobs
+type BucketStat struct {
+	Id           string `json:"id,omitempty"`
+	FileSize     uint64 `json:"file_size"`
+	Filled       bool   `json:"filled"`
+	Blobs        int64  `json:"blobs"`
+	LiveBytes    uint64 `json:"live_bytes"`
+	DeletedBytes uint64 `json:"deleted_bytes"`
+}
+
 // Session represents an authenticated session
 type Session struct {
-	Id        string `json:"id,omitempty"`
-	UserId    string `json:"user_id,omitempty"`
-	CreatedAt string `json:"created_at,omitempty"`
+	Id         string `json:"id,omitempty"`
+	UserId     string `json:"user_id,omitempty"`
+	Ip         string `json:"ip,omitempty"`
+	UserAgent  string `json:"user_agent,omitempty"`
+	ExpiresAt  string `json:"expires_at,omitempty"`
+	LastSeenAt string `json:"last_seen_at,omitempty"`
+	Current    bool   `json:"current,omitempty"`
+	CreatedAt  string `json:"created_at,omitempty"`
+	// KeyId, Scope and PathPrefix are set when the request authenticated with an api key
+	KeyId      string `json:"key_id,omitempty"`
+	Scope      string `json:"scope,omitempty"`
+	PathPrefix string `json:"path_prefix,omitempty"`
+}
+
+// AllowsPath checks if the session can reach a path, which is anything
+// unless it comes from an api key limited to a path prefix
+func (s Session) AllowsPath(path string) bool {
+	if s.PathPrefix == "" {
+		return true
+	}
+	return path == s.PathPrefix || strings.HasPrefix(path, s.PathPrefix+"/")
+}
+
+// TOTP is the time based one time password secret of a user, only required at login once enabled
+type TOTP struct {
+	UserId      string `json:"user_id,omitempty"`
+	Secret      string `json:"-"`
+	Enabled     bool   `json:"enabled"`
+	LastCounter int64  `json:"-"`
+	CreatedAt   string `json:"created_at,omitempty"`
+}
+
+// ApiKey is a long lived credential of a user for machines. Only the hash of its token is stored,
+// the token itself being returned once when the key is created.
+type ApiKey struct {
+	Id         string `json:"id,omitempty"`
+	UserId     string `json:"user_id,omitempty"`
+	Name       string `json:"name,omitempty"`
+	Token      string `json:"token,omitempty"`
+	Hash       string `json:"-"`
+	Scope      string `json:"scope,omitempty"`
+	PathPrefix string `json:"path_prefix,omitempty"`
+	ExpiresAt  string `json:"expires_at,omitempty"`
+	LastUsedAt string `json:"last_used_at,omitempty"`
+	CreatedAt  string `json:"created_at,omitempty"`
+}
+
+// LifecycleRule expires the objects of a user matching a prefix and bucket after some days
+type LifecycleRule struct {
+	Id         string            `json:"id,omitempty"`
+	UserId     string            `json:"user_id,omitempty"`
+	Prefix     string            `json:"prefix,omitempty"`
+	Bucket     string            `json:"bucket,omitempty"`
+	Tags       map[string]string `json:"tags,omitempty"`
+	ExpireDays int               `json:"expire_days,omitempty"`
+	CreatedAt  string            `json:"created_at,omitempty"`
 }
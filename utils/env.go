@@ -3,15 +3,51 @@ package utils
 import (
 	"fmt"
 	"os"
+	"strconv"
+	"time"
 
 	"github.com/joho/godotenv"
 )
 
 type Env struct {
-	ConnString string
-	ListenAddr string
-	BucketPath string
-	CacheConn  string
+	ConnString     string
+	ListenAddr     string
+	GrpcAddr       string
+	BucketPath     string
+	CacheConn      string
+	TrashRetention time.Duration
+	MaxRetention   time.Duration
+	QuotaBytes     uint64
+	QuotaObjects   uint64
+	MaxUpload      uint64
+	SigningKey     string
+	AdminEmail     string
+	SessionTTL     time.Duration
+	SessionIdle    time.Duration
+	LocalLogin     bool
+	JwksFile       string
+	JwtIssuer      string
+	JwtAudience    string
+	OIDCIssuer     string
+	OIDCClientId   string
+	OIDCSecret     string
+	OIDCRedirect   string
+	RoleClaim      string
+	GroupsClaim    string
+	PublicURL      string
+	MailSender     string
+	MailDir        string
+	MailFrom       string
+	SMTPAddr       string
+	SMTPUser       string
+	SMTPPass       string
+	BcryptCost     int
+	AuthRateLimit  Rate
+	ApiRateLimit   Rate
+	LockoutLimit   int
+	LockoutPeriod  time.Duration
+	UploadRate     uint64
+	DownloadRate   uint64
 }
 
 // Reads the .env file and returns an Env struct.
@@ -23,10 +59,44 @@ func ReadEnv() Env {
 	}
 
 	return Env{
-		ConnString: constructDbString(),
-		ListenAddr: getEnv("LISTEN_ADDR"),
-		BucketPath: getEnv("BUCKET_PATH"),
-		CacheConn:  getEnv("CACHE_CONN"),
+		ConnString:     constructDbString(),
+		ListenAddr:     getEnv("LISTEN_ADDR"),
+		GrpcAddr:       getEnvOr("GRPC_ADDR", ""),
+		BucketPath:     getEnv("BUCKET_PATH"),
+		CacheConn:      getEnv("CACHE_CONN"),
+		TrashRetention: getEnvDuration("TRASH_RETENTION", 30*24*time.Hour),
+		MaxRetention:   getEnvDuration("MAX_RETENTION", 10*365*24*time.Hour),
+		QuotaBytes:     getEnvSize("DEFAULT_QUOTA_BYTES", 0),
+		QuotaObjects:   uint64(max(getEnvInt("DEFAULT_QUOTA_OBJECTS", 0), 0)),
+		MaxUpload:      getEnvSize("MAX_UPLOAD_SIZE", 1<<30),
+		SigningKey:     getEnvOr("SIGNING_KEY", ""),
+		AdminEmail:     getEnvOr("ADMIN_EMAIL", ""),
+		SessionTTL:     getEnvDuration("SESSION_TTL", 30*24*time.Hour),
+		SessionIdle:    getEnvDuration("SESSION_IDLE_TIMEOUT", 7*24*time.Hour),
+		LocalLogin:     getEnvOr("LOCAL_LOGIN", "true") == "true",
+		JwksFile:       getEnvOr("JWT_JWKS_FILE", ""),
+		JwtIssuer:      getEnvOr("JWT_ISSUER", ""),
+		JwtAudience:    getEnvOr("JWT_AUDIENCE", ""),
+		OIDCIssuer:     getEnvOr("OIDC_ISSUER", ""),
+		OIDCClientId:   getEnvOr("OIDC_CLIENT_ID", ""),
+		OIDCSecret:     getEnvOr("OIDC_CLIENT_SECRET", ""),
+		OIDCRedirect:   getEnvOr("OIDC_REDIRECT_URL", ""),
+		RoleClaim:      getEnvOr("AUTH_ROLE_CLAIM", "roles"),
+		GroupsClaim:    getEnvOr("AUTH_GROUPS_CLAIM", "groups"),
+		PublicURL:      getEnvOr("PUBLIC_URL", "http://localhost:6969"),
+		MailSender:     getEnvOr("MAIL_SENDER", "log"),
+		MailDir:        getEnvOr("MAIL_DIR", "mail"),
+		MailFrom:       getEnvOr("MAIL_FROM", "noob_store@localhost"),
+		SMTPAddr:       getEnvOr("SMTP_ADDR", ""),
+		SMTPUser:       getEnvOr("SMTP_USER", ""),
+		SMTPPass:       getEnvOr("SMTP_PASS", ""),
+		BcryptCost:     getEnvInt("BCRYPT_COST", 12),
+		AuthRateLimit:  getEnvRate("AUTH_RATE_LIMIT", "10/1m"),
+		ApiRateLimit:   getEnvRate("API_RATE_LIMIT", "600/1m"),
+		LockoutLimit:   getEnvInt("LOCKOUT_THRESHOLD", 5),
+		LockoutPeriod:  getEnvDuration("LOCKOUT_DURATION", 15*time.Minute),
+		UploadRate:     getEnvSize("UPLOAD_BANDWIDTH", 0),
+		DownloadRate:   getEnvSize("DOWNLOAD_BANDWIDTH", 0),
 	}
 }
 
@@ -42,3 +112,64 @@ func getEnv(name string) string {
 	}
 	return val
 }
+
+// Returns the value of the given env var name or the fallback if it is not set.
+func getEnvOr(name string, fallback string) string {
+	val, ok := os.LookupEnv(name)
+	if !ok {
+		return fallback
+	}
+	return val
+}
+
+// Returns the given env var parsed as a duration or the fallback if it is not set.
+func getEnvDuration(name string, fallback time.Duration) time.Duration {
+	val := getEnvOr(name, "")
+	if val == "" {
+		return fallback
+	}
+	d, err := time.ParseDuration(val)
+	if err != nil {
+		panic(fmt.Sprintf("Env var %s is not a valid duration: %s", name, err.Error()))
+	}
+	return d
+}
+
+// Returns the given env var parsed as a size like 10GB or the fallback if it is not set.
+func getEnvSize(name string, fallback uint64) uint64 {
+	val := getEnvOr(name, "")
+	if val == "" {
+		return fallback
+	}
+	n, err := ParseSize(val)
+	if err != nil {
+		panic(fmt.Sprintf("Env var %s is not a valid size: %s", name, err.Error()))
+	}
+	return n
+}
+
+// Returns the given env var parsed as an int or the fallback if it is not set.
+func getEnvInt(name string, fallback int) int {
+	val := getEnvOr(name, "")
+	if val == "" {
+		return fallback
+	}
+	n, err := strconv.Atoi(val)
+	if err != nil {
+		panic(fmt.Sprintf("Env var %s is not a valid number: %s", name, err.Error()))
+	}
+	return n
+}
+
+// Returns the given env var parsed as a rate like 10/1m or the fallback, an empty value turning the limit off.
+func getEnvRate(name string, fallback string) Rate {
+	val := getEnvOr(name, fallback)
+	if val == "" {
+		return Rate{}
+	}
+	rate, err := ParseRate(val)
+	if err != nil {
+		panic(fmt.Sprintf("Env var %s is not a valid rate: %s", name, err.Error()))
+	}
+	return rate
+}
@@ -1,12 +1,80 @@
 package utils
 
 import (
+	"crypto/rand"
 	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
 )
 
+// RandomToken returns a hex encoded token of n cryptographically random bytes
+func RandomToken(n int) string {
+	b := make([]byte, n)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
 func CalHash(content []byte) string {
 	hash := sha256.New()
 	hash.Write(content)
 	return fmt.Sprintf("%x", hash.Sum(nil))
 }
+
+// sizeUnits are the multipliers of the size units
+var sizeUnits = map[string]uint64{
+	"":   1,
+	"B":  1,
+	"KB": 1 << 10,
+	"MB": 1 << 20,
+	"GB": 1 << 30,
+	"TB": 1 << 40,
+}
+
+// ParseSize parses a size like 10MB into bytes
+func ParseSize(s string) (uint64, error) {
+	s = strings.ToUpper(strings.TrimSpace(s))
+	i := strings.IndexFunc(s, func(r rune) bool { return !unicode.IsDigit(r) && r != '.' })
+	num, unit := s, ""
+	if i >= 0 {
+		num, unit = s[:i], s[i:]
+	}
+
+	mult, ok := sizeUnits[unit]
+	if !ok {
+		return 0, fmt.Errorf("unknown size unit: %s", unit)
+	}
+	n, err := strconv.ParseFloat(num, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size: %s", s)
+	}
+
+	return uint64(n * float64(mult)), nil
+}
+
+// Rate is a token bucket limit of Burst requests refilled at PerSecond, zero meaning unlimited
+type Rate struct {
+	PerSecond float64
+	Burst     float64
+}
+
+// ParseRate parses a rate like 10/1m, allowing 10 requests a minute in bursts of up to 10
+func ParseRate(s string) (Rate, error) {
+	count, period, found := strings.Cut(strings.TrimSpace(s), "/")
+	if !found {
+		return Rate{}, fmt.Errorf("rate must look like 10/1m: %s", s)
+	}
+	n, err := strconv.ParseFloat(count, 64)
+	if err != nil || n < 0 {
+		return Rate{}, fmt.Errorf("invalid rate count: %s", count)
+	}
+	d, err := time.ParseDuration(period)
+	if err != nil || d <= 0 {
+		return Rate{}, fmt.Errorf("invalid rate period: %s", period)
+	}
+
+	return Rate{PerSecond: n / d.Seconds(), Burst: n}, nil
+}